@@ -19,6 +19,7 @@ package clickdeb
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
 	"errors"
@@ -34,6 +35,7 @@ import (
 
 	"launchpad.net/snappy/helpers"
 
+	"code.google.com/p/go.crypto/openpgp"
 	"github.com/blakesmith/ar"
 )
 
@@ -41,8 +43,15 @@ var (
 	// ErrSnapInvalidContent is returned if a snap package contains
 	// invalid content
 	ErrSnapInvalidContent = errors.New("snap contains invalid content")
+
+	// ErrSnapNotSigned is returned if a snap package has no signature
+	ErrSnapNotSigned = errors.New("snap is not signed")
 )
 
+// the ar member with the detached signature of the package, see
+// debsigs(1)
+const gpgOriginMember = "_gpgorigin"
+
 // simple pipe based xz reader
 func xzPipeReader(r io.Reader) io.Reader {
 	pr, pw := io.Pipe()
@@ -395,6 +404,90 @@ func (d *ClickDeb) Build(sourceDir string, dataTarFinishedCallback func(dataName
 	return nil
 }
 
+// signedArMember returns true if the given ar member is covered by the
+// signature of the package
+func signedArMember(name string) bool {
+	return strings.HasPrefix(name, "debian-binary") ||
+		strings.HasPrefix(name, "control.tar") ||
+		strings.HasPrefix(name, "data.tar")
+}
+
+// SignedContent writes the content that is signed by the debsigs style
+// signature of the package to w, this is the content of the
+// debian-binary, control.tar and data.tar ar members
+func (d *ClickDeb) SignedContent(w io.Writer) error {
+	file, err := os.Open(d.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	arReader := ar.NewReader(file)
+	for {
+		header, err := arReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !signedArMember(header.Name) {
+			continue
+		}
+		if _, err := io.Copy(w, arReader); err != nil {
+			return err
+		}
+	}
+}
+
+// Signature returns the detached signature of the package or
+// ErrSnapNotSigned if it has none
+func (d *ClickDeb) Signature() ([]byte, error) {
+	file, err := os.Open(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	arReader := ar.NewReader(file)
+	for {
+		header, err := arReader.Next()
+		if err == io.EOF {
+			return nil, ErrSnapNotSigned
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(header.Name, gpgOriginMember) {
+			return ioutil.ReadAll(arReader)
+		}
+	}
+}
+
+// VerifySignature checks the signature of the package against the keys
+// in the given keyring and returns the key that made it
+func (d *ClickDeb) VerifySignature(keyring openpgp.KeyRing) (*openpgp.Entity, error) {
+	sig, err := d.Signature()
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(d.SignedContent(pw))
+	}()
+	// unblock the writer if the check stops reading early
+	defer pr.Close()
+
+	// debsigs creates binary signatures but armored ones are
+	// easier to handle by hand
+	if bytes.HasPrefix(sig, []byte("-----BEGIN PGP SIGNATURE-----")) {
+		return openpgp.CheckArmoredDetachedSignature(keyring, pr, bytes.NewReader(sig))
+	}
+
+	return openpgp.CheckDetachedSignature(keyring, pr, bytes.NewReader(sig))
+}
+
 func skipToArMember(arReader *ar.Reader, memberPrefix string) (io.Reader, error) {
 	var err error
 
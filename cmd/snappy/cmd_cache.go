@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"launchpad.net/snappy/logger"
+	"launchpad.net/snappy/priv"
+	"launchpad.net/snappy/snappy"
+)
+
+type cmdCache struct {
+}
+
+type cmdCacheList struct {
+}
+
+type cmdCacheClean struct {
+}
+
+const shortCacheHelp = `Manage the download cache`
+
+const longCacheHelp = `Downloaded snaps are kept in /var/lib/snappy/cache so that they can be installed again (e.g. for a rollback) without network access. The least recently used snaps are removed when the cache grows too big.`
+
+const shortCacheListHelp = `List the snaps in the download cache`
+
+const longCacheListHelp = `Lists the snaps in the download cache, the least recently used one first.`
+
+const shortCacheCleanHelp = `Remove all snaps from the download cache`
+
+const longCacheCleanHelp = `Removes all snaps from the download cache.`
+
+func init() {
+	var cmdCacheData cmdCache
+	cmd, err := parser.AddCommand("cache", shortCacheHelp, longCacheHelp, &cmdCacheData)
+	if err != nil {
+		// panic here as something must be terribly wrong if there is an
+		// error here
+		logger.LogAndPanic(err)
+	}
+
+	var cmdCacheListData cmdCacheList
+	if _, err := cmd.AddCommand("list", shortCacheListHelp, longCacheListHelp, &cmdCacheListData); err != nil {
+		logger.LogAndPanic(err)
+	}
+
+	var cmdCacheCleanData cmdCacheClean
+	if _, err := cmd.AddCommand("clean", shortCacheCleanHelp, longCacheCleanHelp, &cmdCacheCleanData); err != nil {
+		logger.LogAndPanic(err)
+	}
+}
+
+func (x *cmdCacheList) Execute(args []string) (err error) {
+	cached, err := snappy.CachedSnaps()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 5, 3, 1, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "Name\tVersion\tSize\tLast used\t")
+	for _, snap := range cached {
+		fmt.Fprintln(w, fmt.Sprintf("%s\t%s\t%v\t%s\t", snap.Name, snap.Version, snap.Size, formatDate(snap.Date)))
+	}
+
+	return nil
+}
+
+func (x *cmdCacheClean) Execute(args []string) (err error) {
+	privMutex := priv.New()
+	if err := privMutex.TryLock(); err != nil {
+		return err
+	}
+	defer privMutex.Unlock()
+
+	return snappy.CleanCache()
+}
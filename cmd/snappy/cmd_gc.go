@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"launchpad.net/snappy/logger"
+	"launchpad.net/snappy/priv"
+	"launchpad.net/snappy/snappy"
+)
+
+type cmdGC struct {
+	DryRun bool `long:"dry-run" description:"Only show what would be removed"`
+}
+
+const shortGCHelp = `Remove old snap versions and orphaned data`
+
+const longGCHelp = `Removes the installed versions of snaps that are older than the versions kept by the retention policy, together with their data, and the data directories of snap versions that are no longer installed.
+
+By default the active version and one older version are kept, this can be changed with "keep-versions: N" in /etc/snappy/gc.yaml. Old versions are also removed after each install unless "remove-on-install: false" is set there, their data is kept until the next "snappy gc".`
+
+func init() {
+	var cmdGCData cmdGC
+	if _, err := parser.AddCommand("gc", shortGCHelp, longGCHelp, &cmdGCData); err != nil {
+		// panic here as something must be terribly wrong if there is an
+		// error here
+		logger.LogAndPanic(err)
+	}
+}
+
+func (x *cmdGC) Execute(args []string) (err error) {
+	privMutex := priv.New()
+	if err := privMutex.TryLock(); err != nil {
+		return err
+	}
+	defer privMutex.Unlock()
+
+	removed, err := snappy.GarbageCollect(x.DryRun)
+	for _, dir := range removed {
+		if x.DryRun {
+			fmt.Printf("Would remove %s\n", dir)
+		} else {
+			fmt.Printf("Removed %s\n", dir)
+		}
+	}
+
+	return err
+}
@@ -28,8 +28,9 @@ import (
 
 type cmdInstall struct {
 	AllowUnauthenticated bool `long:"allow-unauthenticated" description:"Install snaps even if the signature can not be verified."`
+	IgnoreArchitecture   bool `long:"ignore-architecture" description:"Install snaps even if they are built for a different architecture."`
 	Positional           struct {
-		PackageName string `positional-arg-name:"package name" description:"Set configuration for a specific installed package"`
+		PackageName string `positional-arg-name:"package name" description:"The package to install, use name/channel to install from a specific channel"`
 		ConfigFile  string `positional-arg-name:"config file" description:"The configuration for the given file"`
 	} `positional-args:"yes"`
 }
@@ -57,10 +58,15 @@ func (x *cmdInstall) Execute(args []string) (err error) {
 	}
 	defer privMutex.Unlock()
 
+	recoverInterrupted()
+
 	var flags snappy.InstallFlags
 	if x.AllowUnauthenticated {
 		flags |= snappy.AllowUnauthenticated
 	}
+	if x.IgnoreArchitecture {
+		flags |= snappy.IgnoreArchitecture
+	}
 
 	fmt.Printf("Installing %s\n", pkgName)
 	if err := snappy.Install(pkgName, flags); err == snappy.ErrPackageNotFound {
@@ -0,0 +1,51 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+
+	"launchpad.net/snappy/snappy"
+)
+
+// the launcher of the binaries and services of snaps, main runs it
+// before anything else so that starting a app does nothing but exec it
+const internalLaunchCmd = "internal-launch"
+
+var errInternalLaunchUsage = errors.New("usage: snappy internal-launch <app id> -- <command> [args...]")
+
+// parseInternalLaunchArgs splits the "<app id> -- <command> [args...]"
+// arguments of internal-launch
+func parseInternalLaunchArgs(args []string) (appID string, cmd []string, err error) {
+	if len(args) < 3 || args[1] != "--" {
+		return "", nil, errInternalLaunchUsage
+	}
+
+	return args[0], args[2:], nil
+}
+
+// internalLaunch runs the command confined as the app, it only returns
+// if that fails
+func internalLaunch(args []string) error {
+	appID, cmd, err := parseInternalLaunchArgs(args)
+	if err != nil {
+		return err
+	}
+
+	return snappy.RunConfined(appID, cmd)
+}
@@ -0,0 +1,34 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *CmdTestSuite) TestParseInternalLaunchArgs(c *C) {
+	appID, cmd, err := parseInternalLaunchArgs([]string{"foo_bar_1.0", "--", "/apps/foo/1.0/bin/bar", "--", "-v"})
+	c.Assert(err, IsNil)
+	c.Assert(appID, Equals, "foo_bar_1.0")
+	c.Assert(cmd, DeepEquals, []string{"/apps/foo/1.0/bin/bar", "--", "-v"})
+
+	for _, args := range [][]string{nil, {"foo_bar_1.0"}, {"foo_bar_1.0", "--"}, {"foo_bar_1.0", "/bin/true"}} {
+		_, _, err := parseInternalLaunchArgs(args)
+		c.Check(err, Equals, errInternalLaunchUsage)
+	}
+}
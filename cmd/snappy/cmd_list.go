@@ -42,7 +42,7 @@ If requested, the command will find out if there are updates for any of the comp
 
 The developer information refers to non-mainline versions of a package (much like PPAs in deb-based Ubuntu). If the package is the primary version of that package in Ubuntu then the developer info is not shown. This allows one to identify packages which have custom, non-standard versions installed. As a special case, the “sideload” developer refers to packages installed manually on the system.
 
-When a verbose listing is requested, information about the channel used is displayed; which is one of alpha, beta, rc or stable, and all fields are fully expanded too. In some cases, older (inactive) versions of snappy packages will be installed, these will be shown in the verbose output and the active version indicated with a * appended to the name of the component.`
+When a verbose listing is requested, information about the channel used is displayed; which is one of stable, candidate, beta or edge, and all fields are fully expanded too. In some cases, older (inactive) versions of snappy packages will be installed, these will be shown in the verbose output and the active version indicated with a * appended to the name of the component.`
 
 func init() {
 	var cmdListData cmdList
@@ -62,13 +62,13 @@ func (x *cmdList) Execute(args []string) (err error) {
 
 func (x cmdList) list() error {
 	installed, err := snappy.ListInstalled()
-	if err != nil {
+	if err := warnIfPartial(err, os.Stderr); err != nil {
 		return err
 	}
 
 	if x.Updates {
 		updates, err := snappy.ListUpdates()
-		if err != nil {
+		if err := warnIfPartial(err, os.Stderr); err != nil {
 			return err
 		}
 		showUpdatesList(installed, updates, os.Stdout)
@@ -78,6 +78,18 @@ func (x cmdList) list() error {
 		showInstalledList(installed, os.Stdout)
 	}
 
+	return nil
+}
+
+// warnIfPartial writes a warning if only some of the repositories
+// failed so that the results of the others can still be shown, any
+// other error is returned
+func warnIfPartial(err error, o io.Writer) error {
+	if _, ok := err.(*snappy.ErrRepositories); ok {
+		fmt.Fprintf(o, "WARNING: the results may be incomplete, %s\n", err)
+		return nil
+	}
+
 	return err
 }
 
@@ -113,7 +125,7 @@ func showInstalledList(installed []snappy.Part, o io.Writer) {
 func showVerboseList(installed []snappy.Part, o io.Writer) {
 	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
 
-	fmt.Fprintln(w, "Name\tDate\tVersion\tDeveloper\t")
+	fmt.Fprintln(w, "Name\tDate\tVersion\tDeveloper\tChannel\t")
 	for _, part := range installed {
 		active := ""
 		if part.IsActive() {
@@ -123,7 +135,7 @@ func showVerboseList(installed []snappy.Part, o io.Writer) {
 			active = "!"
 		}
 		pkg, developer := pkgAndDeveloper(part.Name())
-		fmt.Fprintln(w, fmt.Sprintf("%s%s\t%s\t%s\t%s\t", pkg, active, formatDate(part.Date()), part.Version(), developer))
+		fmt.Fprintln(w, fmt.Sprintf("%s%s\t%s\t%s\t%s\t%s\t", pkg, active, formatDate(part.Date()), part.Version(), developer, part.Channel()))
 	}
 	w.Flush()
 
@@ -0,0 +1,55 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"os"
+
+	"launchpad.net/snappy/logger"
+	"launchpad.net/snappy/snappy"
+)
+
+type cmdLogs struct {
+	Follow     bool `short:"f" long:"follow" description:"Keep showing new log lines"`
+	Lines      int  `short:"n" long:"lines" default:"10" description:"The number of lines to show, -1 for all"`
+	Positional struct {
+		Spec string `positional-arg-name:"package[.service]" description:"The package or service"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+const shortLogsHelp = `Show the logs of snap services`
+
+const longLogsHelp = `Shows what the services of a snap wrote to the journal. A package name alone shows the logs of all services of the package.`
+
+func init() {
+	var cmdLogsData cmdLogs
+	if _, err := parser.AddCommand("logs", shortLogsHelp, longLogsHelp, &cmdLogsData); err != nil {
+		// panic here as something must be terribly wrong if there is an
+		// error here
+		logger.LogAndPanic(err)
+	}
+}
+
+func (x *cmdLogs) Execute(args []string) (err error) {
+	services, err := snappy.FindServices(x.Positional.Spec)
+	if err != nil {
+		return err
+	}
+
+	return snappy.ServiceLogs(services, x.Lines, x.Follow, os.Stdout)
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"launchpad.net/snappy/logger"
+	"launchpad.net/snappy/snappy"
+)
+
+type cmdMirror struct {
+}
+
+type cmdMirrorIndex struct {
+	Positional struct {
+		Dir string `positional-arg-name:"dir" description:"The directory with the snap files"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+const shortMirrorHelp = `Manage offline snap mirrors`
+
+const longMirrorHelp = `Manage directories of snap files (e.g. on a USB stick) that can be used to install and update snaps without network access.
+
+By default snappy uses the mirror in /var/lib/snappy/mirror if it contains an index, other mirrors can be added in /etc/snappy/repositories.yaml.`
+
+const shortMirrorIndexHelp = `Generate the index of a snap mirror`
+
+const longMirrorIndexHelp = `Scans the given directory for snap files and writes the index.yaml that is needed to use the directory as a snap mirror.`
+
+func init() {
+	var cmdMirrorData cmdMirror
+	cmd, err := parser.AddCommand("mirror", shortMirrorHelp, longMirrorHelp, &cmdMirrorData)
+	if err != nil {
+		// panic here as something must be terribly wrong if there is an
+		// error here
+		logger.LogAndPanic(err)
+	}
+
+	var cmdMirrorIndexData cmdMirrorIndex
+	if _, err := cmd.AddCommand("index", shortMirrorIndexHelp, longMirrorIndexHelp, &cmdMirrorIndexData); err != nil {
+		logger.LogAndPanic(err)
+	}
+}
+
+func (x *cmdMirrorIndex) Execute(args []string) (err error) {
+	if err := snappy.BuildMirrorIndex(x.Positional.Dir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Generated index for '%s'\n", x.Positional.Dir)
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"launchpad.net/snappy/priv"
+	"launchpad.net/snappy/snappy"
+)
+
+type cmdRecover struct {
+}
+
+const shortRecoverHelp = `Recover from a interrupted install`
+
+const longRecoverHelp = `Finishes or reverts a install that was interrupted, e.g. by a power cut. This is also done automatically by the next install, update, remove or rollback.`
+
+func init() {
+	var cmdRecoverData cmdRecover
+	_, _ = parser.AddCommand("recover",
+		shortRecoverHelp,
+		longRecoverHelp,
+		&cmdRecoverData)
+}
+
+func (x *cmdRecover) Execute(args []string) (err error) {
+	privMutex := priv.New()
+	if err := privMutex.TryLock(); err != nil {
+		return err
+	}
+	defer privMutex.Unlock()
+
+	msg, err := snappy.Recover()
+	if err != nil {
+		return err
+	}
+	if msg == "" {
+		msg = "Nothing to recover"
+	}
+	fmt.Println(msg)
+
+	return nil
+}
+
+// recoverInterrupted recovers from a interrupted install before a
+// command changes the installed snaps, the caller needs to hold the
+// priv lock so that a install that is still running is not mistaken
+// for a interrupted one
+func recoverInterrupted() {
+	msg, err := snappy.Recover()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to recover the interrupted install: %s\n", err)
+		return
+	}
+	if msg != "" {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+}
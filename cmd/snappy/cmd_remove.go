@@ -25,6 +25,8 @@ import (
 )
 
 type cmdRemove struct {
+	Force bool `long:"force" description:"Remove frameworks even if they are used by installed snaps"`
+	Purge bool `long:"purge" description:"Remove the data of the snap too"`
 }
 
 func init() {
@@ -42,10 +44,20 @@ func (x *cmdRemove) Execute(args []string) (err error) {
 	}
 	defer privMutex.Unlock()
 
+	recoverInterrupted()
+
+	var flags snappy.RemoveFlags
+	if x.Force {
+		flags |= snappy.ForceRemove
+	}
+	if x.Purge {
+		flags |= snappy.PurgeData
+	}
+
 	for _, part := range args {
 		fmt.Printf("Removing %s\n", part)
 
-		if err := snappy.Remove(part); err != nil {
+		if err := snappy.Remove(part, flags); err != nil {
 			return err
 		}
 	}
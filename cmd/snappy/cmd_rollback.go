@@ -25,7 +25,8 @@ import (
 )
 
 type cmdRollback struct {
-	Positional struct {
+	AllowUnauthenticated bool `long:"allow-unauthenticated" description:"Install the version from the download cache even if the signature can not be verified."`
+	Positional           struct {
 		PackageName string `positional-arg-name:"package name" description:"The package to rollback "`
 		Version     string `positional-arg-name:"version" description:"The version to rollback to"`
 	} `positional-args:"yes"`
@@ -33,7 +34,7 @@ type cmdRollback struct {
 
 const shortRollbackHelp = "Rollback to a previous version of a package"
 
-const longRollbackHelp = `Allows rollback of a snap to a previous installed version. Without any arguments, the previous installed version is selected. It is also possible to specify the version to rollback to as a additional argument.
+const longRollbackHelp = `Allows rollback of a snap to a previous installed version. Without any arguments, the previous installed version is selected. It is also possible to specify the version to rollback to as a additional argument. Versions that are no longer installed are installed again from the download cache if they are still in it.
 `
 
 func init() {
@@ -51,13 +52,20 @@ func (x *cmdRollback) Execute(args []string) (err error) {
 	}
 	defer privMutex.Unlock()
 
+	recoverInterrupted()
+
 	pkg := x.Positional.PackageName
 	version := x.Positional.Version
 	if pkg == "" {
 		return errNeedPackageName
 	}
 
-	nowVersion, err := snappy.Rollback(pkg, version)
+	var flags snappy.InstallFlags
+	if x.AllowUnauthenticated {
+		flags |= snappy.AllowUnauthenticated
+	}
+
+	nowVersion, err := snappy.Rollback(pkg, version, flags)
 	if err != nil {
 		return err
 	}
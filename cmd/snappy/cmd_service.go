@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"launchpad.net/snappy/logger"
+	"launchpad.net/snappy/priv"
+	"launchpad.net/snappy/snappy"
+)
+
+type cmdService struct {
+}
+
+type cmdServiceList struct {
+	Positional struct {
+		Spec string `positional-arg-name:"package[.service]" description:"Only list the services of the given package"`
+	} `positional-args:"yes"`
+}
+
+type cmdServiceStatus struct {
+	Positional struct {
+		Spec string `positional-arg-name:"package[.service]" description:"Only show the given package or service"`
+	} `positional-args:"yes"`
+}
+
+// cmdServiceAction is used for all the commands that change the state
+// of services
+type cmdServiceAction struct {
+	action     string
+	Positional struct {
+		Spec string `positional-arg-name:"package[.service]" description:"The package or service"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+const shortServiceHelp = `Manage the services of snaps`
+
+const longServiceHelp = `Query and control the services of the active snaps. Services are given as package.service, a package name alone means all services of the package.`
+
+const shortServiceListHelp = `List the services of snaps`
+
+const longServiceListHelp = `Lists the services of the active snaps together with the systemd units that run them.`
+
+const shortServiceStatusHelp = `Show the state of services`
+
+const longServiceStatusHelp = `Shows if the services are enabled and if they are running.`
+
+// the commands that change the state of services
+var serviceActions = []struct {
+	action string
+	short  string
+	long   string
+}{
+	{"start", "Start services", "Starts the given services."},
+	{"stop", "Stop services", "Stops the given services."},
+	{"restart", "Restart services", "Restarts the given services."},
+	{"enable", "Enable services", "Makes the given services start on boot."},
+	{"disable", "Disable services", "Makes the given services no longer start on boot."},
+}
+
+func init() {
+	var cmdServiceData cmdService
+	cmd, err := parser.AddCommand("service", shortServiceHelp, longServiceHelp, &cmdServiceData)
+	if err != nil {
+		// panic here as something must be terribly wrong if there is an
+		// error here
+		logger.LogAndPanic(err)
+	}
+
+	var cmdServiceListData cmdServiceList
+	if _, err := cmd.AddCommand("list", shortServiceListHelp, longServiceListHelp, &cmdServiceListData); err != nil {
+		logger.LogAndPanic(err)
+	}
+
+	var cmdServiceStatusData cmdServiceStatus
+	if _, err := cmd.AddCommand("status", shortServiceStatusHelp, longServiceStatusHelp, &cmdServiceStatusData); err != nil {
+		logger.LogAndPanic(err)
+	}
+
+	for _, a := range serviceActions {
+		data := &cmdServiceAction{action: a.action}
+		if _, err := cmd.AddCommand(a.action, a.short, a.long, data); err != nil {
+			logger.LogAndPanic(err)
+		}
+	}
+}
+
+func (x *cmdServiceList) Execute(args []string) (err error) {
+	services, err := snappy.FindServices(x.Positional.Spec)
+	if err != nil {
+		return err
+	}
+
+	showServiceList(services, os.Stdout)
+
+	return nil
+}
+
+func showServiceList(services []*snappy.SnapService, o io.Writer) {
+	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "Snap\tService\tVersion\tUnit\t")
+	for _, service := range services {
+		fmt.Fprintln(w, fmt.Sprintf("%s\t%s\t%s\t%s\t", service.Snap(), service.Name(), service.Version(), service.Unit()))
+	}
+}
+
+func (x *cmdServiceStatus) Execute(args []string) (err error) {
+	services, err := snappy.FindServices(x.Positional.Spec)
+	if err != nil {
+		return err
+	}
+
+	var status []*snappy.ServiceStatus
+	for _, service := range services {
+		st, err := service.Status()
+		if err != nil {
+			return err
+		}
+		status = append(status, st)
+	}
+
+	showServiceStatus(status, os.Stdout)
+
+	return nil
+}
+
+func showServiceStatus(status []*snappy.ServiceStatus, o io.Writer) {
+	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "Service\tEnabled\tState\t")
+	for _, st := range status {
+		enabled := "no"
+		if st.Enabled {
+			enabled = "yes"
+		}
+		fmt.Fprintln(w, fmt.Sprintf("%s\t%s\t%s (%s)\t", st.Name, enabled, st.Active, st.Sub))
+	}
+}
+
+func (x *cmdServiceAction) Execute(args []string) (err error) {
+	privMutex := priv.New()
+	if err := privMutex.TryLock(); err != nil {
+		return err
+	}
+	defer privMutex.Unlock()
+
+	services, err := snappy.FindServices(x.Positional.Spec)
+	if err != nil {
+		return err
+	}
+
+	for _, service := range services {
+		if err := serviceAction(service, x.action); err != nil {
+			return fmt.Errorf("can not %s %s: %s", x.action, service.FullName(), err)
+		}
+	}
+
+	return nil
+}
+
+func serviceAction(service *snappy.SnapService, action string) error {
+	switch action {
+	case "start":
+		return service.Start()
+	case "stop":
+		return service.Stop()
+	case "restart":
+		return service.Restart()
+	case "enable":
+		return service.Enable()
+	case "disable":
+		return service.Disable()
+	}
+
+	return fmt.Errorf("unknown action %q", action)
+}
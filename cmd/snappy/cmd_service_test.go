@@ -0,0 +1,39 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+
+	"launchpad.net/snappy/snappy"
+
+	. "launchpad.net/gocheck"
+)
+
+func (s *CmdTestSuite) TestShowServiceStatus(c *C) {
+	var buf bytes.Buffer
+	showServiceStatus([]*snappy.ServiceStatus{
+		{Name: "foo.svc1", Enabled: true, Active: "active", Sub: "running"},
+		{Name: "foo.svc2", Enabled: false, Active: "failed", Sub: "failed"},
+	}, &buf)
+
+	c.Assert(buf.String(), Equals, ""+
+		"Service  Enabled State            \n"+
+		"foo.svc1 yes     active (running) \n"+
+		"foo.svc2 no      failed (failed)  \n")
+}
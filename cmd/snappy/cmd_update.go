@@ -43,6 +43,8 @@ func (x *cmdUpdate) Execute(args []string) (err error) {
 	}
 	defer privMutex.Unlock()
 
+	recoverInterrupted()
+
 	return update()
 }
 
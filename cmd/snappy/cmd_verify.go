@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"launchpad.net/snappy/logger"
+	"launchpad.net/snappy/snappy"
+
+	"gopkg.in/yaml.v2"
+)
+
+type cmdVerify struct {
+	Yaml       bool `long:"yaml" description:"Print a machine readable report"`
+	Positional struct {
+		PackageName string `positional-arg-name:"package name" description:"Only verify the given package"`
+	} `positional-args:"yes"`
+}
+
+const shortVerifyHelp = `Verify the installed files of snaps`
+
+const longVerifyHelp = `Checks the files of all installed versions of the snaps against the hashes that came with them and reports files that were modified, are missing, were added or have the wrong mode.
+
+The command fails if any problems are found. Snaps that were installed before the hashes were recorded on install are reported as unrecorded, but do not fail the command.`
+
+func init() {
+	var cmdVerifyData cmdVerify
+	if _, err := parser.AddCommand("verify", shortVerifyHelp, longVerifyHelp, &cmdVerifyData); err != nil {
+		// panic here as something must be terribly wrong if there is an
+		// error here
+		logger.LogAndPanic(err)
+	}
+}
+
+func (x *cmdVerify) Execute(args []string) (err error) {
+	reports, err := snappy.Verify(x.Positional.PackageName)
+	if err != nil {
+		return err
+	}
+
+	if x.Yaml {
+		err = showVerifyYaml(reports, os.Stdout)
+	} else {
+		showVerifyReports(reports, os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, report := range reports {
+		if !report.OK() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d snaps failed the verification", failed, len(reports))
+	}
+
+	return nil
+}
+
+func showVerifyYaml(reports []*snappy.VerifyReport, o io.Writer) error {
+	content, err := yaml.Marshal(map[string][]*snappy.VerifyReport{"snaps": reports})
+	if err != nil {
+		return err
+	}
+
+	_, err = o.Write(content)
+	return err
+}
+
+func showVerifyReports(reports []*snappy.VerifyReport, o io.Writer) {
+	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "Name\tVersion\tProblem\tFile\t")
+	for _, report := range reports {
+		if len(report.Issues) == 0 {
+			fmt.Fprintln(w, fmt.Sprintf("%s\t%s\tok\t\t", report.Name, report.Version))
+			continue
+		}
+		for _, issue := range report.Issues {
+			file := issue.File
+			if issue.Expected != "" {
+				file = fmt.Sprintf("%s (%s, expected %s)", issue.File, issue.Got, issue.Expected)
+			}
+			fmt.Fprintln(w, fmt.Sprintf("%s\t%s\t%s\t%s\t", report.Name, report.Version, issue.Problem, file))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+
+	"launchpad.net/snappy/snappy"
+
+	. "launchpad.net/gocheck"
+)
+
+var testVerifyReports = []*snappy.VerifyReport{
+	{Name: "foo", Version: "1.0"},
+	{Name: "bar", Version: "2.0", Issues: []snappy.VerifyIssue{
+		{File: "bin/bar", Problem: snappy.VerifyModified},
+		{File: "meta", Problem: snappy.VerifyWrongMode, Expected: "drwxr-xr-x", Got: "drwxrwxrwx"},
+	}},
+}
+
+func (s *CmdTestSuite) TestShowVerifyReports(c *C) {
+	var buf bytes.Buffer
+	showVerifyReports(testVerifyReports, &buf)
+
+	c.Assert(buf.String(), Equals, ""+
+		"Name Version Problem    File                                   \n"+
+		"foo  1.0     ok                                                \n"+
+		"bar  2.0     modified   bin/bar                                \n"+
+		"bar  2.0     wrong-mode meta (drwxrwxrwx, expected drwxr-xr-x) \n")
+}
+
+func (s *CmdTestSuite) TestShowVerifyReportsUnrecorded(c *C) {
+	var buf bytes.Buffer
+	showVerifyReports([]*snappy.VerifyReport{
+		{Name: "foo", Version: "1.0", Issues: []snappy.VerifyIssue{
+			{File: "meta/hashes.yaml", Problem: snappy.VerifyUnrecorded},
+		}},
+	}, &buf)
+
+	c.Assert(buf.String(), Equals, ""+
+		"Name Version Problem    File             \n"+
+		"foo  1.0     unrecorded meta/hashes.yaml \n")
+}
+
+func (s *CmdTestSuite) TestShowVerifyYaml(c *C) {
+	var buf bytes.Buffer
+	c.Assert(showVerifyYaml(testVerifyReports, &buf), IsNil)
+
+	c.Assert(buf.String(), Equals, `snaps:
+- name: foo
+  version: "1.0"
+- name: bar
+  version: "2.0"
+  issues:
+  - file: bin/bar
+    problem: modified
+  - file: meta
+    problem: wrong-mode
+    expected: drwxr-xr-x
+    got: drwxrwxrwx
+`)
+}
@@ -41,14 +41,20 @@ var optionsData options
 
 var parser = flags.NewParser(&optionsData, flags.Default)
 
-func init() {
-	err := logger.ActivateLogger()
-	if err != nil {
+func main() {
+	// every app and service is started this way, so no logging,
+	// recovery or option parsing happens before the exec
+	if len(os.Args) > 1 && os.Args[1] == internalLaunchCmd {
+		if err := internalLaunch(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+
+	if err := logger.ActivateLogger(); err != nil {
 		fmt.Fprintf(os.Stderr, "WARNING: failed to activate logging: %s\n", err)
 	}
-}
 
-func main() {
 	if _, err := parser.Parse(); err != nil {
 		if err == priv.ErrNeedRoot {
 			// make the generic root error more specific for
@@ -0,0 +1,282 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package helpers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// the FICLONE ioctl from linux/fs.h, it makes the destination file
+// share the data blocks of the source file until one of them is
+// written to (a reflink)
+const ficlone = 0x40049409
+
+// reflinkFile makes dst a reflink of src, this only works on
+// filesystems that support it (e.g. btrfs)
+var reflinkFile = func(dst, src *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd())
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// setXattr sets a extended attribute on a file
+var setXattr = syscall.Setxattr
+
+// xattrNotCopied returns true if the error from reading or writing a
+// extended attribute means that the attribute can not be copied,
+// like "cp -a" these attributes are skipped (e.g. security.* as a
+// user or a namespace the filesystem does not support)
+func xattrNotCopied(err error) bool {
+	return err == syscall.ENOTSUP || err == syscall.EPERM || err == syscall.ENODATA
+}
+
+// copyXattrs copies the extended attributes from src to dst, it is fine
+// if the filesystem does not support them
+func copyXattrs(src, dst string) error {
+	size, err := syscall.Listxattr(src, nil)
+	if err == syscall.ENOTSUP || size == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(src, buf)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		attr := string(name)
+
+		size, err := syscall.Getxattr(src, attr, nil)
+		if xattrNotCopied(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		value := make([]byte, size)
+		size, err = syscall.Getxattr(src, attr, value)
+		if xattrNotCopied(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = setXattr(dst, attr, value[:size], 0)
+		if xattrNotCopied(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("can not set xattr %s on %s: %s", attr, dst, err)
+		}
+	}
+
+	return nil
+}
+
+// copyOwner gives dst the owner of src if they differ, changing the
+// owner to someone else needs root
+func copyOwner(dst string, st os.FileInfo) error {
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if int(sys.Uid) == os.Getuid() && int(sys.Gid) == os.Getgid() {
+		return nil
+	}
+
+	return os.Lchown(dst, int(sys.Uid), int(sys.Gid))
+}
+
+// copyMetadata copies the owner, mode, xattrs and times of a file or
+// dir to dst
+func copyMetadata(src, dst string, st os.FileInfo) error {
+	if err := copyOwner(dst, st); err != nil {
+		return err
+	}
+	// chown clears the setuid bits so the mode comes after it
+	if err := os.Chmod(dst, st.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	if err := copyXattrs(src, dst); err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, st.ModTime(), st.ModTime())
+}
+
+// CopyFile copies the regular file src to dst with its owner, mode,
+// xattrs and modification time. The data is shared as a reflink if the
+// filesystem supports it and copied otherwise, so unlike with a hard
+// link changes to one of the files never show up in the other one.
+func CopyFile(src, dst string) (err error) {
+	st, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if !st.Mode().IsRegular() {
+		return fmt.Errorf("can not copy %s: not a regular file", src)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	if reflinkFile(out, in) != nil {
+		if _, err := io.Copy(out, in); err != nil {
+			return err
+		}
+	}
+
+	if err := out.Sync(); err != nil {
+		return err
+	}
+
+	return copyMetadata(src, dst, st)
+}
+
+// the identity of a file, used to find hard links
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// CopyTree copies the directory src to dst, which must not exist yet.
+// Regular files are copied with CopyFile, symlinks and named pipes are
+// recreated and hard links inside of src are kept. Sockets are skipped
+// as they need to be recreated by whoever listens on them.
+//
+// The copy is made in a temporary directory next to dst and only
+// renamed to dst when it is complete.
+func CopyTree(src, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("can not copy %s: %s already exists", src, dst)
+	}
+
+	tmp := dst + ".copy-tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	if err := copyTree(src, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dst)
+}
+
+func copyTree(src, dst string) error {
+	links := make(map[fileID]string)
+	// the dirs get their metadata once their content is copied so
+	// that the times stay right and read-only dirs can be filled
+	var dirs []string
+
+	err := filepath.Walk(src, func(path string, st os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, path[len(src):])
+
+		mode := st.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.Mkdir(target, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, path)
+			return nil
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			return copyOwner(target, st)
+		case mode&os.ModeSocket != 0:
+			return nil
+		}
+
+		// keep hard links
+		if sys, ok := st.Sys().(*syscall.Stat_t); ok && sys.Nlink > 1 {
+			id := fileID{dev: uint64(sys.Dev), ino: uint64(sys.Ino)}
+			if first, ok := links[id]; ok {
+				return os.Link(first, target)
+			}
+			links[id] = target
+		}
+
+		switch {
+		case mode.IsRegular():
+			return CopyFile(path, target)
+		case mode&os.ModeNamedPipe != 0:
+			if err := syscall.Mkfifo(target, uint32(mode.Perm())); err != nil {
+				return err
+			}
+			return copyMetadata(path, target, st)
+		}
+
+		return fmt.Errorf("can not copy %s: unsupported file type %s", path, mode)
+	})
+	if err != nil {
+		return err
+	}
+
+	// innermost dirs first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		st, err := os.Lstat(dirs[i])
+		if err != nil {
+			return err
+		}
+		if err := copyMetadata(dirs[i], filepath.Join(dst, dirs[i][len(src):]), st); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package helpers
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+func (ts *HTestSuite) TestCopyFile(c *C) {
+	tmpdir := c.MkDir()
+	src := filepath.Join(tmpdir, "src")
+	dst := filepath.Join(tmpdir, "dst")
+	c.Assert(ioutil.WriteFile(src, []byte("data"), 0640), IsNil)
+	mtime := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	c.Assert(os.Chtimes(src, mtime, mtime), IsNil)
+
+	c.Assert(CopyFile(src, dst), IsNil)
+
+	content, err := ioutil.ReadFile(dst)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "data")
+	st, err := os.Stat(dst)
+	c.Assert(err, IsNil)
+	c.Assert(st.Mode(), Equals, os.FileMode(0640))
+	c.Assert(st.ModTime().Equal(mtime), Equals, true)
+
+	// the copy is independent of the original
+	c.Assert(ioutil.WriteFile(dst, []byte("changed"), 0640), IsNil)
+	content, err = ioutil.ReadFile(src)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "data")
+}
+
+func (ts *HTestSuite) TestCopyFileNoReflink(c *C) {
+	origReflinkFile := reflinkFile
+	defer func() { reflinkFile = origReflinkFile }()
+	called := false
+	reflinkFile = func(dst, src *os.File) error {
+		called = true
+		return errors.New("not supported")
+	}
+
+	tmpdir := c.MkDir()
+	src := filepath.Join(tmpdir, "src")
+	dst := filepath.Join(tmpdir, "dst")
+	c.Assert(ioutil.WriteFile(src, []byte("data"), 0644), IsNil)
+
+	c.Assert(CopyFile(src, dst), IsNil)
+	c.Assert(called, Equals, true)
+	content, err := ioutil.ReadFile(dst)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "data")
+}
+
+func (ts *HTestSuite) TestCopyFileXattrs(c *C) {
+	tmpdir := c.MkDir()
+	src := filepath.Join(tmpdir, "src")
+	dst := filepath.Join(tmpdir, "dst")
+	c.Assert(ioutil.WriteFile(src, []byte("data"), 0644), IsNil)
+	if err := syscall.Setxattr(src, "user.snappy-test", []byte("value"), 0); err != nil {
+		c.Skip("no xattr support: " + err.Error())
+	}
+
+	c.Assert(CopyFile(src, dst), IsNil)
+
+	value := make([]byte, 64)
+	n, err := syscall.Getxattr(dst, "user.snappy-test", value)
+	c.Assert(err, IsNil)
+	c.Assert(string(value[:n]), Equals, "value")
+}
+
+func (ts *HTestSuite) TestCopyFileSkipsXattrsThatCanNotBeSet(c *C) {
+	origSetXattr := setXattr
+	defer func() { setXattr = origSetXattr }()
+	setXattr = func(path, attr string, data []byte, flags int) error {
+		if attr == "user.snappy-denied" {
+			return syscall.EPERM
+		}
+		return origSetXattr(path, attr, data, flags)
+	}
+
+	tmpdir := c.MkDir()
+	src := filepath.Join(tmpdir, "src")
+	dst := filepath.Join(tmpdir, "dst")
+	c.Assert(ioutil.WriteFile(src, []byte("data"), 0644), IsNil)
+	if err := syscall.Setxattr(src, "user.snappy-denied", []byte("value"), 0); err != nil {
+		c.Skip("no xattr support: " + err.Error())
+	}
+	c.Assert(syscall.Setxattr(src, "user.snappy-test", []byte("value"), 0), IsNil)
+
+	c.Assert(CopyFile(src, dst), IsNil)
+
+	value := make([]byte, 64)
+	n, err := syscall.Getxattr(dst, "user.snappy-test", value)
+	c.Assert(err, IsNil)
+	c.Assert(string(value[:n]), Equals, "value")
+	_, err = syscall.Getxattr(dst, "user.snappy-denied", value)
+	c.Assert(err, Equals, syscall.ENODATA)
+}
+
+func (ts *HTestSuite) TestCopyFileExists(c *C) {
+	tmpdir := c.MkDir()
+	src := filepath.Join(tmpdir, "src")
+	dst := filepath.Join(tmpdir, "dst")
+	c.Assert(ioutil.WriteFile(src, []byte("data"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(dst, []byte("other"), 0644), IsNil)
+
+	c.Assert(CopyFile(src, dst), NotNil)
+	content, err := ioutil.ReadFile(dst)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "other")
+}
+
+func (ts *HTestSuite) TestCopyTree(c *C) {
+	tmpdir := c.MkDir()
+	src := filepath.Join(tmpdir, "src")
+	dst := filepath.Join(tmpdir, "dst")
+	c.Assert(os.MkdirAll(filepath.Join(src, "sub"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(src, "sub", "file"), []byte("data"), 0600), IsNil)
+	c.Assert(os.Link(filepath.Join(src, "sub", "file"), filepath.Join(src, "link")), IsNil)
+	c.Assert(os.Symlink("sub/file", filepath.Join(src, "symlink")), IsNil)
+	c.Assert(syscall.Mkfifo(filepath.Join(src, "fifo"), 0644), IsNil)
+	c.Assert(os.Chmod(filepath.Join(src, "sub"), 0750), IsNil)
+
+	c.Assert(CopyTree(src, dst), IsNil)
+
+	content, err := ioutil.ReadFile(filepath.Join(dst, "sub", "file"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "data")
+
+	st, err := os.Stat(filepath.Join(dst, "sub"))
+	c.Assert(err, IsNil)
+	c.Assert(st.Mode(), Equals, os.ModeDir|0750)
+
+	// hard links inside the tree are kept, but not shared with the
+	// original
+	st1, err := os.Stat(filepath.Join(dst, "sub", "file"))
+	c.Assert(err, IsNil)
+	st2, err := os.Stat(filepath.Join(dst, "link"))
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(st1, st2), Equals, true)
+	orig, err := os.Stat(filepath.Join(src, "link"))
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(st1, orig), Equals, false)
+
+	link, err := os.Readlink(filepath.Join(dst, "symlink"))
+	c.Assert(err, IsNil)
+	c.Assert(link, Equals, "sub/file")
+
+	st, err = os.Lstat(filepath.Join(dst, "fifo"))
+	c.Assert(err, IsNil)
+	c.Assert(st.Mode()&os.ModeNamedPipe, Equals, os.ModeNamedPipe)
+
+	c.Assert(FileExists(dst+".copy-tmp"), Equals, false)
+}
+
+func (ts *HTestSuite) TestCopyTreeDstExists(c *C) {
+	tmpdir := c.MkDir()
+	src := filepath.Join(tmpdir, "src")
+	dst := filepath.Join(tmpdir, "dst")
+	c.Assert(os.MkdirAll(src, 0755), IsNil)
+	c.Assert(os.MkdirAll(dst, 0755), IsNil)
+
+	c.Assert(CopyTree(src, dst), ErrorMatches, ".* already exists")
+}
@@ -0,0 +1,385 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"launchpad.net/snappy/helpers"
+)
+
+// the policy that is used if the system does not ship its own, the
+// format is the one of the apparmor-easyprof templates
+var builtinAppArmorTemplates = map[string]string{
+	defaultSecurityTemplate: `# Description: Allows access to app-specific directories and basic runtime
+# Usage: common
+###ENDUSAGE###
+#include <tunables/global>
+
+###VAR###
+
+###PROFILEATTACH### (attach_disconnected) {
+  #include <abstractions/base>
+  #include <abstractions/consoles>
+  #include <abstractions/openssl>
+
+  # for python apps/services
+  #include <abstractions/python>
+  /usr/bin/python{,2,2.[0-9]*,3,3.[0-9]*} ixr,
+
+  # for perl apps/services
+  #include <abstractions/perl>
+  /usr/bin/perl{,5*} ixr,
+
+  # the binary wrappers
+  /bin/sh ixr,
+  /bin/mkdir ixr,
+  /usr/bin/dpkg ixr,
+
+  # read-only for the install directory
+  @{INSTALL_DIR}/@{APP_PKGNAME}/                   r,
+  @{INSTALL_DIR}/@{APP_PKGNAME}/@{APP_VERSION}/    r,
+  @{INSTALL_DIR}/@{APP_PKGNAME}/@{APP_VERSION}/**  mrklix,
+
+  # writable home area
+  owner @{HOMEDIRS}/*/apps/@{APP_PKGNAME}/   rw,
+  owner @{HOMEDIRS}/*/apps/@{APP_PKGNAME}/** mrwklix,
+
+  # writable system area
+  /var/lib/apps/@{APP_PKGNAME}/   rw,
+  /var/lib/apps/@{APP_PKGNAME}/** mrwklix,
+
+  # the TMPDIR the launcher sets up
+  /tmp/snaps/@{APP_PKGNAME}/@{APP_VERSION}/tmp/   rw,
+  /tmp/snaps/@{APP_PKGNAME}/@{APP_VERSION}/tmp/** mrwkl,
+
+  ###ABSTRACTIONS###
+
+  ###POLICYGROUPS###
+
+  ###READS###
+
+  ###WRITES###
+}
+`,
+}
+
+// the rules of the launcher, they are added to every profile as the
+// templates of the system do not know about it
+const appArmorLauncherRules = `
+  # the launcher, services start it in this profile already
+  /usr/bin/snappy mr,
+  /var/lib/snappy/seccomp/filters/@{APP_PKGNAME}_@{APP_APPNAME}_@{APP_VERSION} r,
+  owner @{PROC}/@{pid}/task/@{tid}/attr/current r,
+`
+
+// the line of a template that starts the profile
+var appArmorProfileStart = regexp.MustCompile(`(?m)^###PROFILEATTACH###.*\{$`)
+
+// the policy groups (caps) that are used if the system does not ship
+// its own
+var builtinAppArmorPolicyGroups = map[string]string{
+	defaultSecurityCap: `# Description: Can access the network as a client.
+# Usage: common
+#include <abstractions/nameservice>
+#include <abstractions/ssl_certs>
+
+network inet stream,
+network inet6 stream,
+network inet dgram,
+network inet6 dgram,
+
+@{PROC}/sys/net/core/somaxconn r,
+`,
+}
+
+// handCraftedAppArmorProfile returns the file of the profile the app
+// ships itself, either from its security-policy or from a apparmor-profile
+// in the integration section of older snaps, or "" if it has none
+func handCraftedAppArmorProfile(m *packageYaml, app snapApp) string {
+	if app.SecurityPolicy != nil {
+		return app.SecurityPolicy.Apparmor
+	}
+
+	return m.Integration[app.name]["apparmor-profile"]
+}
+
+// appArmorPolicy is the high level policy a profile is generated from,
+// the json tags are the ones of the apparmor-easyprof json that is used
+// by the security-override
+type appArmorPolicy struct {
+	Template     string   `json:"template"`
+	PolicyGroups []string `json:"policy_groups"`
+	Abstractions []string `json:"abstractions"`
+	ReadPath     []string `json:"read_path"`
+	WritePath    []string `json:"write_path"`
+}
+
+// dbusPath escapes s like apparmor-easyprof does for dbus object paths
+func dbusPath(s string) string {
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			buf = append(buf, c)
+		default:
+			buf = append(buf, fmt.Sprintf("_%02x", c)...)
+		}
+	}
+
+	return string(buf)
+}
+
+// appArmorPathRules returns the rules for the given paths, directories
+// get access to everything below them
+func appArmorPathRules(paths []string, perms string) (rules []string) {
+	for _, p := range paths {
+		rules = append(rules, fmt.Sprintf("%q %s,", p, perms))
+		if strings.HasSuffix(p, "/") {
+			rules = append(rules, fmt.Sprintf("%q %s,", p+"**", perms))
+		}
+	}
+
+	return rules
+}
+
+// snapAppPolicy returns the high level policy of the app of the snap
+// in baseDir, from its security-template, caps and security-override
+func snapAppPolicy(baseDir string, app snapApp) (*appArmorPolicy, error) {
+	policy := &appArmorPolicy{
+		Template:     app.SecurityTemplate,
+		PolicyGroups: app.SecurityCaps,
+	}
+	if policy.PolicyGroups == nil {
+		policy.PolicyGroups = []string{defaultSecurityCap}
+	}
+
+	if app.SecurityOverride == nil || app.SecurityOverride.Apparmor == "" {
+		return policy, nil
+	}
+
+	overrideFile := filepath.Join(baseDir, app.SecurityOverride.Apparmor)
+	content, err := ioutil.ReadFile(overrideFile)
+	if err != nil {
+		return nil, err
+	}
+	var override appArmorPolicy
+	if err := json.Unmarshal(content, &override); err != nil {
+		return nil, fmt.Errorf("%s: %s", overrideFile, err)
+	}
+
+	if override.Template != "" {
+		policy.Template = override.Template
+	}
+	policy.PolicyGroups = append(append([]string(nil), policy.PolicyGroups...), override.PolicyGroups...)
+	policy.Abstractions = override.Abstractions
+	policy.ReadPath = override.ReadPath
+	policy.WritePath = override.WritePath
+
+	return policy, nil
+}
+
+// snapAppArmorProfile returns the profile of the app of the snap in
+// baseDir, either the hand-crafted one it ships or one generated from
+// its policy and the write paths from hw-assign
+func snapAppArmorProfile(m *packageYaml, baseDir string, app snapApp, writePaths []string) (string, error) {
+	if profileFile := handCraftedAppArmorProfile(m, app); profileFile != "" {
+		content, err := ioutil.ReadFile(filepath.Join(baseDir, profileFile))
+		if err != nil {
+			return "", err
+		}
+		return string(content), nil
+	}
+
+	policy, err := snapAppPolicy(baseDir, app)
+	if err != nil {
+		return "", err
+	}
+	policy.WritePath = append(policy.WritePath, writePaths...)
+
+	return generateAppArmorProfile(m, app.name, policy)
+}
+
+// generateAppArmorProfile renders the profile of the given app from the
+// template and policy groups of the policy
+func generateAppArmorProfile(m *packageYaml, appName string, policy *appArmorPolicy) (string, error) {
+	templateName := policy.Template
+	if templateName == "" {
+		templateName = defaultSecurityTemplate
+	}
+	template, ok, err := readSecurityPolicy(snapAppArmorTemplatesDir, templateName, builtinAppArmorTemplates)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", &ErrUnknownSecurityTemplate{template: templateName}
+	}
+	// the usage is only interesting for humans
+	if i := strings.Index(template, "###ENDUSAGE###\n"); i >= 0 {
+		template = template[i+len("###ENDUSAGE###\n"):]
+	}
+
+	start := appArmorProfileStart.FindStringIndex(template)
+	if start == nil {
+		return "", fmt.Errorf("security-template %q does not start a profile with ###PROFILEATTACH###", templateName)
+	}
+	template = template[:start[1]] + appArmorLauncherRules + template[start[1]:]
+
+	var policyGroups []string
+	for _, cap := range policy.PolicyGroups {
+		policyGroup, ok, err := readSecurityPolicy(snapAppArmorPolicyGroupsDir, cap, builtinAppArmorPolicyGroups)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", &ErrUnknownCap{cap: cap}
+		}
+		policyGroups = append(policyGroups, "# policy group "+cap)
+		policyGroups = append(policyGroups, strings.Split(strings.TrimSpace(policyGroup), "\n")...)
+	}
+
+	var abstractions []string
+	for _, abstraction := range policy.Abstractions {
+		if !validSecurityName.MatchString(abstraction) {
+			return "", fmt.Errorf("invalid abstraction %q", abstraction)
+		}
+		abstractions = append(abstractions, fmt.Sprintf("#include <abstractions/%s>", abstraction))
+	}
+
+	appID := snapAppID(m, appName)
+	vars := []string{
+		fmt.Sprintf("@{APP_APPNAME}=%q", appName),
+		fmt.Sprintf("@{APP_ID_DBUS}=%q", dbusPath(appID)),
+		fmt.Sprintf("@{APP_PKGNAME_DBUS}=%q", dbusPath(m.Name)),
+		fmt.Sprintf("@{APP_PKGNAME}=%q", m.Name),
+		fmt.Sprintf("@{APP_VERSION}=%q", m.Version),
+		`@{INSTALL_DIR}="{/apps,/oem}"`,
+	}
+
+	r := strings.NewReplacer(
+		"###VAR###", strings.Join(vars, "\n"),
+		"###PROFILEATTACH###", fmt.Sprintf("profile %q", appID),
+		"###ABSTRACTIONS###", strings.Join(abstractions, "\n  "),
+		"###POLICYGROUPS###", strings.Join(policyGroups, "\n  "),
+		"###READS###", strings.Join(appArmorPathRules(policy.ReadPath, "rk"), "\n  "),
+		"###WRITES###", strings.Join(appArmorPathRules(policy.WritePath, "rwk"), "\n  "),
+	)
+
+	return r.Replace(template), nil
+}
+
+// appArmorProfileFile returns the file the profile of the app is written to
+func appArmorProfileFile(appID string) string {
+	return filepath.Join(snapAppArmorProfilesDir, appID)
+}
+
+func runAppArmorParserImpl(args ...string) error {
+	if err := exec.Command("apparmor_parser", args...).Run(); err != nil {
+		if exitCode, err := helpers.ExitCode(err); err == nil {
+			return &ErrHookFailed{
+				cmd:      "apparmor_parser",
+				exitCode: exitCode,
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// runAppArmorParser loads and unloads profiles, useful to override for
+// testing
+var runAppArmorParser = runAppArmorParserImpl
+
+// addPackageAppArmorProfiles generates the profiles of the snap in
+// baseDir and loads them, unless inhibitHooks is set. Snappy is the only
+// one that writes the profiles of snaps, the apparmor click hooks are
+// ignored and the snappy-apparmor.service loads them on boot.
+func addPackageAppArmorProfiles(baseDir string, inhibitHooks bool) error {
+	m, err := parsePackageYamlFile(filepath.Join(baseDir, "meta", "package.yaml"))
+	if err != nil {
+		return err
+	}
+
+	// the additions from hw-assign, its ok if there are none
+	additional, err := readHWAccessJSONFile(m.Name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := helpers.EnsureDir(snapAppArmorProfilesDir, 0755); err != nil {
+		return err
+	}
+
+	for _, app := range snapApps(m, baseDir) {
+		profile, err := snapAppArmorProfile(m, baseDir, app, additional.WritePath)
+		if err != nil {
+			return err
+		}
+
+		profileFile := appArmorProfileFile(snapAppID(m, app.name))
+		if err := helpers.AtomicWriteFile(profileFile, []byte(profile), 0644); err != nil {
+			return err
+		}
+
+		if !inhibitHooks {
+			if err := runAppArmorParser("-r", profileFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// removePackageAppArmorProfiles unloads and removes the profiles of the
+// snap in baseDir
+func removePackageAppArmorProfiles(baseDir string, inhibitHooks bool) error {
+	m, err := parsePackageYamlFile(filepath.Join(baseDir, "meta", "package.yaml"))
+	if err != nil {
+		return err
+	}
+
+	for _, app := range snapApps(m, baseDir) {
+		profileFile := appArmorProfileFile(snapAppID(m, app.name))
+		if !helpers.FileExists(profileFile) {
+			continue
+		}
+
+		if !inhibitHooks {
+			if err := runAppArmorParser("-R", profileFile); err != nil {
+				log.Printf("WARNING: failed to unload %s: %s", profileFile, err)
+			}
+		}
+
+		if err := os.Remove(profileFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"launchpad.net/snappy/helpers"
+
+	. "launchpad.net/gocheck"
+)
+
+const appArmorPackageYaml = `name: foo
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+binaries:
+ - name: bin/foo
+ - name: bin/bar
+   security-policy:
+     apparmor: meta/bar.profile
+services:
+ - name: svc
+   start: bin/foo
+`
+
+const testAppArmorTemplate = `# Description: test template
+# Usage: common
+###ENDUSAGE###
+###VAR###
+###PROFILEATTACH### {
+  ###POLICYGROUPS###
+  ###WRITES###
+}
+`
+
+func (s *SnapTestSuite) writeAppArmorPolicy(c *C, dir, name, content string) {
+	c.Assert(os.MkdirAll(dir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), IsNil)
+}
+
+func (s *SnapTestSuite) TestDbusPath(c *C) {
+	c.Assert(dbusPath("foo_bar_1.0"), Equals, "foo_5fbar_5f1_2e0")
+	c.Assert(dbusPath("Foo2"), Equals, "Foo2")
+}
+
+func (s *SnapTestSuite) TestGenerateAppArmorProfileFromSystemPolicy(c *C) {
+	s.writeAppArmorPolicy(c, snapAppArmorTemplatesDir, "test", testAppArmorTemplate)
+	s.writeAppArmorPolicy(c, snapAppArmorPolicyGroupsDir, "network-client", "# Usage: common\n#include <abstractions/nameservice>\n")
+	s.writeAppArmorPolicy(c, snapAppArmorPolicyGroupsDir, "video", "/dev/video* rw,\n")
+
+	m, err := parsePackageYamlData([]byte(appArmorPackageYaml))
+	c.Assert(err, IsNil)
+	policy := &appArmorPolicy{
+		Template:     "test",
+		PolicyGroups: []string{"network-client", "video"},
+		WritePath:    []string{"/dev/ttyUSB0", "/sys/devices/gpio1/"},
+	}
+
+	profile, err := generateAppArmorProfile(m, "foo", policy)
+	c.Assert(err, IsNil)
+	c.Assert(profile, Equals, `@{APP_APPNAME}="foo"
+@{APP_ID_DBUS}="foo_5ffoo_5f1_2e0"
+@{APP_PKGNAME_DBUS}="foo"
+@{APP_PKGNAME}="foo"
+@{APP_VERSION}="1.0"
+@{INSTALL_DIR}="{/apps,/oem}"
+profile "foo_foo_1.0" {
+  # the launcher, services start it in this profile already
+  /usr/bin/snappy mr,
+  /var/lib/snappy/seccomp/filters/@{APP_PKGNAME}_@{APP_APPNAME}_@{APP_VERSION} r,
+  owner @{PROC}/@{pid}/task/@{tid}/attr/current r,
+
+  # policy group network-client
+  # Usage: common
+  #include <abstractions/nameservice>
+  # policy group video
+  /dev/video* rw,
+  "/dev/ttyUSB0" rwk,
+  "/sys/devices/gpio1/" rwk,
+  "/sys/devices/gpio1/**" rwk,
+}
+`)
+}
+
+func (s *SnapTestSuite) TestGenerateAppArmorProfileBuiltinDefault(c *C) {
+	m, err := parsePackageYamlData([]byte(appArmorPackageYaml))
+	c.Assert(err, IsNil)
+
+	policy, err := snapAppPolicy("", snapApp{name: "svc"})
+	c.Assert(err, IsNil)
+	profile, err := generateAppArmorProfile(m, "svc", policy)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(profile, "#include <tunables/global>\n"), Equals, true)
+	c.Assert(strings.Contains(profile, "\nprofile \"foo_svc_1.0\" (attach_disconnected) {\n"), Equals, true)
+	c.Assert(strings.Contains(profile, "\n  # policy group network-client\n"), Equals, true)
+	c.Assert(strings.Contains(profile, "\n  /usr/bin/snappy mr,\n"), Equals, true)
+	c.Assert(strings.Contains(profile, "###"), Equals, false)
+}
+
+func (s *SnapTestSuite) TestGenerateAppArmorProfileUnknownPolicy(c *C) {
+	m, err := parsePackageYamlData([]byte(appArmorPackageYaml))
+	c.Assert(err, IsNil)
+
+	_, err = generateAppArmorProfile(m, "svc", &appArmorPolicy{Template: "no-such-template"})
+	c.Assert(err, ErrorMatches, `unknown security-template "no-such-template"`)
+
+	_, err = generateAppArmorProfile(m, "svc", &appArmorPolicy{PolicyGroups: []string{"../../etc/passwd"}})
+	c.Assert(err, ErrorMatches, `unknown cap "../../etc/passwd"`)
+
+	s.writeAppArmorPolicy(c, snapAppArmorTemplatesDir, "test", "###VAR###\n")
+	_, err = generateAppArmorProfile(m, "svc", &appArmorPolicy{Template: "test"})
+	c.Assert(err, ErrorMatches, `security-template "test" does not start a profile with ###PROFILEATTACH###`)
+}
+
+func (s *SnapTestSuite) TestAppArmorAppPolicyOverride(c *C) {
+	baseDir := c.MkDir()
+	s.writeAppArmorPolicy(c, filepath.Join(baseDir, "meta"), "svc.override", `{
+  "template": "test",
+  "policy_groups": ["video"],
+  "abstractions": ["audio"],
+  "read_path": ["/etc/foo.conf"],
+  "write_path": ["/run/foo/"]
+}`)
+	app := snapApp{name: "svc", SecurityDefinitions: SecurityDefinitions{
+		SecurityCaps:     []string{"network-client"},
+		SecurityOverride: &SecurityOverrideDefinition{Apparmor: "meta/svc.override"},
+	}}
+
+	policy, err := snapAppPolicy(baseDir, app)
+	c.Assert(err, IsNil)
+	c.Assert(policy, DeepEquals, &appArmorPolicy{
+		Template:     "test",
+		PolicyGroups: []string{"network-client", "video"},
+		Abstractions: []string{"audio"},
+		ReadPath:     []string{"/etc/foo.conf"},
+		WritePath:    []string{"/run/foo/"},
+	})
+	// the caps of the app are not changed
+	c.Assert(app.SecurityCaps, DeepEquals, Caps{"network-client"})
+
+	s.writeAppArmorPolicy(c, snapAppArmorTemplatesDir, "test", "###PROFILEATTACH### {\n###ABSTRACTIONS###\n###READS###\n###WRITES###\n}\n")
+	s.writeAppArmorPolicy(c, snapAppArmorPolicyGroupsDir, "video", "/dev/video* rw,\n")
+	m, err := parsePackageYamlData([]byte(appArmorPackageYaml))
+	c.Assert(err, IsNil)
+	profile, err := generateAppArmorProfile(m, "svc", policy)
+	c.Assert(err, IsNil)
+	c.Assert(profile, Equals, `profile "foo_svc_1.0" {`+appArmorLauncherRules+`
+#include <abstractions/audio>
+"/etc/foo.conf" rk,
+"/run/foo/" rwk,
+  "/run/foo/**" rwk,
+}
+`)
+
+	policy.Abstractions = []string{"../../etc/passwd"}
+	_, err = generateAppArmorProfile(m, "svc", policy)
+	c.Assert(err, ErrorMatches, `invalid abstraction "../../etc/passwd"`)
+}
+
+func (s *SnapTestSuite) TestAppArmorProfilesOnInstallAndRemove(c *C) {
+	var parserArgs [][]string
+	runAppArmorParser = func(args ...string) error {
+		parserArgs = append(parserArgs, args)
+		return nil
+	}
+
+	sourceDir := makeExampleSnapSourceDir(c, appArmorPackageYaml)
+	hooksDir := filepath.Join(sourceDir, "meta", "hooks")
+	c.Assert(os.MkdirAll(hooksDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooksDir, "config"), []byte("#!/bin/sh\n"), 0755), IsNil)
+	const barProfile = "profile \"foo_bar_1.0\" {}\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(sourceDir, "meta", "bar.profile"), []byte(barProfile), 0644), IsNil)
+	var snapFile string
+	err := helpers.ChDir(sourceDir, func() {
+		var err error
+		snapFile, err = Build(sourceDir, "")
+		c.Assert(err, IsNil)
+	})
+	c.Assert(err, IsNil)
+	c.Assert(installClick(filepath.Join(sourceDir, snapFile), 0, nil), IsNil)
+
+	svcProfile := filepath.Join(snapAppArmorProfilesDir, "foo_svc_1.0")
+	profiles := []string{
+		svcProfile,
+		filepath.Join(snapAppArmorProfilesDir, "foo_foo_1.0"),
+		filepath.Join(snapAppArmorProfilesDir, "foo_bar_1.0"),
+		filepath.Join(snapAppArmorProfilesDir, "foo_snappy-config_1.0"),
+	}
+	for _, profile := range profiles {
+		c.Assert(helpers.FileExists(profile), Equals, true)
+	}
+	c.Assert(parserArgs, DeepEquals, [][]string{
+		{"-r", profiles[0]}, {"-r", profiles[1]}, {"-r", profiles[2]}, {"-r", profiles[3]},
+	})
+
+	content, err := ioutil.ReadFile(svcProfile)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(content), "\nprofile \"foo_svc_1.0\" (attach_disconnected) {\n"), Equals, true)
+	// the security-policy is used as it is
+	content, err = ioutil.ReadFile(profiles[2])
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, barProfile)
+
+	parserArgs = nil
+	c.Assert(removeClick(filepath.Join(snapAppsDir, "foo", "1.0")), IsNil)
+	for _, profile := range profiles {
+		c.Assert(helpers.FileExists(profile), Equals, false)
+	}
+	c.Assert(parserArgs, DeepEquals, [][]string{
+		{"-R", profiles[0]}, {"-R", profiles[1]}, {"-R", profiles[2]}, {"-R", profiles[3]},
+	})
+}
+
+func (s *SnapTestSuite) TestAppArmorProfileFromIntegration(c *C) {
+	baseDir := c.MkDir()
+	const legacyProfile = "profile \"foo_legacy_1.0\" {}\n"
+	s.writeAppArmorPolicy(c, filepath.Join(baseDir, "meta"), "legacy.profile", legacyProfile)
+	m, err := parsePackageYamlData([]byte(appArmorPackageYaml + `integration:
+ legacy:
+   apparmor-profile: meta/legacy.profile
+`))
+	c.Assert(err, IsNil)
+
+	// older snaps ship the profile in the integration section, snappy
+	// loads it instead of the apparmor click hook
+	profile, err := snapAppArmorProfile(m, baseDir, snapApp{name: "legacy"}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(profile, Equals, legacyProfile)
+}
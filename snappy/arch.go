@@ -18,7 +18,13 @@
 package snappy
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
 	"launchpad.net/snappy/helpers"
+
+	"gopkg.in/yaml.v2"
 )
 
 // ArchitectureType is the type for a supported snappy architecture
@@ -44,3 +50,54 @@ func Architecture() ArchitectureType {
 func SetArchitecture(newArch ArchitectureType) {
 	arch = newArch
 }
+
+// the /etc/snappy/architectures.yaml file
+type architecturesConfig struct {
+	// architectures whose snaps can run on this system too, e.g.
+	// i386 on amd64
+	ForeignArchitectures []ArchitectureType `yaml:"foreign-architectures"`
+}
+
+// foreignArchitectures returns the configured foreign architectures
+func foreignArchitectures() ([]ArchitectureType, error) {
+	content, err := ioutil.ReadFile(snappyArchitecturesConfig)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg architecturesConfig
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", snappyArchitecturesConfig, err)
+	}
+
+	return cfg.ForeignArchitectures, nil
+}
+
+// checkArchitectures returns a error unless the given architectures of
+// a snap include "all", the native or a foreign architecture
+func checkArchitectures(name string, architectures []string) error {
+	foreign, err := foreignArchitectures()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range architectures {
+		if a == "all" || ArchitectureType(a) == Architecture() {
+			return nil
+		}
+		for _, f := range foreign {
+			if ArchitectureType(a) == f {
+				return nil
+			}
+		}
+	}
+
+	return &ErrArchitectureNotSupported{
+		snap:          name,
+		architectures: architectures,
+		arch:          Architecture(),
+	}
+}
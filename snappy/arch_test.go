@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+)
+
+const armhfPackageYaml = `name: foo
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+architecture: armhf
+`
+
+func (s *SnapTestSuite) TestCheckArchitectures(c *C) {
+	origArch := Architecture()
+	defer SetArchitecture(origArch)
+	SetArchitecture(ArchAmd64)
+
+	c.Assert(checkArchitectures("foo", []string{"all"}), IsNil)
+	c.Assert(checkArchitectures("foo", []string{"armhf", "amd64"}), IsNil)
+
+	err := checkArchitectures("foo", []string{"armhf", "i386"})
+	c.Assert(err, FitsTypeOf, &ErrArchitectureNotSupported{})
+	c.Assert(err, ErrorMatches, "foo is for armhf, i386 and does not run on amd64")
+}
+
+func (s *SnapTestSuite) TestCheckArchitecturesForeign(c *C) {
+	origArch := Architecture()
+	defer SetArchitecture(origArch)
+	SetArchitecture(ArchAmd64)
+
+	c.Assert(os.MkdirAll(filepath.Dir(snappyArchitecturesConfig), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(snappyArchitecturesConfig, []byte("foreign-architectures: [i386]\n"), 0644), IsNil)
+
+	c.Assert(checkArchitectures("foo", []string{"i386"}), IsNil)
+	c.Assert(checkArchitectures("foo", []string{"armhf"}), NotNil)
+}
+
+func (s *SnapTestSuite) TestInstallWrongArchitecture(c *C) {
+	origArch := Architecture()
+	defer SetArchitecture(origArch)
+	SetArchitecture(ArchAmd64)
+
+	snapFile := makeTestSnapPackage(c, armhfPackageYaml)
+	err := installClick(snapFile, 0, nil)
+	c.Assert(err, FitsTypeOf, &ErrArchitectureNotSupported{})
+	_, err = os.Stat(filepath.Join(snapAppsDir, "foo", "1.0"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// unless asked to
+	c.Assert(installClick(snapFile, IgnoreArchitecture, nil), IsNil)
+	c.Assert(ActiveSnapByName("foo"), NotNil)
+}
@@ -82,14 +82,13 @@ var shouldExclude = regexp.MustCompile(strings.Join([]string{
 	`^{arch}$`,
 }, "|")).MatchString
 
-const defaultApparmorJSON = `{
-    "template": "default",
-    "policy_groups": [
-        "networking"
-    ],
-    "policy_vendor": "ubuntu-snappy",
-    "policy_version": 1.3
-}`
+// the .apparmor json of the click apparmor hook
+type apparmorJSON struct {
+	Template      string   `json:"template"`
+	PolicyGroups  []string `json:"policy_groups"`
+	PolicyVendor  string   `json:"policy_vendor"`
+	PolicyVersion float64  `json:"policy_version"`
+}
 
 // small helper that return the architecture or "multi" if its multiple arches
 func debArchitecture(m *packageYaml) string {
@@ -137,14 +136,8 @@ func handleBinaries(buildDir string, m *packageYaml) error {
 		}
 		m.Integration[hookName]["bin-path"] = v.Name
 
-		_, hasApparmor := m.Integration[hookName]["apparmor"]
-		_, hasApparmorProfile := m.Integration[hookName]["apparmor-profile"]
-		if !hasApparmor && !hasApparmorProfile {
-			defaultApparmorJSONFile := filepath.Join("meta", hookName+".apparmor")
-			if err := ioutil.WriteFile(filepath.Join(buildDir, defaultApparmorJSONFile), []byte(defaultApparmorJSON), 0644); err != nil {
-				return err
-			}
-			m.Integration[hookName]["apparmor"] = defaultApparmorJSONFile
+		if err := handleApparmor(buildDir, m, hookName, &v.SecurityDefinitions); err != nil {
+			return err
 		}
 	}
 
@@ -169,6 +162,11 @@ func handleServices(buildDir string, m *packageYaml) error {
 			v.Description = description
 		}
 
+		// generate apparmor
+		if err := handleApparmor(buildDir, m, hookName, &v.SecurityDefinitions); err != nil {
+			return err
+		}
+
 		// omit the name from the json to make the
 		// click-reviewers-tool happy
 		v.Name = ""
@@ -181,35 +179,85 @@ func handleServices(buildDir string, m *packageYaml) error {
 			return err
 		}
 		m.Integration[hookName]["snappy-systemd"] = snappySystemdContentFile
-
-		// generate apparmor
-		_, hasApparmor := m.Integration[hookName]["apparmor"]
-		_, hasApparmorProfile := m.Integration[hookName]["apparmor-profile"]
-		if !hasApparmor && !hasApparmorProfile {
-			defaultApparmorJSONFile := filepath.Join("meta", hookName+".apparmor")
-			if err := ioutil.WriteFile(filepath.Join(buildDir, defaultApparmorJSONFile), []byte(defaultApparmorJSON), 0644); err != nil {
-				return err
-			}
-			m.Integration[hookName]["apparmor"] = defaultApparmorJSONFile
-		}
 	}
 
 	return nil
 }
 
-func handleConfigHookApparmor(buildDir string, m *packageYaml) error {
-	configHookFile := filepath.Join(buildDir, "meta", "hooks", "config")
-	if !helpers.FileExists(configHookFile) {
+// handleApparmor sets the apparmor click hook of the app from its
+// security fields, unless the integration section already has one
+func handleApparmor(buildDir string, m *packageYaml, hookName string, s *SecurityDefinitions) error {
+	_, hasApparmor := m.Integration[hookName]["apparmor"]
+	_, hasApparmorProfile := m.Integration[hookName]["apparmor-profile"]
+	if hasApparmor || hasApparmorProfile {
+		return nil
+	}
+
+	if s.SecurityPolicy != nil && s.SecurityPolicy.Apparmor != "" {
+		if !helpers.FileExists(filepath.Join(buildDir, s.SecurityPolicy.Apparmor)) {
+			return fmt.Errorf("security-policy of %s: %s does not exist", hookName, s.SecurityPolicy.Apparmor)
+		}
+		m.Integration[hookName]["apparmor-profile"] = s.SecurityPolicy.Apparmor
 		return nil
 	}
 
-	hookName := "snappy-config"
-	defaultApparmorJSONFile := filepath.Join("meta", hookName+".apparmor")
-	if err := ioutil.WriteFile(filepath.Join(buildDir, defaultApparmorJSONFile), []byte(defaultApparmorJSON), 0644); err != nil {
+	if s.SecurityOverride != nil && s.SecurityOverride.Apparmor != "" {
+		if !helpers.FileExists(filepath.Join(buildDir, s.SecurityOverride.Apparmor)) {
+			return fmt.Errorf("security-override of %s: %s does not exist", hookName, s.SecurityOverride.Apparmor)
+		}
+		m.Integration[hookName]["apparmor"] = s.SecurityOverride.Apparmor
+		return nil
+	}
+
+	return writeApparmorJSON(buildDir, m, hookName, s)
+}
+
+// writeApparmorJSON writes the meta/<hookName>.apparmor json with the
+// template and caps of the app
+func writeApparmorJSON(buildDir string, m *packageYaml, hookName string, s *SecurityDefinitions) error {
+	aaJSON := apparmorJSON{
+		Template:      defaultSecurityTemplate,
+		PolicyGroups:  []string{defaultSecurityCap},
+		PolicyVendor:  "ubuntu-snappy",
+		PolicyVersion: 1.3,
+	}
+	if s.SecurityTemplate != "" {
+		aaJSON.Template = s.SecurityTemplate
+	}
+	if s.SecurityCaps != nil {
+		aaJSON.PolicyGroups = s.SecurityCaps
+	}
+
+	content, err := json.MarshalIndent(aaJSON, "", "    ")
+	if err != nil {
 		return err
 	}
-	m.Integration[hookName] = make(map[string]string)
-	m.Integration[hookName]["apparmor"] = defaultApparmorJSONFile
+
+	apparmorJSONFile := filepath.Join("meta", hookName+".apparmor")
+	if err := ioutil.WriteFile(filepath.Join(buildDir, apparmorJSONFile), content, 0644); err != nil {
+		return err
+	}
+	if _, ok := m.Integration[hookName]; !ok {
+		m.Integration[hookName] = make(map[string]string)
+	}
+	m.Integration[hookName]["apparmor"] = apparmorJSONFile
+
+	return nil
+}
+
+func handleSnapHooksApparmor(buildDir string, m *packageYaml) error {
+	for _, hook := range snapHooks {
+		hookFile := filepath.Join(buildDir, "meta", "hooks", hook)
+		if !helpers.FileExists(hookFile) {
+			continue
+		}
+
+		hookName := snapHookAppArmorName(hook)
+		m.Integration[hookName] = make(map[string]string)
+		if err := writeApparmorJSON(buildDir, m, hookName, &SecurityDefinitions{}); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -457,6 +505,9 @@ func Build(sourceDir, targetDir string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if err := m.validate(); err != nil {
+		return "", err
+	}
 
 	if m.ExplicitLicenseAgreement {
 		err = licenseChecker(sourceDir)
@@ -502,8 +553,8 @@ func Build(sourceDir, targetDir string) (string, error) {
 		return "", err
 	}
 
-	// generate config hook apparmor
-	if err := handleConfigHookApparmor(buildDir, m); err != nil {
+	// generate apparmor for the meta/hooks
+	if err := handleSnapHooksApparmor(buildDir, m); err != nil {
 		return "", err
 	}
 
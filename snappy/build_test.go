@@ -240,6 +240,77 @@ vendor: Foo <foo@example.com>
 }`
 }
 
+func (s *SnapTestSuite) TestBuildSecurityFields(c *C) {
+	sourceDir := makeExampleSnapSourceDir(c, `name: hello
+version: 5.0.1
+vendor: Foo <foo@example.com>
+services:
+ - name: svc
+   start: bin/hello-world
+   security-template: nondefault
+   caps:
+    - network-client
+    - video
+binaries:
+ - name: bin/raw
+   security-policy:
+     apparmor: meta/raw.profile
+ - name: bin/override
+   security-override:
+     apparmor: meta/override.json
+`)
+	for _, f := range []string{"raw.profile", "override.json"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(sourceDir, "meta", f), nil, 0644), IsNil)
+	}
+
+	resultSnap, err := Build(sourceDir, "")
+	c.Assert(err, IsNil)
+	defer os.Remove(resultSnap)
+
+	unpackDir := c.MkDir()
+	err = exec.Command("dpkg-deb", "-x", resultSnap, unpackDir).Run()
+	c.Assert(err, IsNil)
+
+	manifestData, err := exec.Command("dpkg-deb", "-I", resultSnap, "manifest").Output()
+	c.Assert(err, IsNil)
+	manifest, err := readClickManifest(manifestData)
+	c.Assert(err, IsNil)
+	c.Assert(manifest.Hooks["svc"]["apparmor"], Equals, "meta/svc.apparmor")
+	c.Assert(manifest.Hooks["raw"]["apparmor-profile"], Equals, "meta/raw.profile")
+	c.Assert(manifest.Hooks["override"]["apparmor"], Equals, "meta/override.json")
+
+	content, err := ioutil.ReadFile(filepath.Join(unpackDir, "meta", "svc.apparmor"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, `{
+    "template": "nondefault",
+    "policy_groups": [
+        "network-client",
+        "video"
+    ],
+    "policy_vendor": "ubuntu-snappy",
+    "policy_version": 1.3
+}`)
+
+	// the security fields are not part of the systemd hook
+	content, err = ioutil.ReadFile(filepath.Join(unpackDir, "meta", "svc.snappy-systemd"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(content), "nondefault"), Equals, false)
+}
+
+func (s *SnapTestSuite) TestBuildSecurityPolicyMissing(c *C) {
+	sourceDir := makeExampleSnapSourceDir(c, `name: hello
+version: 5.0.1
+vendor: Foo <foo@example.com>
+binaries:
+ - name: bin/raw
+   security-policy:
+     apparmor: meta/raw.profile
+`)
+
+	_, err := Build(sourceDir, "")
+	c.Assert(err, ErrorMatches, "security-policy of raw: meta/raw.profile does not exist")
+}
+
 func (s *SnapTestSuite) TestBuildNoManifestFails(c *C) {
 	sourceDir := makeExampleSnapSourceDir(c, "")
 	c.Assert(os.Remove(filepath.Join(sourceDir, "meta", "package.yaml")), IsNil)
@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"launchpad.net/snappy/helpers"
+)
+
+// the maximum size of all snaps in the download cache, the oldest
+// snaps are removed if the cache grows beyond it
+var maxCacheSize int64 = 512 * 1024 * 1024
+
+// CachedSnap is a downloaded snap file kept in the download cache
+type CachedSnap struct {
+	Name    string
+	Version string
+	Sha512  string
+	Size    int64
+	Date    time.Time
+
+	path string
+}
+
+// the cached snap files are named "name_version_sha512.snap"
+func cachedSnapPath(name, version, sha512 string) string {
+	return filepath.Join(snapCacheDir, fmt.Sprintf("%s_%s_%s.snap", name, version, sha512))
+}
+
+// byCacheDate sorts cached snaps with the oldest one first
+type byCacheDate []CachedSnap
+
+func (bd byCacheDate) Less(a, b int) bool {
+	return bd[a].Date.Before(bd[b].Date)
+}
+func (bd byCacheDate) Swap(a, b int) {
+	bd[a], bd[b] = bd[b], bd[a]
+}
+func (bd byCacheDate) Len() int {
+	return len(bd)
+}
+
+// CachedSnaps returns the snaps in the download cache, the least
+// recently used one first
+func CachedSnaps() (snaps []CachedSnap, err error) {
+	files, err := ioutil.ReadDir(snapCacheDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".snap") {
+			continue
+		}
+		l := strings.Split(strings.TrimSuffix(fi.Name(), ".snap"), "_")
+		if len(l) != 3 {
+			continue
+		}
+
+		snaps = append(snaps, CachedSnap{
+			Name:    l[0],
+			Version: l[1],
+			Sha512:  l[2],
+			Size:    fi.Size(),
+			Date:    fi.ModTime(),
+			path:    filepath.Join(snapCacheDir, fi.Name()),
+		})
+	}
+	sort.Sort(byCacheDate(snaps))
+
+	return snaps, nil
+}
+
+// cachedSnapFile returns the cached snap file for the given snap or ""
+// if it is not in the cache. If sha512 is empty any cached file for
+// the given version is used.
+func cachedSnapFile(name, version, sha512 string) string {
+	snaps, err := CachedSnaps()
+	if err != nil {
+		return ""
+	}
+
+	for _, snap := range snaps {
+		if snap.Name != name || snap.Version != version {
+			continue
+		}
+		if sha512 != "" && snap.Sha512 != sha512 {
+			continue
+		}
+
+		// the file may have been damaged since it was downloaded
+		got, err := helpers.Sha512sum(snap.path)
+		if err != nil || got != snap.Sha512 {
+			log.Printf("WARNING: removing damaged %s from the cache", snap.path)
+			os.Remove(snap.path)
+			continue
+		}
+
+		// mark it as recently used
+		now := time.Now()
+		os.Chtimes(snap.path, now, now)
+
+		return snap.path
+	}
+
+	return ""
+}
+
+// isCachedSnapFile returns true if the given snap file is in the
+// download cache
+func isCachedSnapFile(snapFile string) bool {
+	dir, err := filepath.Abs(filepath.Dir(snapFile))
+	if err != nil {
+		return false
+	}
+	cacheDir, err := filepath.Abs(snapCacheDir)
+	if err != nil {
+		return false
+	}
+
+	return dir == cacheDir
+}
+
+// addToCache moves the given verified snap file into the download
+// cache and returns its new path
+func addToCache(snapFile, name, version, sha512 string) (string, error) {
+	if err := helpers.EnsureDir(snapCacheDir, 0755); err != nil {
+		return "", err
+	}
+
+	cached := cachedSnapPath(name, version, sha512)
+	if err := os.Rename(snapFile, cached); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if err := os.Chtimes(cached, now, now); err != nil {
+		return "", err
+	}
+
+	if err := trimCache(cached); err != nil {
+		log.Printf("WARNING: can not trim the download cache: %s", err)
+	}
+
+	return cached, nil
+}
+
+// trimCache removes the least recently used snaps from the cache
+// until it is no bigger than maxCacheSize, the snap with the given
+// path is never removed
+func trimCache(keep string) error {
+	snaps, err := CachedSnaps()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, snap := range snaps {
+		size += snap.Size
+	}
+
+	for _, snap := range snaps {
+		if size <= maxCacheSize {
+			break
+		}
+		if snap.path == keep {
+			continue
+		}
+		if err := os.Remove(snap.path); err != nil {
+			return err
+		}
+		size -= snap.Size
+	}
+
+	return nil
+}
+
+// CleanCache removes all snaps from the download cache
+func CleanCache() error {
+	snaps, err := CachedSnaps()
+	if err != nil {
+		return err
+	}
+
+	for _, snap := range snaps {
+		if err := os.Remove(snap.path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
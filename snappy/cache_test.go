@@ -0,0 +1,195 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"launchpad.net/snappy/helpers"
+
+	. "launchpad.net/gocheck"
+)
+
+// addTestSnapToCache adds a snap with the given package.yaml to the
+// download cache and returns its sha512
+func addTestSnapToCache(c *C, name, version string) string {
+	snapFile := makeTestSnapPackage(c, "name: "+name+"\nversion: "+version+"\nvendor: Foo Bar <foo@example.com>\n")
+	sha512, err := helpers.Sha512sum(snapFile)
+	c.Assert(err, IsNil)
+
+	_, err = addToCache(snapFile, name, version, sha512)
+	c.Assert(err, IsNil)
+
+	return sha512
+}
+
+func (s *SnapTestSuite) TestCachedSnapsEmpty(c *C) {
+	cached, err := CachedSnaps()
+	c.Assert(err, IsNil)
+	c.Assert(cached, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestCacheAddAndList(c *C) {
+	sha512 := addTestSnapToCache(c, "foo", "1.0")
+
+	cached, err := CachedSnaps()
+	c.Assert(err, IsNil)
+	c.Assert(cached, HasLen, 1)
+	c.Assert(cached[0].Name, Equals, "foo")
+	c.Assert(cached[0].Version, Equals, "1.0")
+	c.Assert(cached[0].Sha512, Equals, sha512)
+
+	c.Assert(cachedSnapFile("foo", "1.0", sha512), Equals, cachedSnapPath("foo", "1.0", sha512))
+	c.Assert(cachedSnapFile("foo", "1.0", ""), Equals, cachedSnapPath("foo", "1.0", sha512))
+	c.Assert(cachedSnapFile("foo", "1.0", "1234"), Equals, "")
+	c.Assert(cachedSnapFile("foo", "2.0", ""), Equals, "")
+}
+
+func (s *SnapTestSuite) TestCacheDamagedSnap(c *C) {
+	sha512 := addTestSnapToCache(c, "foo", "1.0")
+	cached := cachedSnapPath("foo", "1.0", sha512)
+	c.Assert(ioutil.WriteFile(cached, []byte("damaged"), 0644), IsNil)
+
+	c.Assert(cachedSnapFile("foo", "1.0", sha512), Equals, "")
+	c.Assert(helpers.FileExists(cached), Equals, false)
+}
+
+func (s *SnapTestSuite) TestCacheTrim(c *C) {
+	fooSha := addTestSnapToCache(c, "foo", "1.0")
+	barSha := addTestSnapToCache(c, "bar", "1.0")
+
+	// make foo the least recently used one
+	old := time.Now().Add(-time.Hour)
+	c.Assert(os.Chtimes(cachedSnapPath("foo", "1.0", fooSha), old, old), IsNil)
+
+	// room for one snap only
+	oldMaxCacheSize := maxCacheSize
+	defer func() { maxCacheSize = oldMaxCacheSize }()
+	st, err := os.Stat(cachedSnapPath("bar", "1.0", barSha))
+	c.Assert(err, IsNil)
+	maxCacheSize = st.Size() + 1
+
+	c.Assert(trimCache(""), IsNil)
+	cached, err := CachedSnaps()
+	c.Assert(err, IsNil)
+	c.Assert(cached, HasLen, 1)
+	c.Assert(cached[0].Name, Equals, "bar")
+
+	// the newly added snap is kept even if it is too big
+	maxCacheSize = 1
+	addTestSnapToCache(c, "baz", "1.0")
+	cached, err = CachedSnaps()
+	c.Assert(err, IsNil)
+	c.Assert(cached, HasLen, 1)
+	c.Assert(cached[0].Name, Equals, "baz")
+}
+
+func (s *SnapTestSuite) TestCacheClean(c *C) {
+	addTestSnapToCache(c, "foo", "1.0")
+	addTestSnapToCache(c, "bar", "1.0")
+
+	c.Assert(CleanCache(), IsNil)
+	cached, err := CachedSnaps()
+	c.Assert(err, IsNil)
+	c.Assert(cached, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapInstallUsesCache(c *C) {
+	snapPackage := makeTestSnapPackage(c, "name: foo\nversion: 1.0\nvendor: Foo Bar <foo@example.com>\n")
+	sha512, err := helpers.Sha512sum(snapPackage)
+	c.Assert(err, IsNil)
+
+	downloads := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		f, err := os.Open(snapPackage)
+		c.Assert(err, IsNil)
+		defer f.Close()
+		io.Copy(w, f)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.Version = "1.0"
+	snap.pkg.AnonDownloadURL = mockServer.URL + "/snap"
+	snap.pkg.DownloadSha512 = sha512
+
+	c.Assert(snap.Install(nil, AllowUnauthenticated), IsNil)
+	c.Assert(downloads, Equals, 1)
+	// the downloaded snap is kept
+	c.Assert(helpers.FileExists(cachedSnapPath("foo", "1.0", sha512)), Equals, true)
+
+	// and used instead of downloading it again
+	snapFile, err := snap.downloadOrCached(nil)
+	c.Assert(err, IsNil)
+	c.Assert(snapFile, Equals, cachedSnapPath("foo", "1.0", sha512))
+	c.Assert(downloads, Equals, 1)
+}
+
+func (s *SnapTestSuite) TestRollbackFromCache(c *C) {
+	addTestSnapToCache(c, "foo", "1.0")
+
+	snapFile := makeTestSnapPackage(c, "name: foo\nversion: 2.0\nvendor: Foo Bar <foo@example.com>\n")
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+	c.Assert(ActiveSnapByName("foo").Version(), Equals, "2.0")
+
+	// 1.0 is not installed but in the cache
+	version, err := Rollback("foo", "", 0)
+	c.Assert(err, IsNil)
+	c.Assert(version, Equals, "1.0")
+	c.Assert(ActiveSnapByName("foo").Version(), Equals, "1.0")
+}
+
+func (s *SnapTestSuite) TestRollbackFromCachePassesFlags(c *C) {
+	addTestSnapToCache(c, "foo", "1.0")
+
+	snapFile := makeTestSnapPackage(c, "name: foo\nversion: 2.0\nvendor: Foo Bar <foo@example.com>\n")
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	var allowUnauthenticated bool
+	verifySnapSignature = func(snapFile string, allowUnauth bool) error {
+		allowUnauthenticated = allowUnauth
+		return nil
+	}
+	_, err := Rollback("foo", "1.0", AllowUnauthenticated)
+	c.Assert(err, IsNil)
+	c.Assert(allowUnauthenticated, Equals, true)
+}
+
+func (s *SnapTestSuite) TestIsCachedSnapFile(c *C) {
+	c.Assert(isCachedSnapFile(cachedSnapPath("foo", "1.0", "1234")), Equals, true)
+	c.Assert(isCachedSnapFile(filepath.Join(snapCacheDir, "sub", "..", "foo.snap")), Equals, true)
+	c.Assert(isCachedSnapFile(filepath.Join(snapCacheDir+"/", "foo.snap")), Equals, true)
+	c.Assert(isCachedSnapFile(filepath.Join(snapDownloadsDir, "foo.snap")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestRollbackNothingCached(c *C) {
+	snapFile := makeTestSnapPackage(c, "name: foo\nversion: 2.0\nvendor: Foo Bar <foo@example.com>\n")
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	_, err := Rollback("foo", "", 0)
+	c.Assert(err, ErrorMatches, "no version to rollback to")
+}
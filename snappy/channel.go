@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"launchpad.net/snappy/helpers"
+)
+
+// the channel used for snaps that do not track a channel yet
+const defaultChannel = "edge"
+
+// the channels the store knows about, from most to least stable
+var storeChannels = []string{"stable", "candidate", "beta", "edge"}
+
+// splitChannel takes a snap spec like "foo" or "foo/beta" and returns
+// the snap name and the channel. The channel is empty if the spec does
+// not contain one.
+func splitChannel(spec string) (name, channel string) {
+	l := strings.SplitN(spec, "/", 2)
+	if len(l) == 1 {
+		return spec, ""
+	}
+
+	return l[0], l[1]
+}
+
+// validChannel returns true if the given channel is known to the store
+func validChannel(channel string) bool {
+	for _, c := range storeChannels {
+		if c == channel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// snapChannel returns the channel that the snap with the given name
+// tracks, or the default channel if it does not track any
+func snapChannel(name string) string {
+	content, err := ioutil.ReadFile(filepath.Join(snapChannelsDir, name))
+	if err != nil {
+		return defaultChannel
+	}
+
+	channel := strings.TrimSpace(string(content))
+	if !validChannel(channel) {
+		return defaultChannel
+	}
+
+	return channel
+}
+
+// setSnapChannel remembers the channel that the snap with the given
+// name tracks
+func setSnapChannel(name, channel string) error {
+	if !validChannel(channel) {
+		return ErrInvalidChannel
+	}
+
+	if err := helpers.EnsureDir(snapChannelsDir, 0755); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(filepath.Join(snapChannelsDir, name), []byte(channel+"\n"), 0644)
+}
+
+// removeSnapChannel forgets the channel that the snap with the given
+// name tracks
+func removeSnapChannel(name string) error {
+	err := os.Remove(filepath.Join(snapChannelsDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// addChannelQuery returns the given store uri with the channel
+// added to its query
+func addChannelQuery(uri, channel string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("channel", channel)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "launchpad.net/gocheck"
+)
+
+func (s *SnapTestSuite) TestSplitChannel(c *C) {
+	name, channel := splitChannel("foo")
+	c.Assert(name, Equals, "foo")
+	c.Assert(channel, Equals, "")
+
+	name, channel = splitChannel("foo/beta")
+	c.Assert(name, Equals, "foo")
+	c.Assert(channel, Equals, "beta")
+}
+
+func (s *SnapTestSuite) TestSnapChannelDefault(c *C) {
+	c.Assert(snapChannel("foo"), Equals, "edge")
+}
+
+func (s *SnapTestSuite) TestSetSnapChannel(c *C) {
+	c.Assert(setSnapChannel("foo", "beta"), IsNil)
+	c.Assert(snapChannel("foo"), Equals, "beta")
+	c.Assert(snapChannel("bar"), Equals, "edge")
+
+	c.Assert(setSnapChannel("foo", "stable"), IsNil)
+	c.Assert(snapChannel("foo"), Equals, "stable")
+}
+
+func (s *SnapTestSuite) TestSetSnapChannelInvalid(c *C) {
+	c.Assert(setSnapChannel("foo", "alpha"), Equals, ErrInvalidChannel)
+	c.Assert(snapChannel("foo"), Equals, "edge")
+}
+
+func (s *SnapTestSuite) TestRemoveSnapChannel(c *C) {
+	c.Assert(setSnapChannel("foo", "beta"), IsNil)
+	c.Assert(removeSnapChannel("foo"), IsNil)
+	c.Assert(snapChannel("foo"), Equals, "edge")
+
+	// nothing to forget
+	c.Assert(removeSnapChannel("foo"), IsNil)
+}
+
+func (s *SnapTestSuite) TestRemoveForgetsChannel(c *C) {
+	installTestSnapVersion(c, "1.0")
+	installTestSnapVersion(c, "2.0")
+	c.Assert(setSnapChannel("foo", "beta"), IsNil)
+
+	// another version is still installed
+	c.Assert(Remove("foo=1.0", 0), IsNil)
+	c.Assert(snapChannel("foo"), Equals, "beta")
+
+	c.Assert(Remove("foo", 0), IsNil)
+	c.Assert(snapChannel("foo"), Equals, "edge")
+}
+
+func (s *SnapTestSuite) TestInstalledSnapChannel(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	snap := NewInstalledSnapPart(yamlFile)
+	c.Assert(snap.Channel(), Equals, "edge")
+
+	c.Assert(setSnapChannel("hello-app", "candidate"), IsNil)
+	c.Assert(snap.Channel(), Equals, "candidate")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsChannel(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.URL.Path, Equals, "/details/xkcd-webserver")
+		c.Assert(r.URL.Query().Get("channel"), Equals, "beta")
+		io.WriteString(w, MockDetailsJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	storeDetailsURI = mockServer.URL + "/details/%s"
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo, NotNil)
+
+	results, err := repo.Details("xkcd-webserver/beta")
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Assert(results[0].Name(), Equals, "xkcd-webserver")
+	c.Assert(results[0].Channel(), Equals, "beta")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsTrackedChannel(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.URL.Query().Get("channel"), Equals, "candidate")
+		io.WriteString(w, MockDetailsJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	c.Assert(setSnapChannel("xkcd-webserver", "candidate"), IsNil)
+
+	storeDetailsURI = mockServer.URL + "/details/%s"
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo, NotNil)
+
+	results, err := repo.Details("xkcd-webserver")
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Assert(results[0].Channel(), Equals, "candidate")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositorySearchChannel(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.URL.Query().Get("q"), Equals, "xkcd")
+		c.Assert(r.URL.Query().Get("channel"), Equals, "candidate")
+		io.WriteString(w, MockSearchJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	storeSearchURI = mockServer.URL + "/search?q=%s"
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo, NotNil)
+
+	results, err := repo.Search("xkcd/candidate")
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Assert(results[0].Channel(), Equals, "candidate")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryUpdatesPerChannel(c *C) {
+	var channels []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		channel := r.URL.Query().Get("channel")
+		channels = append(channels, channel)
+
+		jsonReq, err := ioutil.ReadAll(r.Body)
+		c.Assert(err, IsNil)
+		switch channel {
+		case "edge":
+			c.Assert(string(jsonReq), Equals, `{"name":["hello-world"]}`)
+			io.WriteString(w, MockUpdatesJSON)
+		case "beta":
+			c.Assert(string(jsonReq), Equals, `{"name":["xkcd-webserver"]}`)
+			io.WriteString(w, "[]")
+		default:
+			c.Fatalf("unexpected channel %q", channel)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	storeBulkURI = mockServer.URL + "/updates/"
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo, NotNil)
+
+	mockInstalledSnapNamesByType([]string{"hello-world", "xkcd-webserver"})
+	c.Assert(setSnapChannel("xkcd-webserver", "beta"), IsNil)
+
+	results, err := repo.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(channels, DeepEquals, []string{"beta", "edge"})
+	c.Assert(results, HasLen, 1)
+	c.Assert(results[0].Name(), Equals, "hello-world")
+	c.Assert(results[0].Channel(), Equals, "edge")
+}
+
+func (s *SnapTestSuite) TestRemoteSnapInstallRemembersChannel(c *C) {
+	snapPackage := makeTestSnapPackage(c, "")
+	snapR, err := os.Open(snapPackage)
+	c.Assert(err, IsNil)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, snapR)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	snap := NewRemoteSnapPart(remoteSnap{Name: "foo", Version: "1.0", AnonDownloadURL: mockServer.URL + "/snap"}, "beta")
+	c.Assert(snap.Install(&MockProgressMeter{}, 0), IsNil)
+
+	c.Assert(snapChannel("foo"), Equals, "beta")
+	c.Assert(ActiveSnapByName("foo").Channel(), Equals, "beta")
+}
+
+func (s *SnapTestSuite) TestInstallInvalidChannel(c *C) {
+	c.Assert(Install("foo/alpha", 0), Equals, ErrInvalidChannel)
+}
@@ -21,21 +21,28 @@ package snappy
    to install a "snap" package
    Limitations:
    - no per-user registration
-   - no user-level hooks
    - more(?)
 */
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"os/user"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
 
 	"launchpad.net/snappy/clickdeb"
@@ -65,73 +72,76 @@ type clickHook struct {
 	exec    string
 	user    string
 	pattern string
-}
 
-const (
-	// from debsig-verify-0.9/debsigs.h
-	dsSuccess           = 0
-	dsFailNosigs        = 10
-	dsFailUnknownOrigin = 11
-	dsFailNopolicies    = 12
-	dsFailBadsig        = 13
-	dsFailInternal      = 14
-)
+	// the hook is installed for each user and runs as that user
+	userLevel bool
+	// the hook exec runs once per transaction instead of once per app
+	trigger bool
+	// only the hook of one version of a snap is installed at a time
+	singleVersion bool
+}
 
 // ignore hooks of this type
 var ignoreHooks = map[string]bool{
 	"bin-path":       true,
 	"snappy-systemd": true,
+	// snappy generates and loads the profiles itself
+	"apparmor":         true,
+	"apparmor-profile": true,
 }
 
-// Execute the hook.Exec command
-func execHook(execCmd string) (err error) {
+// hookCommand returns the command for the hook.Exec that runs as the
+// given user
+func hookCommand(execCmd, username string) (*exec.Cmd, error) {
 	// the spec says this is passed to the shell
 	cmd := exec.Command("sh", "-c", execCmd)
-	if err = cmd.Run(); err != nil {
-		if exitCode, err := helpers.ExitCode(err); err != nil {
-			return &ErrHookFailed{cmd: execCmd,
-				exitCode: exitCode}
-		}
-		return err
+
+	// no need to change the user, we are not root
+	if username == "" || !helpers.ShouldDropPrivs() {
+		return cmd, nil
 	}
 
-	return nil
-}
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+	}
+	cmd.Env = append(os.Environ(), "HOME="+u.HomeDir, "USER="+u.Username)
 
-// This function checks if the given exitCode is "ok" when running with
-// --allow-unauthenticated. We allow package with no signature or with
-// a unknown policy or with no policies at all. We do not allow overriding
-// bad signatures
-func allowUnauthenticatedOkExitCode(exitCode int) bool {
-	return (exitCode == dsFailNosigs ||
-		exitCode == dsFailUnknownOrigin ||
-		exitCode == dsFailNopolicies)
+	return cmd, nil
 }
 
-// Tiny wrapper around the debsig-verify commandline
-func runDebsigVerifyImpl(clickFile string, allowUnauthenticated bool) (err error) {
-	cmd := exec.Command("debsig-verify", clickFile)
-	if err := cmd.Run(); err != nil {
-		exitCode, err := helpers.ExitCode(err)
-		if err == nil {
-			if allowUnauthenticated && allowUnauthenticatedOkExitCode(exitCode) {
-				log.Println("Signature check failed, but installing anyway as requested")
-				return nil
-			}
-			return &ErrSignature{exitCode: exitCode}
+// Execute the hook.Exec command as the given user
+func execHook(execCmd, username string) (err error) {
+	cmd, err := hookCommand(execCmd, username)
+	if err != nil {
+		return err
+	}
+	if err = cmd.Run(); err != nil {
+		if exitCode, err := helpers.ExitCode(err); err != nil {
+			return &ErrHookFailed{cmd: execCmd,
+				exitCode: exitCode}
 		}
-		// not a exit code error, something else, pass on
 		return err
 	}
+
 	return nil
 }
 
-var runDebsigVerify = runDebsigVerifyImpl
-
 func auditClick(snapFile string, allowUnauthenticated bool) (err error) {
 	// FIXME: check what more we need to do here, click is also doing
 	//        permission checks
-	return runDebsigVerify(snapFile, allowUnauthenticated)
+	return verifySnapSignature(snapFile, allowUnauthenticated)
 }
 
 func readClickManifest(data []byte) (manifest clickManifest, err error) {
@@ -141,6 +151,12 @@ func readClickManifest(data []byte) (manifest clickManifest, err error) {
 	return manifest, err
 }
 
+// hookFlag returns true if the given boolean hook field is set to "yes"
+func hookFlag(cfg *goconfigparser.ConfigParser, field string) bool {
+	value, _ := cfg.Get("hook", field)
+	return strings.ToLower(strings.TrimSpace(value)) == "yes"
+}
+
 func readClickHookFile(hookFile string) (hook clickHook, err error) {
 	// FIXME: fugly, write deb822 style parser if we keep this
 	// FIXME2: the hook file will go probably entirely and gets
@@ -160,10 +176,9 @@ func readClickHookFile(hookFile string) (hook clickHook, err error) {
 	hook.exec, _ = cfg.Get("hook", "Exec")
 	hook.user, _ = cfg.Get("hook", "User")
 	hook.pattern, _ = cfg.Get("hook", "Pattern")
-	// FIXME: error on supported hook features like
-	//    User-Level: yes
-	//    Trigger: yes
-	//    Single-Version: yes
+	hook.userLevel = hookFlag(cfg, "User-Level")
+	hook.trigger = hookFlag(cfg, "Trigger")
+	hook.singleVersion = hookFlag(cfg, "Single-Version")
 
 	// urgh, click allows empty "Hook-Name"
 	if hook.name == "" {
@@ -191,17 +206,111 @@ func systemClickHooks() (hooks map[string]clickHook, err error) {
 	return
 }
 
-func expandHookPattern(name, app, version, pattern string) (expanded string) {
-	id := fmt.Sprintf("%s_%s_%s", name, app, version)
-	// FIXME: support the other patterns (and see if they are used at all):
-	//        - short-id
-	//        - user (probably not!)
-	//        - home (probably not!)
-	//        - $$ (?)
-	return strings.Replace(pattern, "${id}", id, -1)
+// expandHookPattern expands the ${id}, ${short-id}, ${user} and
+// ${home} variables in the given hook pattern, "$$" is a literal "$"
+func expandHookPattern(name, app, version, username, home, pattern string) (expanded string) {
+	shortID := fmt.Sprintf("%s_%s", name, app)
+	r := strings.NewReplacer(
+		"$$", "$",
+		"${id}", shortID+"_"+version,
+		"${short-id}", shortID,
+		"${user}", username,
+		"${home}", home,
+	)
+
+	return r.Replace(pattern)
 }
 
-type iterHooksFunc func(src, dst string, systemHook clickHook) error
+// hookUser is a user that gets the user-level hooks
+type hookUser struct {
+	name string
+	home string
+}
+
+// the uids of regular users start here, the ones below are system users
+const firstRegularUID = 1000
+
+// the uid of the "nobody" user
+const nobodyUID = 65534
+
+// parsePasswdLine returns the hook user of a regular user in a passwd
+// line, ok is false for system users and lines that can not be parsed
+func parsePasswdLine(line string) (u hookUser, ok bool) {
+	fields := strings.Split(line, ":")
+	if len(fields) != 7 {
+		return u, false
+	}
+	uid, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil || uid < firstRegularUID || uid == nobodyUID {
+		return u, false
+	}
+	if fields[0] == "" || !filepath.IsAbs(fields[5]) {
+		return u, false
+	}
+
+	return hookUser{name: fields[0], home: filepath.Clean(fields[5])}, true
+}
+
+// hookUsers returns the users that user-level hooks are installed for,
+// that is every regular user in the passwd file whose home directory
+// exists. Entries that can not be used are skipped.
+func hookUsers() (users []hookUser, err error) {
+	f, err := os.Open(passwdFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		u, ok := parsePasswdLine(scanner.Text())
+		if !ok || seen[u.name] {
+			continue
+		}
+		if st, err := os.Stat(filepath.Join(globalRootDir, u.home)); err != nil || !st.IsDir() {
+			continue
+		}
+		seen[u.name] = true
+		users = append(users, u)
+	}
+
+	return users, scanner.Err()
+}
+
+// hookTarget is a single hook of a app for a single user (if the hook
+// is a user-level one)
+type hookTarget struct {
+	app  string
+	src  string
+	dst  string
+	user hookUser
+	hook clickHook
+}
+
+// dstForVersion returns where the hook of the given version of the snap
+// is installed
+func (t *hookTarget) dstForVersion(name, version string) string {
+	return filepath.Join(globalRootDir, expandHookPattern(name, t.app, version, t.user.name, t.user.home, t.hook.pattern))
+}
+
+// execUser returns the user the hook exec runs as
+func (t *hookTarget) execUser() string {
+	if t.hook.userLevel {
+		return t.user.name
+	}
+
+	return t.hook.user
+}
+
+// errHookSkipped is returned by a iterHooksFunc if there was nothing to
+// do for the hook, its exec is not run then
+var errHookSkipped = errors.New("hook skipped")
+
+type iterHooksFunc func(target *hookTarget) error
 
 // iterHooks will run the callback "f" for the given manifest
 // so that the call back can arrange e.g. a new link
@@ -211,6 +320,10 @@ func iterHooks(manifest clickManifest, inhibitHooks bool, f iterHooksFunc) error
 		return err
 	}
 
+	// trigger hooks run once at the end, no matter how many apps
+	// (or users) use them
+	triggers := make(map[string]clickHook)
+
 	for app, hook := range manifest.Hooks {
 		for hookName, hookSourceFile := range hook {
 			// ignore hooks that only exist for compatibility
@@ -226,37 +339,100 @@ func iterHooks(manifest clickManifest, inhibitHooks bool, f iterHooksFunc) error
 				continue
 			}
 
-			dst := filepath.Join(globalRootDir, expandHookPattern(manifest.Name, app, manifest.Version, systemHook.pattern))
-
-			if _, err := os.Stat(dst); err == nil {
-				if err := os.Remove(dst); err != nil {
-					log.Printf("Warning: failed to remove %s: %s", dst, err)
+			users := []hookUser{{}}
+			if systemHook.userLevel {
+				if users, err = hookUsers(); err != nil {
+					return err
 				}
 			}
 
-			// run iter func here
-			if err := f(hookSourceFile, dst, systemHook); err != nil {
-				return err
-			}
+			for _, u := range users {
+				target := &hookTarget{
+					app:  app,
+					src:  hookSourceFile,
+					user: u,
+					hook: systemHook,
+				}
+				target.dst = target.dstForVersion(manifest.Name, manifest.Version)
 
-			if systemHook.exec != "" && !inhibitHooks {
-				if err := execHook(systemHook.exec); err != nil {
-					os.Remove(dst)
+				// run iter func here
+				err := f(target)
+				if err == errHookSkipped {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+
+				if systemHook.exec == "" || inhibitHooks {
+					continue
+				}
+				if systemHook.trigger {
+					triggers[systemHook.name] = systemHook
+					continue
+				}
+				if err := execHook(systemHook.exec, target.execUser()); err != nil {
+					os.Remove(target.dst)
 					return err
 				}
 			}
 		}
 	}
 
+	for _, hook := range triggers {
+		if err := execHook(hook.exec, hook.user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeHookLink removes the given hook symlink
+func removeHookLink(dst string) {
+	if _, err := os.Lstat(dst); err != nil {
+		return
+	}
+	if err := os.Remove(dst); err != nil {
+		log.Printf("Warning: failed to remove %s: %s", dst, err)
+	}
+}
+
+// removeOtherVersionsHookLinks removes the hook links of all other
+// installed versions of the snap in targetDir, for hooks that allow
+// only a single version
+func removeOtherVersionsHookLinks(targetDir, name string, target *hookTarget) error {
+	versionDirs, err := filepath.Glob(filepath.Join(filepath.Dir(targetDir), "*"))
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range versionDirs {
+		version := filepath.Base(dir)
+		if dir == targetDir || version == "current" {
+			continue
+		}
+		if dst := target.dstForVersion(name, version); dst != target.dst {
+			removeHookLink(dst)
+		}
+	}
+
 	return nil
 }
 
 func installClickHooks(targetDir string, manifest clickManifest, inhibitHooks bool) error {
-	return iterHooks(manifest, inhibitHooks, func(src, dst string, systemHook clickHook) error {
+	return iterHooks(manifest, inhibitHooks, func(target *hookTarget) error {
+		if target.hook.singleVersion {
+			if err := removeOtherVersionsHookLinks(targetDir, manifest.Name, target); err != nil {
+				return err
+			}
+		}
+
 		// setup the new link target here, iterHooks will take
 		// care of running the hook
-		realSrc := path.Join(targetDir, src)
-		if err := os.Symlink(realSrc, dst); err != nil {
+		removeHookLink(target.dst)
+		realSrc := path.Join(targetDir, target.src)
+		if err := os.Symlink(realSrc, target.dst); err != nil {
 			return err
 		}
 
@@ -264,10 +440,29 @@ func installClickHooks(targetDir string, manifest clickManifest, inhibitHooks bo
 	})
 }
 
+// hookLinkOwnedBy returns true if the given hook symlink points into
+// the given version of the snap
+func hookLinkOwnedBy(dst string, manifest clickManifest, src string) bool {
+	realSrc, err := os.Readlink(dst)
+	if err != nil {
+		return false
+	}
+
+	suffix := "/" + filepath.Join(manifest.Name, manifest.Version, src)
+	return strings.HasSuffix(filepath.Clean(realSrc), suffix)
+}
+
 func removeClickHooks(manifest clickManifest, inhibitHooks bool) (err error) {
-	return iterHooks(manifest, inhibitHooks, func(src, dst string, systemHook clickHook) error {
-		// nothing we need to do here, the iterHookss will remove
-		// the hook symlink and call the hook itself
+	return iterHooks(manifest, inhibitHooks, func(target *hookTarget) error {
+		// patterns without the version are shared by all versions,
+		// do not remove (or re-run) the hook of another version
+		if !hookLinkOwnedBy(target.dst, manifest, target.src) {
+			return errHookSkipped
+		}
+
+		// iterHooks will call the hook itself
+		removeHookLink(target.dst)
+
 		return nil
 	})
 }
@@ -291,30 +486,55 @@ func removeClick(clickDir string) (err error) {
 		return err
 	}
 
-	if err := removeClickHooks(manifest, false); err != nil {
-		return err
-	}
-
-	// maybe remove current symlink
+	// maybe remove current symlink, this removes the hooks too
 	currentSymlink := path.Join(path.Dir(clickDir), "current")
 	p, _ := filepath.EvalSymlinks(currentSymlink)
 	if clickDir == p {
+		// the snap goes away, a failing remove hook stops that
+		if err := runSnapHook(clickDir, hookRemove); err != nil {
+			return err
+		}
 		if err := unsetActiveClick(p, false); err != nil {
 			return err
 		}
+		if err := releasePorts(manifest.Name); err != nil {
+			log.Printf("WARNING: can not release the ports of %s: %s", manifest.Name, err)
+		}
+	} else if err := removeClickHooks(manifest, false); err != nil {
+		return err
+	}
+
+	if err := os.Remove(hashesSha512File(manifest.Name, manifest.Version)); err != nil && !os.IsNotExist(err) {
+		log.Printf("WARNING: can not remove the recorded sha512 of %s: %s", manifest.Name, err)
 	}
 
 	return os.RemoveAll(clickDir)
 }
 
-func writeHashesFile(d *clickdeb.ClickDeb, instDir string) error {
+// hashesSha512File returns the file that records the sha512 of the
+// hashes.yaml of the given snap, it lives outside of the snap dir so
+// that a modified hashes.yaml can be detected
+func hashesSha512File(name, version string) string {
+	return filepath.Join(snapHashesDir, fmt.Sprintf("%s_%s.sha512", name, version))
+}
+
+func writeHashesFile(d *clickdeb.ClickDeb, manifest clickManifest, instDir string) error {
 	hashesFile := filepath.Join(instDir, "meta", "hashes.yaml")
 	hashesData, err := d.ControlMember("hashes.yaml")
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(hashesFile, hashesData, 0644)
+	if err := ioutil.WriteFile(hashesFile, hashesData, 0644); err != nil {
+		return err
+	}
+
+	if err := helpers.EnsureDir(snapHashesDir, 0755); err != nil {
+		return err
+	}
+	sum := sha512.Sum512(hashesData)
+
+	return helpers.AtomicWriteFile(hashesSha512File(manifest.Name, manifest.Version), []byte(hex.EncodeToString(sum[:])), 0644)
 }
 
 // generate the name
@@ -375,7 +595,7 @@ export HOME="$SNAP_APP_USER_DATA_PATH"
 # export old pwd
 export SNAP_OLD_PWD="$(pwd)"
 cd {{.Path}}
-aa-exec -p {{.AaProfile}} -- {{.Target}} "$@"
+{{.Launcher}} internal-launch {{.AaProfile}} -- {{.Target}} "$@"
 `
 	actualBinPath := binPathForBinary(pkgPath, binary)
 
@@ -387,8 +607,9 @@ aa-exec -p {{.AaProfile}} -- {{.Target}} "$@"
 		Target    string
 		Path      string
 		AaProfile string
+		Launcher  string
 	}{
-		m.Name, m.Version, actualBinPath, pkgPath, aaProfile,
+		m.Name, m.Version, actualBinPath, pkgPath, aaProfile, launcherCmd,
 	}
 	t.Execute(&templateOut, wrapperData)
 
@@ -399,39 +620,58 @@ func generateSnapServicesFile(service Service, baseDir string, aaProfile string,
 
 	serviceTemplate := `[Unit]
 Description={{.Description}}
-After=apparmor.service click-system-hooks.service
+After=apparmor.service click-system-hooks.service{{if .AfterUnits}} {{.AfterUnits}}{{end}}
 Requires=apparmor.service click-system-hooks.service
-X-Snappy=yes
+{{if .BeforeUnits}}Before={{.BeforeUnits}}
+{{end}}X-Snappy=yes
 
 [Service]
-ExecStart={{.FullPathStart}}
-WorkingDirectory={{.AppPath}}
+ExecStart={{.ExecStart}}
+WorkingDirectory={{.FullPathWorkingDirectory}}
 Environment="SNAPP_APP_PATH={{.AppPath}}" "SNAPP_APP_DATA_PATH=/var/lib{{.AppPath}}" "SNAPP_APP_USER_DATA_PATH=%h{{.AppPath}}" "SNAP_APP_PATH={{.AppPath}}" "SNAP_APP_DATA_PATH=/var/lib{{.AppPath}}" "SNAP_APP_USER_DATA_PATH=%h{{.AppPath}}" "SNAP_APP={{.AppTriple}}"
-AppArmorProfile={{.AaProfile}}
-{{if .Stop}}ExecStop={{.FullPathStop}}{{end}}
-{{if .PostStop}}ExecStopPost={{.FullPathPostStop}}{{end}}
+{{if .ServiceEnvironment}}Environment={{.ServiceEnvironment}}
+{{end}}AppArmorProfile={{.AaProfile}}
+{{if .Stop}}ExecStop={{.ExecStop}}{{end}}
+{{if .PostStop}}ExecStopPost={{.ExecStopPost}}{{end}}
 {{if .StopTimeout}}TimeoutStopSec={{.StopTimeout}}{{end}}
-
+{{if .ServiceType}}Type={{.ServiceType}}
+{{end}}{{if .Restart}}Restart={{.Restart}}
+{{end}}
 [Install]
 WantedBy=multi-user.target
 `
 	var templateOut bytes.Buffer
 	t := template.Must(template.New("wrapper").Parse(serviceTemplate))
+	workingDir := baseDir
+	if service.WorkingDirectory != "" {
+		workingDir = filepath.Join(baseDir, service.WorkingDirectory)
+	}
 	wrapperData := struct {
 		packageYaml
 		Service
-		AppPath          string
-		AaProfile        string
-		FullPathStart    string
-		FullPathStop     string
-		FullPathPostStop string
-		AppTriple        string
+		AppPath                  string
+		AaProfile                string
+		ExecStart                string
+		ExecStop                 string
+		ExecStopPost             string
+		FullPathWorkingDirectory string
+		AppTriple                string
+		// Type is ambiguous between packageYaml and Service
+		ServiceType        string
+		AfterUnits         string
+		BeforeUnits        string
+		ServiceEnvironment string
 	}{
 		*m, service, baseDir, aaProfile,
-		filepath.Join(baseDir, service.Start),
-		filepath.Join(baseDir, service.Stop),
-		filepath.Join(baseDir, service.PostStop),
+		launcherCommand(aaProfile, filepath.Join(baseDir, service.Start)),
+		launcherCommand(aaProfile, filepath.Join(baseDir, service.Stop)),
+		launcherCommand(aaProfile, filepath.Join(baseDir, service.PostStop)),
+		systemdEscapeSpecifiers.Replace(workingDir),
 		fmt.Sprintf("%s_%s_%s", m.Name, service.Name, m.Version),
+		service.Type,
+		serviceUnitNames(m, service.After),
+		serviceUnitNames(m, service.Before),
+		serviceEnvironment(service.Environment),
 	}
 	if err := t.Execute(&templateOut, wrapperData); err != nil {
 		// this can never happen, except we forget a variable
@@ -445,24 +685,71 @@ func generateServiceFileName(m *packageYaml, service Service) string {
 	return filepath.Join(snapServicesDir, fmt.Sprintf("%s_%s_%s.service", m.Name, service.Name, m.Version))
 }
 
+// serviceUnitNames returns the space separated unit names of the given
+// services of the snap
+func serviceUnitNames(m *packageYaml, names []string) string {
+	units := make([]string, len(names))
+	for i, name := range names {
+		units[i] = filepath.Base(generateServiceFileName(m, Service{Name: name}))
+	}
+
+	return strings.Join(units, " ")
+}
+
+// systemdQuote quotes a value for use in a unit file, "%" starts a
+// specifier in systemd so it needs escaping too
+var systemdQuote = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%")
+
+// systemdEscapeSpecifiers escapes the specifiers in a unit file value
+// that is not quoted, like a path
+var systemdEscapeSpecifiers = strings.NewReplacer("%", "%%")
+
+// sortedEnvironmentNames returns the names of the variables of the
+// environment in a stable order
+func sortedEnvironmentNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// serviceEnvironment returns the environment of a service as value of a
+// Environment= line
+func serviceEnvironment(env map[string]string) string {
+	keys := sortedEnvironmentNames(env)
+	vars := make([]string, len(keys))
+	for i, k := range keys {
+		vars[i] = `"` + systemdQuote.Replace(k+"="+env[k]) + `"`
+	}
+
+	return strings.Join(vars, " ")
+}
+
+// runSystemctl runs systemctl with the given arguments and returns its
+// output
 var runSystemctl = runSystemctlImpl
 
-func runSystemctlImpl(cmd ...string) error {
-	// FIXME: find an elegant solution, only enable works with --root
+func runSystemctlImpl(cmd ...string) ([]byte, error) {
+	// FIXME: find an elegant solution, only enable and is-enabled
+	// work with --root
 	// +3 == "systemctl" + "daemon-reload", globalRootDir
 	args := make([]string, 0, len(cmd)+3)
 	args = append(args, "systemctl")
-	if len(cmd) > 0 && cmd[0] == "enable" {
+	if len(cmd) > 0 && (cmd[0] == "enable" || cmd[0] == "is-enabled") {
 		args = append(args, "--root", globalRootDir)
 	}
 	args = append(args, cmd...)
-	if err := exec.Command(args[0], args[1:]...).Run(); err != nil {
+	output, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
 		exitCode, _ := helpers.ExitCode(err)
-		return &ErrSystemCtl{cmd: args,
+		return nil, &ErrSystemCtl{cmd: args,
 			exitCode: exitCode}
 	}
 
-	return nil
+	return output, nil
 }
 
 // takes a directory and removes the global root, this is needed
@@ -476,14 +763,38 @@ func stripGlobalRootDir(dir string) string {
 	return dir[len(globalRootDir):]
 }
 
-func addPackageServices(baseDir string, inhibitHooks bool) error {
+// addPackageServices generates the units of the services of the snap in
+// baseDir, enables and starts them; the given disabled services are
+// neither enabled nor started
+func addPackageServices(baseDir string, inhibitHooks bool, disabled []string) error {
 	m, err := parsePackageYamlFile(filepath.Join(baseDir, "meta", "package.yaml"))
 	if err != nil {
 		return err
 	}
 
+	isDisabled := make(map[string]bool, len(disabled))
+	for _, name := range disabled {
+		isDisabled[name] = true
+	}
+
 	for _, service := range m.Services {
-		aaProfile := fmt.Sprintf("%s_%s_%s", m.Name, service.Name, m.Version)
+		// the service learns its ports from the environment
+		ports, err := servicePorts(m.Name, service.Name)
+		if err != nil {
+			return err
+		}
+		if len(ports) > 0 {
+			env := make(map[string]string, len(service.Environment)+len(ports))
+			for k, v := range service.Environment {
+				env[k] = v
+			}
+			for tag, port := range ports {
+				env[portEnvName(tag)] = port
+			}
+			service.Environment = env
+		}
+
+		aaProfile := snapAppID(m, service.Name)
 		// this will remove the global base dir when generating the
 		// service file, this ensures that /apps/foo/1.0/bin/start
 		// is in the service file when the SetRoot() option
@@ -502,17 +813,20 @@ func addPackageServices(baseDir string, inhibitHooks bool) error {
 		// *but* always run enable (which just sets a symlink)
 		serviceName := filepath.Base(generateServiceFileName(m, service))
 		if !inhibitHooks {
-			if err := runSystemctl("daemon-reload"); err != nil {
+			if _, err := runSystemctl("daemon-reload"); err != nil {
 				return err
 			}
 		}
+		if isDisabled[service.Name] {
+			continue
+		}
 
-		if err := runSystemctl("enable", serviceName); err != nil {
+		if _, err := runSystemctl("enable", serviceName); err != nil {
 			return err
 		}
 
 		if !inhibitHooks {
-			if err := runSystemctl("start", serviceName); err != nil {
+			if _, err := runSystemctl("start", serviceName); err != nil {
 				return err
 			}
 		}
@@ -527,21 +841,25 @@ func removePackageServices(baseDir string) error {
 		return err
 	}
 	for _, service := range m.Services {
-		serviceName := filepath.Base(generateServiceFileName(m, service))
-		if err := runSystemctl("stop", serviceName); err != nil {
-			return err
+		// a service that had to be killed is gone as well, its
+		// units are removed all the same
+		if err := stopService(m, service); err != nil {
+			if _, ok := err.(*ErrServiceStopTimeout); !ok {
+				return err
+			}
+			log.Printf("WARNING: %s", err)
 		}
-		if err := runSystemctl("disable", serviceName); err != nil {
+		serviceName := filepath.Base(generateServiceFileName(m, service))
+		if _, err := runSystemctl("disable", serviceName); err != nil {
 			return err
 		}
-		// FIXME: wait for the service to be really stopped
 
 		os.Remove(generateServiceFileName(m, service))
 	}
 
 	// only reload if we actually had services
 	if len(m.Services) > 0 {
-		if err := runSystemctl("daemon-reload"); err != nil {
+		if _, err := runSystemctl("daemon-reload"); err != nil {
 			return err
 		}
 	}
@@ -549,19 +867,12 @@ func removePackageServices(baseDir string) error {
 	return nil
 }
 
+// getBinaryAaProfile returns the name of the apparmor profile of the
+// binary, the profile is generated from its security fields or copied
+// from its security-policy on activation and always named after the
+// APP_ID
 func getBinaryAaProfile(m *packageYaml, binary Binary) string {
-	// check if there is a specific apparmor profile
-	if binary.SecurityPolicy != "" {
-		return binary.SecurityPolicy
-	}
-	// ... or apparmor.json
-	if binary.SecurityTemplate != "" {
-		return binary.SecurityTemplate
-	}
-
-	// FIXME: we need to generate a default aa profile here instead
-	// of relying on a default one shipped by the package
-	return fmt.Sprintf("%s_%s_%s", m.Name, filepath.Base(binary.Name), m.Version)
+	return snapAppID(m, filepath.Base(binary.Name))
 }
 
 func addPackageBinaries(baseDir string) error {
@@ -686,6 +997,28 @@ func installClick(snapFile string, flags InstallFlags, ag agreer) (err error) {
 		return err
 	}
 	m, err := parsePackageYamlData(yamlData)
+	if err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	if flags&IgnoreArchitecture == 0 {
+		if err := checkArchitectures(m.Name, m.Architectures); err != nil {
+			return err
+		}
+	}
+
+	if err := checkFrameworks(m.Framework); err != nil {
+		return err
+	}
+	if m.Type == SnapTypeFramework {
+		if err := checkFrameworkUpdate(m.Name, m.Version); err != nil {
+			return err
+		}
+	}
+
 	if m.ExplicitLicenseAgreement {
 		if ag == nil {
 			return ErrLicenseNotAccepted
@@ -700,6 +1033,16 @@ func installClick(snapFile string, flags InstallFlags, ag agreer) (err error) {
 		}
 	}
 
+	// refuse ports that other snaps use before anything changes
+	registry, err := readPortRegistry()
+	if err != nil {
+		return err
+	}
+	ports, err := registry.assignPorts(m)
+	if err != nil {
+		return err
+	}
+
 	dataDir := filepath.Join(snapDataDir, manifest.Name, manifest.Version)
 
 	targetDir := snapAppsDir
@@ -709,6 +1052,23 @@ func installClick(snapFile string, flags InstallFlags, ag agreer) (err error) {
 	}
 
 	instDir := filepath.Join(targetDir, manifest.Name, manifest.Version)
+	currentActiveDir, _ := filepath.EvalSymlinks(filepath.Join(instDir, "..", "current"))
+	inhibitHooks := (flags & InhibitHooks) != 0
+
+	// record what we do so that a interrupted install can be
+	// finished or reverted by Recover
+	journal := &installJournal{
+		Name:         manifest.Name,
+		Version:      manifest.Version,
+		NewDir:       instDir,
+		OldDir:       currentActiveDir,
+		InhibitHooks: inhibitHooks,
+	}
+	if err := journal.record(journalStepUnpack); err != nil {
+		return err
+	}
+	defer journal.done()
+
 	if err := helpers.EnsureDir(instDir, 0755); err != nil {
 		log.Printf("WARNING: Can not create %s", instDir)
 	}
@@ -741,13 +1101,10 @@ func installClick(snapFile string, flags InstallFlags, ag agreer) (err error) {
 	}
 
 	// write the hashes now
-	if err := writeHashesFile(d, instDir); err != nil {
+	if err := writeHashesFile(d, manifest, instDir); err != nil {
 		return err
 	}
 
-	inhibitHooks := (flags & InhibitHooks) != 0
-
-	currentActiveDir, _ := filepath.EvalSymlinks(filepath.Join(instDir, "..", "current"))
 	// deal with the data:
 	//
 	// if there was a previous version, stop it
@@ -761,7 +1118,24 @@ func installClick(snapFile string, flags InstallFlags, ag agreer) (err error) {
 			return err
 		}
 
+		// give the old version a chance to prepare, a failure
+		// aborts the update
+		if currentActiveDir != instDir && !inhibitHooks {
+			if err := runSnapHook(currentActiveDir, hookPreRefresh); err != nil {
+				return err
+			}
+		}
+
+		// the services that are disabled stay disabled in the new
+		// version
+		if journal.DisabledServices, err = disabledSnapServices(currentActiveDir); err != nil {
+			return err
+		}
+
 		// we need to stop making it active
+		if err := journal.record(journalStepDeactivate); err != nil {
+			return err
+		}
 		if err := unsetActiveClick(currentActiveDir, inhibitHooks); err != nil {
 			// if anything goes wrong try to activate the old
 			// one again and pass the error on
@@ -769,29 +1143,92 @@ func installClick(snapFile string, flags InstallFlags, ag agreer) (err error) {
 			return err
 		}
 
+		if journal.NewDataDirs, err = newDataDirs(manifest.Name, oldManifest.Version, manifest.Version); err != nil {
+			activateClick(currentActiveDir, inhibitHooks, journal.DisabledServices)
+			return err
+		}
+		if err := journal.record(journalStepCopyData); err != nil {
+			activateClick(currentActiveDir, inhibitHooks, journal.DisabledServices)
+			return err
+		}
 		if err := copySnapData(manifest.Name, oldManifest.Version, manifest.Version); err != nil {
 			// FIXME: remove newDir
 
 			// restore the previous version
-			setActiveClick(currentActiveDir, inhibitHooks)
+			activateClick(currentActiveDir, inhibitHooks, journal.DisabledServices)
 			return err
 		}
 	} else {
+		if journal.NewDataDirs, err = newDataDirs(manifest.Name, "", manifest.Version); err != nil {
+			return err
+		}
+		if err := journal.record(journalStepCopyData); err != nil {
+			return err
+		}
 		if err := helpers.EnsureDir(dataDir, 0755); err != nil {
 			log.Printf("WARNING: Can not create %s", dataDir)
 			return err
 		}
 	}
 
+	// the services of the new version get their ports with the
+	// activation
+	restorePorts, err := registry.claim(m.Name, ports)
+	if err != nil {
+		if currentActiveDir != "" {
+			activateClick(currentActiveDir, inhibitHooks, journal.DisabledServices)
+		}
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if err := restorePorts(); err != nil {
+			log.Printf("WARNING: can not restore the ports of %s: %s", manifest.Name, err)
+		}
+	}()
+
 	// and finally make active
-	if err := setActiveClick(instDir, inhibitHooks); err != nil {
+	if err := journal.record(journalStepActivate); err != nil {
+		if currentActiveDir != "" {
+			activateClick(currentActiveDir, inhibitHooks, journal.DisabledServices)
+		}
+		return err
+	}
+	if err := activateClick(instDir, inhibitHooks, journal.DisabledServices); err != nil {
 		// ensure to revert on install failure
 		if currentActiveDir != "" {
-			setActiveClick(currentActiveDir, inhibitHooks)
+			activateClick(currentActiveDir, inhibitHooks, journal.DisabledServices)
 		}
 		return err
 	}
 
+	// and let the new version know, a failure reverts the install
+	if currentActiveDir != instDir && !inhibitHooks {
+		hook := hookPostRefresh
+		if currentActiveDir == "" {
+			hook = hookInstall
+		}
+		err := runSnapHook(instDir, hook)
+		if err == nil {
+			err = configSnapPorts(instDir, manifest.Name, ports)
+		}
+		if err != nil {
+			unsetActiveClick(instDir, inhibitHooks)
+			if err := journal.rollBack(); err != nil {
+				log.Printf("WARNING: can not revert the install of %s: %s", manifest.Name, err)
+			}
+			return err
+		}
+	}
+
+	// drop the versions that are no longer needed if that is
+	// configured, the install worked even if that fails
+	if err := gcOnInstall(manifest.Name); err != nil {
+		log.Printf("WARNING: can not remove old versions of %s: %s", manifest.Name, err)
+	}
+
 	return nil
 }
 
@@ -822,17 +1259,14 @@ func copySnapData(snapName, oldVersion, newVersion string) (err error) {
 func copySnapDataDirectory(oldPath, newPath string) (err error) {
 	if _, err := os.Stat(oldPath); err == nil {
 		if _, err := os.Stat(newPath); err != nil {
-			// there is no golang "CopyFile" and we want hardlinks
-			// by default to save space
-			cmd := exec.Command("cp", "-al", oldPath, newPath)
-			if err := cmd.Run(); err != nil {
-				if exitCode, err := helpers.ExitCode(err); err != nil {
-					return &ErrDataCopyFailed{
-						oldPath:  oldPath,
-						newPath:  newPath,
-						exitCode: exitCode}
+			// a real copy, the old version must keep its data
+			// as it was so that a rollback gets it back
+			if err := helpers.CopyTree(oldPath, newPath); err != nil {
+				return &ErrDataCopyFailed{
+					oldPath: oldPath,
+					newPath: newPath,
+					err:     err,
 				}
-				return err
 			}
 		}
 	}
@@ -860,6 +1294,14 @@ func unsetActiveClick(clickDir string, inhibitHooks bool) error {
 		return err
 	}
 
+	if err := removePackageAppArmorProfiles(clickDir, inhibitHooks); err != nil {
+		return err
+	}
+
+	if err := removePackageSeccompFilters(clickDir); err != nil {
+		return err
+	}
+
 	manifest, err := readClickManifestFromClickDir(clickDir)
 	if err != nil {
 		return err
@@ -885,11 +1327,25 @@ func setActiveClick(baseDir string, inhibitHooks bool) error {
 		return nil
 	}
 
-	// there is already an active part
+	// there is already an active part, the services that were
+	// disabled in it stay disabled
+	var disabledServices []string
 	if currentActiveDir != "" {
+		var err error
+		if disabledServices, err = disabledSnapServices(currentActiveDir); err != nil {
+			return err
+		}
 		unsetActiveClick(currentActiveDir, inhibitHooks)
 	}
 
+	return activateClick(baseDir, inhibitHooks, disabledServices)
+}
+
+// activateClick makes the snap in baseDir active while no version of it
+// is, the given services are neither enabled nor started
+func activateClick(baseDir string, inhibitHooks bool, disabledServices []string) error {
+	currentActiveSymlink := filepath.Join(baseDir, "..", "current")
+
 	// make new part active
 	newActiveManifest, err := readClickManifestFromClickDir(baseDir)
 	if err != nil {
@@ -902,12 +1358,20 @@ func setActiveClick(baseDir string, inhibitHooks bool) error {
 		return err
 	}
 
+	// generate the apparmor profiles and seccomp filters before
+	// anything can use them
+	if err := addPackageAppArmorProfiles(baseDir, inhibitHooks); err != nil {
+		return err
+	}
+	if err := addPackageSeccompFilters(baseDir); err != nil {
+		return err
+	}
 	// add the "binaries:" from the package.yaml
 	if err := addPackageBinaries(baseDir); err != nil {
 		return err
 	}
 	// add the "services:" from the package.yaml
-	if err := addPackageServices(baseDir, inhibitHooks); err != nil {
+	if err := addPackageServices(baseDir, inhibitHooks, disabledServices); err != nil {
 		return err
 	}
 
@@ -119,25 +119,24 @@ Pattern: /var/lib/systemd/click/${id}
 `
 	makeClickHook(c, content)
 
-	os.MkdirAll(path.Join(s.tempdir, "/var/lib/apparmor/click/"), 0755)
-	testSymlinkDir2 := path.Join(s.tempdir, "/var/lib/apparmor/click/")
+	testSymlinkDir2 := path.Join(s.tempdir, "/var/lib/content-hub/")
 	os.MkdirAll(testSymlinkDir2, 0755)
-	content = `Hook-Name: apparmor
-Pattern: /var/lib/apparmor/click/${id}
+	content = `Hook-Name: content-hub
+Pattern: /var/lib/content-hub/${id}
 `
 	makeClickHook(c, content)
 
 	instDir := path.Join(s.tempdir, "apps", "foo", "1.0")
 	os.MkdirAll(instDir, 0755)
 	ioutil.WriteFile(path.Join(instDir, "path-to-systemd-file"), []byte(""), 0644)
-	ioutil.WriteFile(path.Join(instDir, "path-to-apparmor-file"), []byte(""), 0644)
+	ioutil.WriteFile(path.Join(instDir, "path-to-content-hub-file"), []byte(""), 0644)
 	manifest := clickManifest{
 		Name:    "foo",
 		Version: "1.0",
 		Hooks: map[string]clickAppHook{
 			"app": clickAppHook{
-				"systemd":  "path-to-systemd-file",
-				"apparmor": "path-to-apparmor-file",
+				"systemd":     "path-to-systemd-file",
+				"content-hub": "path-to-content-hub-file",
 			},
 		},
 	}
@@ -155,7 +154,7 @@ Pattern: /var/lib/apparmor/click/${id}
 	c.Assert(err, IsNil)
 	symlinkTarget, err = filepath.EvalSymlinks(p)
 	c.Assert(err, IsNil)
-	c.Assert(symlinkTarget, Equals, path.Join(instDir, "path-to-apparmor-file"))
+	c.Assert(symlinkTarget, Equals, path.Join(instDir, "path-to-content-hub-file"))
 
 	// now ensure we can remove
 	err = removeClickHooks(manifest, false)
@@ -164,6 +163,210 @@ Pattern: /var/lib/apparmor/click/${id}
 	c.Assert(err, NotNil)
 }
 
+func (s *SnapTestSuite) TestHandleClickHooksIgnoresAppArmor(c *C) {
+	testSymlinkDir := path.Join(s.tempdir, "/var/lib/apparmor/clicks/")
+	os.MkdirAll(testSymlinkDir, 0755)
+	makeClickHook(c, `Hook-Name: apparmor
+Pattern: /var/lib/apparmor/clicks/${id}.json
+`)
+
+	instDir := path.Join(s.tempdir, "apps", "foo", "1.0")
+	os.MkdirAll(instDir, 0755)
+	manifest := clickManifest{
+		Name:    "foo",
+		Version: "1.0",
+		Hooks: map[string]clickAppHook{
+			"app": clickAppHook{"apparmor": "meta/app.apparmor"},
+		},
+	}
+	c.Assert(installClickHooks(instDir, manifest, false), IsNil)
+
+	// snappy writes the profile of the app itself
+	c.Assert(helpers.FileExists(path.Join(testSymlinkDir, "foo_app_1.0.json")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestReadClickHookFileFlags(c *C) {
+	makeClickHook(c, `Hook-Name: foo
+Pattern: ${home}/foo/${id}
+User-Level: yes
+Trigger: yes
+Single-Version: no`)
+	hook, err := readClickHookFile(path.Join(clickSystemHooksDir, "foo.hook"))
+	c.Assert(err, IsNil)
+	c.Assert(hook.userLevel, Equals, true)
+	c.Assert(hook.trigger, Equals, true)
+	c.Assert(hook.singleVersion, Equals, false)
+}
+
+func (s *SnapTestSuite) TestExpandHookPattern(c *C) {
+	c.Assert(expandHookPattern("foo", "app", "1.0", "", "", "/a/${id}"), Equals, "/a/foo_app_1.0")
+	c.Assert(expandHookPattern("foo", "app", "1.0", "", "", "/a/${short-id}.conf"), Equals, "/a/foo_app.conf")
+	c.Assert(expandHookPattern("foo", "app", "1.0", "bob", "/home/bob", "${home}/${user}/${id}"), Equals, "/home/bob/bob/foo_app_1.0")
+	c.Assert(expandHookPattern("foo", "app", "1.0", "", "", "/a/$${id}-$$$$"), Equals, "/a/${id}-$$")
+}
+
+func makeTestHookSource(c *C, instDir string) {
+	c.Assert(os.MkdirAll(instDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(path.Join(instDir, "hook-src"), nil, 0644), IsNil)
+}
+
+// writeTestPasswd writes the passwd file that the user-level hooks
+// are installed from
+func writeTestPasswd(c *C, content string) {
+	c.Assert(os.MkdirAll(filepath.Dir(passwdFile), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(passwdFile, []byte(content), 0644), IsNil)
+}
+
+func (s *SnapTestSuite) TestHookUsers(c *C) {
+	writeTestPasswd(c, `root:x:0:0:root:/root:/bin/bash
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+alice:x:1000:1000:Alice:/home/alice:/bin/bash
+broken line
+bob:x:not-a-uid:1001::/home/bob:/bin/sh
+carol:x:1002:1002::/srv/carol:/bin/sh
+dave:x:1003:1003::/home/dave:/bin/sh
+nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin
+`)
+	for _, home := range []string{"root", "home/alice", "home/bob", "srv/carol", "nonexistent"} {
+		c.Assert(os.MkdirAll(filepath.Join(s.tempdir, home), 0755), IsNil)
+	}
+
+	// dave has no home directory
+	users, err := hookUsers()
+	c.Assert(err, IsNil)
+	c.Assert(users, DeepEquals, []hookUser{
+		{name: "alice", home: "/home/alice"},
+		{name: "carol", home: "/srv/carol"},
+	})
+}
+
+func (s *SnapTestSuite) TestHookUsersNoPasswd(c *C) {
+	users, err := hookUsers()
+	c.Assert(err, IsNil)
+	c.Assert(users, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestHandleClickHooksUserLevel(c *C) {
+	writeTestPasswd(c, "alice:x:1000:1000::/home/alice:/bin/sh\nbob:x:1001:1001::/home/bob:/bin/sh\n")
+	for _, u := range []string{"alice", "bob"} {
+		c.Assert(os.MkdirAll(filepath.Join(s.tempdir, "home", u, "hooks"), 0755), IsNil)
+	}
+	makeClickHook(c, `Hook-Name: user-hook
+User-Level: yes
+Pattern: ${home}/hooks/${short-id}-${user}`)
+
+	instDir := path.Join(s.tempdir, "apps", "foo", "1.0")
+	makeTestHookSource(c, instDir)
+	manifest := clickManifest{
+		Name:    "foo",
+		Version: "1.0",
+		Hooks:   map[string]clickAppHook{"app": {"user-hook": "hook-src"}},
+	}
+	c.Assert(installClickHooks(instDir, manifest, false), IsNil)
+
+	for _, u := range []string{"alice", "bob"} {
+		p := filepath.Join(s.tempdir, "home", u, "hooks", "foo_app-"+u)
+		target, err := os.Readlink(p)
+		c.Assert(err, IsNil)
+		c.Assert(target, Equals, path.Join(instDir, "hook-src"))
+	}
+
+	c.Assert(removeClickHooks(manifest, false), IsNil)
+	c.Assert(helpers.FileExists(filepath.Join(s.tempdir, "home", "alice", "hooks", "foo_app-alice")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestHandleClickHooksTrigger(c *C) {
+	c.Assert(os.MkdirAll(filepath.Join(s.tempdir, "hooks"), 0755), IsNil)
+	triggerLog := filepath.Join(s.tempdir, "trigger.log")
+	perAppLog := filepath.Join(s.tempdir, "per-app.log")
+
+	makeClickHook(c, fmt.Sprintf(`Hook-Name: trigger
+Trigger: yes
+Exec: echo run >> %s
+Pattern: /hooks/trigger-${id}`, triggerLog))
+	makeClickHook(c, fmt.Sprintf(`Hook-Name: per-app
+Exec: echo run >> %s
+Pattern: /hooks/per-app-${id}`, perAppLog))
+
+	instDir := path.Join(s.tempdir, "apps", "foo", "1.0")
+	makeTestHookSource(c, instDir)
+	manifest := clickManifest{
+		Name:    "foo",
+		Version: "1.0",
+		Hooks: map[string]clickAppHook{
+			"app1": {"trigger": "hook-src", "per-app": "hook-src"},
+			"app2": {"trigger": "hook-src", "per-app": "hook-src"},
+		},
+	}
+	c.Assert(installClickHooks(instDir, manifest, false), IsNil)
+
+	content, err := ioutil.ReadFile(triggerLog)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "run\n")
+	content, err = ioutil.ReadFile(perAppLog)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "run\nrun\n")
+}
+
+func (s *SnapTestSuite) TestHandleClickHooksSingleVersion(c *C) {
+	hooksDir := filepath.Join(s.tempdir, "hooks")
+	c.Assert(os.MkdirAll(hooksDir, 0755), IsNil)
+	makeClickHook(c, `Hook-Name: single
+Single-Version: yes
+Pattern: /hooks/${id}`)
+
+	dir1 := path.Join(s.tempdir, "apps", "foo", "1.0")
+	dir2 := path.Join(s.tempdir, "apps", "foo", "2.0")
+	makeTestHookSource(c, dir1)
+	makeTestHookSource(c, dir2)
+	manifest1 := clickManifest{
+		Name:    "foo",
+		Version: "1.0",
+		Hooks:   map[string]clickAppHook{"app": {"single": "hook-src"}},
+	}
+	manifest2 := manifest1
+	manifest2.Version = "2.0"
+
+	c.Assert(installClickHooks(dir1, manifest1, false), IsNil)
+	c.Assert(helpers.FileExists(filepath.Join(hooksDir, "foo_app_1.0")), Equals, true)
+
+	// only one version has the hook
+	c.Assert(installClickHooks(dir2, manifest2, false), IsNil)
+	c.Assert(helpers.FileExists(filepath.Join(hooksDir, "foo_app_1.0")), Equals, false)
+	c.Assert(helpers.FileExists(filepath.Join(hooksDir, "foo_app_2.0")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestRemoveClickHooksKeepsOtherVersions(c *C) {
+	hooksDir := filepath.Join(s.tempdir, "hooks")
+	c.Assert(os.MkdirAll(hooksDir, 0755), IsNil)
+	execLog := filepath.Join(s.tempdir, "exec.log")
+	makeClickHook(c, fmt.Sprintf(`Hook-Name: shared
+Exec: echo run >> %s
+Pattern: /hooks/${short-id}`, execLog))
+
+	dir2 := path.Join(s.tempdir, "apps", "foo", "2.0")
+	makeTestHookSource(c, dir2)
+	manifest1 := clickManifest{
+		Name:    "foo",
+		Version: "1.0",
+		Hooks:   map[string]clickAppHook{"app": {"shared": "hook-src"}},
+	}
+	manifest2 := manifest1
+	manifest2.Version = "2.0"
+	c.Assert(installClickHooks(dir2, manifest2, false), IsNil)
+
+	// removing the hooks of the old version does not touch the
+	// hook of the new one
+	c.Assert(removeClickHooks(manifest1, false), IsNil)
+	target, err := os.Readlink(filepath.Join(hooksDir, "foo_app"))
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, path.Join(dir2, "hook-src"))
+
+	content, err := ioutil.ReadFile(execLog)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "run\n")
+}
+
 func (s *SnapTestSuite) TestLocalSnapInstall(c *C) {
 	snapFile := makeTestSnapPackage(c, "")
 	err := installClick(snapFile, 0, nil)
@@ -188,8 +391,8 @@ func (s *SnapTestSuite) TestLocalSnapInstall(c *C) {
 	c.Assert(snap.Hash(), Not(Equals), "")
 }
 
-func (s *SnapTestSuite) TestLocalSnapInstallDebsigVerifyFails(c *C) {
-	runDebsigVerify = func(snapFile string, allowUnauth bool) (err error) {
+func (s *SnapTestSuite) TestLocalSnapInstallSignatureFails(c *C) {
+	verifySnapSignature = func(snapFile string, allowUnauth bool) (err error) {
 		return errors.New("something went wrong")
 	}
 
@@ -202,10 +405,10 @@ func (s *SnapTestSuite) TestLocalSnapInstallDebsigVerifyFails(c *C) {
 	c.Assert(err, NotNil)
 }
 
-// ensure that the right parameters are passed to runDebsigVerify()
-func (s *SnapTestSuite) TestLocalSnapInstallDebsigVerifyPassesUnauth(c *C) {
+// ensure that the right parameters are passed to verifySnapSignature()
+func (s *SnapTestSuite) TestLocalSnapInstallSignaturePassesUnauth(c *C) {
 	var expectedUnauth bool
-	runDebsigVerify = func(snapFile string, allowUnauth bool) (err error) {
+	verifySnapSignature = func(snapFile string, allowUnauth bool) (err error) {
 		c.Assert(allowUnauth, Equals, expectedUnauth)
 		return nil
 	}
@@ -232,10 +435,12 @@ func (a *agreerator) Agreed(intro, license string) bool {
 	return a.y
 }
 
+const licenseYaml = "name: foo\nversion: 1.0\nvendor: Foo Bar <foo@example.com>\nexplicit-license-agreement: Y"
+
 // if the snap asks for accepting a license, and an agreer isn't provided,
 // install fails
 func (s *SnapTestSuite) TestLocalSnapInstallMissingAccepterFails(c *C) {
-	pkg := makeTestSnapPackage(c, "explicit-license-agreement: Y")
+	pkg := makeTestSnapPackage(c, licenseYaml)
 	err := installClick(pkg, 0, nil)
 	c.Check(err, Equals, ErrLicenseNotAccepted)
 }
@@ -243,7 +448,7 @@ func (s *SnapTestSuite) TestLocalSnapInstallMissingAccepterFails(c *C) {
 // if the snap asks for accepting a license, and an agreer is provided, and
 // Agreed returns false, install fails
 func (s *SnapTestSuite) TestLocalSnapInstallNegAccepterFails(c *C) {
-	pkg := makeTestSnapPackage(c, "explicit-license-agreement: Y")
+	pkg := makeTestSnapPackage(c, licenseYaml)
 	err := installClick(pkg, 0, &agreerator{y: false})
 	c.Check(err, Equals, ErrLicenseNotAccepted)
 }
@@ -254,7 +459,7 @@ func (s *SnapTestSuite) TestLocalSnapInstallNoLicenseFails(c *C) {
 	licenseChecker = func(string) error { return nil }
 	defer func() { licenseChecker = checkLicenseExists }()
 
-	pkg := makeTestSnapPackageFull(c, "explicit-license-agreement: Y", false)
+	pkg := makeTestSnapPackageFull(c, licenseYaml, false)
 	err := installClick(pkg, 0, &agreerator{y: true})
 	c.Check(err, Equals, ErrLicenseNotProvided)
 }
@@ -262,14 +467,14 @@ func (s *SnapTestSuite) TestLocalSnapInstallNoLicenseFails(c *C) {
 // if the snap asks for accepting a license, and an agreer is provided, and
 // Agreed returns true, install succeeds
 func (s *SnapTestSuite) TestLocalSnapInstallPosAccepterWorks(c *C) {
-	pkg := makeTestSnapPackage(c, "explicit-license-agreement: Y")
+	pkg := makeTestSnapPackage(c, licenseYaml)
 	err := installClick(pkg, 0, &agreerator{y: true})
 	c.Check(err, Equals, nil)
 }
 
 // Agreed is given reasonable values for intro and license
 func (s *SnapTestSuite) TestLocalSnapInstallAccepterReasonable(c *C) {
-	pkg := makeTestSnapPackage(c, "name: foobar\nexplicit-license-agreement: Y")
+	pkg := makeTestSnapPackage(c, "name: foobar\nversion: 1.0\nvendor: Foo Bar <foo@example.com>\nexplicit-license-agreement: Y")
 	ag := &agreerator{y: true}
 	err := installClick(pkg, 0, ag)
 	c.Assert(err, Equals, nil)
@@ -279,9 +484,9 @@ func (s *SnapTestSuite) TestLocalSnapInstallAccepterReasonable(c *C) {
 
 func (s *SnapTestSuite) TestSnapRemove(c *C) {
 	allSystemctl := []string{}
-	runSystemctl = func(cmd ...string) error {
+	runSystemctl = func(cmd ...string) ([]byte, error) {
 		allSystemctl = append(allSystemctl, cmd[0])
-		return nil
+		return nil, nil
 	}
 
 	targetDir := path.Join(s.tempdir, "apps")
@@ -521,7 +726,7 @@ export HOME="$SNAP_APP_USER_DATA_PATH"
 # export old pwd
 export SNAP_OLD_PWD="$(pwd)"
 cd /apps/pastebinit.mvo/1.4.0.0.1/
-aa-exec -p pastebinit.mvo_pastebinit_1.4.0.0.1 -- /apps/pastebinit.mvo/1.4.0.0.1/bin/pastebinit "$@"
+/usr/bin/snappy internal-launch pastebinit.mvo_pastebinit_1.4.0.0.1 -- /apps/pastebinit.mvo/1.4.0.0.1/bin/pastebinit "$@"
 `
 
 func (s *SnapTestSuite) TestSnappyGenerateSnapBinaryWrapper(c *C) {
@@ -555,8 +760,8 @@ func (s *SnapTestSuite) TestSnappyGetBinaryAaProfile(c *C) {
 		Version: "1.0"}
 
 	c.Assert(getBinaryAaProfile(&m, Binary{Name: "bin/app"}), Equals, "foo_app_1.0")
-	c.Assert(getBinaryAaProfile(&m, Binary{Name: "bin/app", SecurityTemplate: "some-security-json"}), Equals, "some-security-json")
-	c.Assert(getBinaryAaProfile(&m, Binary{Name: "bin/app", SecurityPolicy: "some-profile"}), Equals, "some-profile")
+	c.Assert(getBinaryAaProfile(&m, Binary{Name: "bin/app", SecurityDefinitions: SecurityDefinitions{SecurityTemplate: "some-template"}}), Equals, "foo_app_1.0")
+	c.Assert(getBinaryAaProfile(&m, Binary{Name: "bin/app", SecurityDefinitions: SecurityDefinitions{SecurityPolicy: &SecurityPolicyDefinition{Apparmor: "meta/app.profile"}}}), Equals, "foo_app_1.0")
 }
 
 func (s *SnapTestSuite) TestSnappyHandleBinariesOnInstall(c *C) {
@@ -633,9 +838,9 @@ services:
 
 func (s *SnapTestSuite) TestSnappyHandleServicesOnInstallInhibit(c *C) {
 	allSystemctl := []string{}
-	runSystemctl = func(cmd ...string) error {
+	runSystemctl = func(cmd ...string) ([]byte, error) {
 		allSystemctl = append(allSystemctl, cmd[0])
-		return nil
+		return nil, nil
 	}
 
 	packageYaml := `name: foo.mvo
@@ -659,7 +864,7 @@ Requires=apparmor.service click-system-hooks.service
 X-Snappy=yes
 
 [Service]
-ExecStart=/apps/docker/1.3.3.001/bin/docker.wrap
+ExecStart=/usr/bin/snappy internal-launch docker_docker_1.3.3.001 -- /apps/docker/1.3.3.001/bin/docker.wrap
 WorkingDirectory=/apps/docker/1.3.3.001/
 Environment="SNAPP_APP_PATH=/apps/docker/1.3.3.001/" "SNAPP_APP_DATA_PATH=/var/lib/apps/docker/1.3.3.001/" "SNAPP_APP_USER_DATA_PATH=%h/apps/docker/1.3.3.001/" "SNAP_APP_PATH=/apps/docker/1.3.3.001/" "SNAP_APP_DATA_PATH=/var/lib/apps/docker/1.3.3.001/" "SNAP_APP_USER_DATA_PATH=%h/apps/docker/1.3.3.001/" "SNAP_APP=docker_docker_1.3.3.001"
 AppArmorProfile=docker_docker_1.3.3.001
@@ -767,12 +972,12 @@ func (s *SnapTestSuite) TestAddPackageServicesStripsGlobalRootdir(c *C) {
 	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
 	c.Assert(err, IsNil)
 	baseDir := filepath.Dir(filepath.Dir(yamlFile))
-	err = addPackageServices(baseDir, false)
+	err = addPackageServices(baseDir, false, nil)
 	c.Assert(err, IsNil)
 
 	content, err := ioutil.ReadFile(filepath.Join(s.tempdir, "/etc/systemd/system/hello-app_svc1_1.10.service"))
 	c.Assert(err, IsNil)
-	c.Assert(strings.Contains(string(content), "\nExecStart=/apps/hello-app/1.10/bin/hello\n"), Equals, true)
+	c.Assert(strings.Contains(string(content), "\nExecStart=/usr/bin/snappy internal-launch hello-app_svc1_1.10 -- /apps/hello-app/1.10/bin/hello\n"), Equals, true)
 }
 
 func (s *SnapTestSuite) TestAddPackageBinariesStripsGlobalRootdir(c *C) {
@@ -792,7 +997,7 @@ func (s *SnapTestSuite) TestAddPackageBinariesStripsGlobalRootdir(c *C) {
 
 	needle := `
 cd /apps/hello-app/1.10
-aa-exec -p hello-app_hello_1.10 -- /apps/hello-app/1.10/bin/hello "$@"
+/usr/bin/snappy internal-launch hello-app_hello_1.10 -- /apps/hello-app/1.10/bin/hello "$@"
 `
 	c.Assert(strings.Contains(string(content), needle), Equals, true)
 }
@@ -804,12 +1009,12 @@ Requires=apparmor.service click-system-hooks.service
 X-Snappy=yes
 
 [Service]
-ExecStart=/apps/xkcd-webserver.canonical/0.3.4/bin/foo start
+ExecStart=/usr/bin/snappy internal-launch xkcd-webserver.canonical_xkcd-webserver_0.3.4 -- /apps/xkcd-webserver.canonical/0.3.4/bin/foo start
 WorkingDirectory=/apps/xkcd-webserver.canonical/0.3.4/
 Environment="SNAPP_APP_PATH=/apps/xkcd-webserver.canonical/0.3.4/" "SNAPP_APP_DATA_PATH=/var/lib/apps/xkcd-webserver.canonical/0.3.4/" "SNAPP_APP_USER_DATA_PATH=%h/apps/xkcd-webserver.canonical/0.3.4/" "SNAP_APP_PATH=/apps/xkcd-webserver.canonical/0.3.4/" "SNAP_APP_DATA_PATH=/var/lib/apps/xkcd-webserver.canonical/0.3.4/" "SNAP_APP_USER_DATA_PATH=%h/apps/xkcd-webserver.canonical/0.3.4/" "SNAP_APP=xckd-webserver.canonical_xkcd-webserver_0.3.4"
 AppArmorProfile=xkcd-webserver.canonical_xkcd-webserver_0.3.4
-ExecStop=/apps/xkcd-webserver.canonical/0.3.4/bin/foo stop
-ExecStopPost=/apps/xkcd-webserver.canonical/0.3.4/bin/foo post-stop
+ExecStop=/usr/bin/snappy internal-launch xkcd-webserver.canonical_xkcd-webserver_0.3.4 -- /apps/xkcd-webserver.canonical/0.3.4/bin/foo stop
+ExecStopPost=/usr/bin/snappy internal-launch xkcd-webserver.canonical_xkcd-webserver_0.3.4 -- /apps/xkcd-webserver.canonical/0.3.4/bin/foo post-stop
 TimeoutStopSec=30
 
 [Install]
@@ -832,3 +1037,63 @@ func (s *SnapTestSuite) TestSnappyGenerateSnapServiceWrapper(c *C) {
 	generatedWrapper := generateSnapServicesFile(service, pkgPath, aaProfile, &m)
 	c.Assert(generatedWrapper, Equals, expectedServiceWrapper)
 }
+
+var expectedRichServiceWrapper = `[Unit]
+Description=A fun webserver
+After=apparmor.service click-system-hooks.service xkcd_db_1.0.service
+Requires=apparmor.service click-system-hooks.service
+Before=xkcd_cache_1.0.service xkcd_proxy_1.0.service
+X-Snappy=yes
+
+[Service]
+ExecStart=/usr/bin/snappy internal-launch xkcd_xkcd-webserver_1.0 -- /apps/xkcd/1.0/bin/foo start
+WorkingDirectory=/apps/xkcd/1.0/www
+Environment="SNAPP_APP_PATH=/apps/xkcd/1.0/" "SNAPP_APP_DATA_PATH=/var/lib/apps/xkcd/1.0/" "SNAPP_APP_USER_DATA_PATH=%h/apps/xkcd/1.0/" "SNAP_APP_PATH=/apps/xkcd/1.0/" "SNAP_APP_DATA_PATH=/var/lib/apps/xkcd/1.0/" "SNAP_APP_USER_DATA_PATH=%h/apps/xkcd/1.0/" "SNAP_APP=xkcd_xkcd-webserver_1.0"
+Environment="GREETING=say \"hi\" 100%%" "PORT=80"
+AppArmorProfile=xkcd_xkcd-webserver_1.0
+
+
+
+Type=notify
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`
+
+func (s *SnapTestSuite) TestSnappyGenerateSnapServiceRich(c *C) {
+	service := Service{Name: "xkcd-webserver",
+		Start:       "bin/foo start",
+		Description: "A fun webserver",
+		Restart:     "on-failure",
+		Type:        "notify",
+		After:       []string{"db"},
+		Before:      []string{"cache", "proxy"},
+		Environment: map[string]string{
+			"PORT":     "80",
+			"GREETING": `say "hi" 100%`,
+		},
+		WorkingDirectory: "www",
+	}
+	pkgPath := "/apps/xkcd/1.0/"
+	aaProfile := "xkcd_xkcd-webserver_1.0"
+	m := packageYaml{Name: "xkcd",
+		Version: "1.0",
+		Type:    SnapTypeApp}
+
+	generatedWrapper := generateSnapServicesFile(service, pkgPath, aaProfile, &m)
+	c.Assert(generatedWrapper, Equals, expectedRichServiceWrapper)
+}
+
+func (s *SnapTestSuite) TestSnappyGenerateSnapServiceWorkingDirectorySpecifiers(c *C) {
+	service := Service{Name: "xkcd-webserver",
+		Start:            "bin/foo start",
+		WorkingDirectory: "www-%h",
+	}
+	m := packageYaml{Name: "xkcd",
+		Version: "1.0",
+		Type:    SnapTypeApp}
+
+	generatedWrapper := generateSnapServicesFile(service, "/apps/xkcd/1.0/", "xkcd_xkcd-webserver_1.0", &m)
+	c.Assert(generatedWrapper, Matches, "(?s).*\nWorkingDirectory=/apps/xkcd/1.0/www-%%h\n.*")
+}
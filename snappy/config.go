@@ -26,8 +26,8 @@ import (
 	"strings"
 )
 
-// can be overriden by tests
-var aaExec = "aa-exec"
+// the launcher the hooks are run with, can be overriden by tests
+var hookLauncher = launcherCmd
 
 // snapConfig configures a installed snap in the given directory
 //
@@ -46,15 +46,16 @@ func snapConfig(snapDir, rawConfig string) (newConfig string, err error) {
 		return "", ErrPackageNotFound
 	}
 
-	appArmorProfile := fmt.Sprintf("%s_%s_%s", part.Name(), "snappy-config", part.Version())
+	appID := snapAppID(part.m, snapHookAppArmorName("config"))
 
-	return runConfigScript(configScript, appArmorProfile, rawConfig, makeSnapHookEnv(part))
+	return runConfigScript(configScript, appID, rawConfig, makeSnapHookEnv(part))
 }
 
-// runConfigScript is a helper that just runs the config script and passes
-// the rawConfig via stdin and reads/returns the output
-func runConfigScript(configScript, appArmorProfile, rawConfig string, env []string) (newConfig string, err error) {
-	cmd := exec.Command(aaExec, "-p", appArmorProfile, configScript)
+// runConfigScript is a helper that just runs the config script confined
+// as the given app and passes the rawConfig via stdin and reads/returns
+// the output
+func runConfigScript(configScript, appID, rawConfig string, env []string) (newConfig string, err error) {
+	cmd := exec.Command(hookLauncher, launcherArgs(appID, configScript)...)
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return "", err
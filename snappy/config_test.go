@@ -45,11 +45,9 @@ printf "error: some error"
 exit 1
 `
 
-const mockAaExecScript = `#!/bin/sh
-[ "$1" = "-d" ]
-shift
-[ -n "$1" ]
-shift
+const mockLauncherScript = `#!/bin/sh
+[ "$1" = "internal-launch" ] && [ -n "$2" ] && [ "$3" = "--" ] || exit 1
+shift 3
 exec "$@"
 `
 
@@ -32,11 +32,35 @@ var (
 	snapDataHomeGlob string
 	snapAppArmorDir  string
 
+	snapAppArmorProfilesDir     string
+	snapAppArmorTemplatesDir    string
+	snapAppArmorPolicyGroupsDir string
+
+	snapSeccompFiltersDir      string
+	snapSeccompTemplatesDir    string
+	snapSeccompPolicyGroupsDir string
+
 	snapBinariesDir string
 	snapServicesDir string
 
 	clickSystemHooksDir string
 	cloudMetaDataFile   string
+	passwdFile          string
+
+	snapChannelsDir  string
+	snapMirrorDir    string
+	snapDownloadsDir string
+	snapCacheDir     string
+	snapHashesDir    string
+	snapPortsFile    string
+
+	snappyRepositoriesConfig string
+	snappyInstallJournal     string
+	snappyGCConfig           string
+
+	snappyArchitecturesConfig string
+
+	snappyKeyringsDir string
 )
 
 // SetRootDir allows settings a new global root directory, this is useful
@@ -50,12 +74,35 @@ func SetRootDir(rootdir string) {
 	snapDataHomeGlob = filepath.Join(rootdir, "/home/*/apps/")
 	snapAppArmorDir = filepath.Join(rootdir, "/var/lib/apparmor/clicks")
 
+	snapAppArmorProfilesDir = filepath.Join(rootdir, "/var/lib/snappy/apparmor/profiles")
+	snapAppArmorTemplatesDir = filepath.Join(rootdir, "/usr/share/apparmor/easyprof/templates/ubuntu-snappy/1.3")
+	snapAppArmorPolicyGroupsDir = filepath.Join(rootdir, "/usr/share/apparmor/easyprof/policygroups/ubuntu-snappy/1.3")
+
+	snapSeccompFiltersDir = filepath.Join(rootdir, "/var/lib/snappy/seccomp/filters")
+	snapSeccompTemplatesDir = filepath.Join(rootdir, "/usr/share/seccomp/templates/ubuntu-snappy/1.3")
+	snapSeccompPolicyGroupsDir = filepath.Join(rootdir, "/usr/share/seccomp/policygroups/ubuntu-snappy/1.3")
+
 	snapBinariesDir = filepath.Join(snapAppsDir, "bin")
 	snapServicesDir = filepath.Join(rootdir, "/etc/systemd/system")
 
 	clickSystemHooksDir = filepath.Join(rootdir, "/usr/share/click/hooks")
 
 	cloudMetaDataFile = filepath.Join(rootdir, "/var/lib/cloud/seed/nocloud-net/meta-data")
+	passwdFile = filepath.Join(rootdir, "/etc/passwd")
+
+	snapChannelsDir = filepath.Join(rootdir, "/var/lib/snappy/channels")
+	snapMirrorDir = filepath.Join(rootdir, "/var/lib/snappy/mirror")
+	snapDownloadsDir = filepath.Join(rootdir, "/var/lib/snappy/downloads")
+	snapCacheDir = filepath.Join(rootdir, "/var/lib/snappy/cache")
+	snapHashesDir = filepath.Join(rootdir, "/var/lib/snappy/hashes")
+	snapPortsFile = filepath.Join(rootdir, "/var/lib/snappy/ports.yaml")
+
+	snappyRepositoriesConfig = filepath.Join(rootdir, "/etc/snappy/repositories.yaml")
+	snappyInstallJournal = filepath.Join(rootdir, "/var/lib/snappy/install-journal.yaml")
+	snappyGCConfig = filepath.Join(rootdir, "/etc/snappy/gc.yaml")
+	snappyArchitecturesConfig = filepath.Join(rootdir, "/etc/snappy/architectures.yaml")
+
+	snappyKeyringsDir = filepath.Join(rootdir, "/usr/share/snappy/keyrings")
 }
 
 func init() {
@@ -20,6 +20,8 @@ package snappy
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
 var (
@@ -69,9 +71,9 @@ var (
 	// ErrInvalidCredentials is returned on login error
 	ErrInvalidCredentials = errors.New("invalid credentials")
 
-	// ErrInvalidPackageYaml is returned is a package.yaml file can not
-	// be parsed
-	ErrInvalidPackageYaml = errors.New("can not parse package.yaml")
+	// ErrServiceNotFound is returned if a snap does not have the
+	// given service
+	ErrServiceNotFound = errors.New("snap service not found")
 
 	// ErrSnapNotActive is returned if you try to unset a snap from
 	// active to inactive
@@ -95,6 +97,22 @@ var (
 	// ErrLicenseNotProvided is returned when the package specifies that
 	// accepting a license is required, but no license file is provided
 	ErrLicenseNotProvided = errors.New("package.yaml requires license, but no license was provided")
+
+	// ErrInvalidChannel is returned when a snap is requested from a
+	// channel the store does not know about
+	ErrInvalidChannel = errors.New("invalid channel, must be one of stable, candidate, beta or edge")
+
+	// ErrRepositoryTimeout is returned when a repository does not
+	// answer in time
+	ErrRepositoryTimeout = errors.New("repository did not answer in time")
+
+	// ErrNoTrustedKeys is returned when a signature can not be checked
+	// because there are no trusted keys
+	ErrNoTrustedKeys = errors.New("no trusted keys to check the signature with")
+
+	// ErrNoHashes is returned when a installed snap can not be
+	// verified because it has no meta/hashes.yaml
+	ErrNoHashes = errors.New("snap has no meta/hashes.yaml")
 )
 
 // ErrUnpackFailed is the error type for a snap unpack problem
@@ -109,13 +127,89 @@ func (e *ErrUnpackFailed) Error() string {
 	return fmt.Sprintf("unpack %s to %s failed with %s", e.snapFile, e.instDir, e.origErr)
 }
 
-// ErrSignature is returned if a snap failed the signature verification
-type ErrSignature struct {
-	exitCode int
+// ErrUnknownSigningKey is returned if a snap is signed by a key that
+// is not in the trusted keyring
+type ErrUnknownSigningKey struct {
+	keyID uint64
+}
+
+func (e *ErrUnknownSigningKey) Error() string {
+	return fmt.Sprintf("snap is signed by unknown key %016X", e.keyID)
+}
+
+// ErrBadSignature is returned if the signature of a snap does not match
+// its content
+type ErrBadSignature struct {
+	err error
+}
+
+func (e *ErrBadSignature) Error() string {
+	return fmt.Sprintf("bad signature: %s", e.err)
+}
+
+// ErrSnapHookFailed is returned if a meta/hooks script of a snap failed
+type ErrSnapHookFailed struct {
+	snap   string
+	hook   string
+	output string
+}
+
+func (e *ErrSnapHookFailed) Error() string {
+	return fmt.Sprintf("%s hook of %s failed: %q", e.hook, e.snap, e.output)
 }
 
-func (e *ErrSignature) Error() string {
-	return fmt.Sprintf("Signature verification failed with exit status %v", e.exitCode)
+// ErrArchitectureNotSupported is returned if a snap does not run on the
+// architecture of the system
+type ErrArchitectureNotSupported struct {
+	snap          string
+	architectures []string
+	arch          ArchitectureType
+}
+
+func (e *ErrArchitectureNotSupported) Error() string {
+	return fmt.Sprintf("%s is for %s and does not run on %s", e.snap, strings.Join(e.architectures, ", "), e.arch)
+}
+
+// ErrInvalidPackageYaml is returned if a package.yaml file does not
+// follow the rules from docs/meta.md
+type ErrInvalidPackageYaml struct {
+	problems []string
+}
+
+func (e *ErrInvalidPackageYaml) Error() string {
+	return fmt.Sprintf("invalid package.yaml: %s", strings.Join(e.problems, "; "))
+}
+
+// ErrPortConflict is returned if a snap wants a port that is not
+// negotiable and already used by another snap
+type ErrPortConflict struct {
+	snap  string
+	port  string
+	owner string
+}
+
+func (e *ErrPortConflict) Error() string {
+	return fmt.Sprintf("%s can not use port %s, it is already used by %s", e.snap, e.port, e.owner)
+}
+
+// ErrUnknownSecurityTemplate is returned if a app uses a
+// security-template that is not available on the system
+type ErrUnknownSecurityTemplate struct {
+	template string
+}
+
+func (e *ErrUnknownSecurityTemplate) Error() string {
+	return fmt.Sprintf("unknown security-template %q", e.template)
+}
+
+// ErrUnknownCap is returned if a app uses a cap that is not available
+// on the system
+type ErrUnknownCap struct {
+	cap string
+}
+
+func (e *ErrUnknownCap) Error() string {
+	return fmt.Sprintf("unknown cap %q", e.cap)
 }
 
 // ErrSystemCtl is returned if the systemctl command failed
@@ -128,6 +222,27 @@ func (e *ErrSystemCtl) Error() string {
 	return fmt.Sprintf("%v failed with exit status %d", e.cmd, e.exitCode)
 }
 
+// ErrServiceStopTimeout is returned if a service did not stop in time
+// and had to be killed
+type ErrServiceStopTimeout struct {
+	unit    string
+	timeout time.Duration
+}
+
+func (e *ErrServiceStopTimeout) Error() string {
+	return fmt.Sprintf("%s did not stop within %s and was killed", e.unit, e.timeout)
+}
+
+// ErrServiceNotStopped is returned if a service is still running after
+// it was killed
+type ErrServiceNotStopped struct {
+	unit string
+}
+
+func (e *ErrServiceNotStopped) Error() string {
+	return fmt.Sprintf("%s did not stop after it was killed", e.unit)
+}
+
 // ErrHookFailed is returned if a hook command fails
 type ErrHookFailed struct {
 	cmd      string
@@ -140,13 +255,13 @@ func (e *ErrHookFailed) Error() string {
 
 // ErrDataCopyFailed is returned if copying the snap data fialed
 type ErrDataCopyFailed struct {
-	oldPath  string
-	newPath  string
-	exitCode int
+	oldPath string
+	newPath string
+	err     error
 }
 
 func (e *ErrDataCopyFailed) Error() string {
-	return fmt.Sprintf("data copy from %v to %v failed with exit status %d", e.oldPath, e.newPath, e.exitCode)
+	return fmt.Sprintf("data copy from %v to %v failed: %s", e.oldPath, e.newPath, e.err)
 }
 
 // ErrUpgradeVerificationFailed is returned if the upgrade has not
@@ -158,3 +273,100 @@ type ErrUpgradeVerificationFailed struct {
 func (e *ErrUpgradeVerificationFailed) Error() string {
 	return fmt.Sprintf("upgrade verification failed: %s", e.msg)
 }
+
+// ErrHashMismatch is returned if a snap file does not have the sha512
+// it is expected to have
+type ErrHashMismatch struct {
+	file     string
+	expected string
+	got      string
+}
+
+func (e *ErrHashMismatch) Error() string {
+	return fmt.Sprintf("sha512 mismatch for %s: expected %s got %s", e.file, e.expected, e.got)
+}
+
+// ErrInvalidRemoteSnap is returned if the store sends a snap name or
+// version that is not valid
+type ErrInvalidRemoteSnap struct {
+	field string
+	value string
+}
+
+func (e *ErrInvalidRemoteSnap) Error() string {
+	return fmt.Sprintf("invalid snap %s %q", e.field, e.value)
+}
+
+// ErrMissingFrameworks is returned if a snap needs frameworks that are
+// not installed and can not be installed
+type ErrMissingFrameworks struct {
+	frameworks []string
+}
+
+func (e *ErrMissingFrameworks) Error() string {
+	return fmt.Sprintf("missing frameworks: %s", strings.Join(e.frameworks, ", "))
+}
+
+// ErrUnsatisfiedFrameworks is returned if a snap needs versions of
+// frameworks that are not installed
+type ErrUnsatisfiedFrameworks struct {
+	deps []string
+}
+
+func (e *ErrUnsatisfiedFrameworks) Error() string {
+	return fmt.Sprintf("framework dependencies not met: %s", strings.Join(e.deps, "; "))
+}
+
+// ErrFrameworkUpdateBreaks is returned if a framework is updated to a
+// version that does not meet the constraints of the snaps that use it
+type ErrFrameworkUpdateBreaks struct {
+	framework string
+	version   string
+	broken    []string
+}
+
+func (e *ErrFrameworkUpdateBreaks) Error() string {
+	return fmt.Sprintf("%s %s would break: %s", e.framework, e.version, strings.Join(e.broken, "; "))
+}
+
+// ErrInvalidFrameworkDep is returned if a entry of the framework field
+// of the package.yaml can not be parsed
+type ErrInvalidFrameworkDep struct {
+	entry string
+}
+
+func (e *ErrInvalidFrameworkDep) Error() string {
+	return fmt.Sprintf("invalid framework dependency %q", e.entry)
+}
+
+// ErrFrameworkInUse is returned if a framework is removed that is
+// used by active snaps
+type ErrFrameworkInUse struct {
+	framework string
+	users     []string
+}
+
+func (e *ErrFrameworkInUse) Error() string {
+	return fmt.Sprintf("framework %s is used by: %s", e.framework, strings.Join(e.users, ", "))
+}
+
+// ErrRepositories is returned by the MetaRepository if some of its
+// repositories failed, the results of the others are still returned
+type ErrRepositories struct {
+	errs []repositoryError
+}
+
+// repositoryError is the error of a single repository
+type repositoryError struct {
+	repo string
+	err  error
+}
+
+func (e *ErrRepositories) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, repoErr := range e.errs {
+		msgs[i] = fmt.Sprintf("%s: %s", repoErr.repo, repoErr.err)
+	}
+
+	return fmt.Sprintf("%d repositories failed: %s", len(e.errs), strings.Join(msgs, ", "))
+}
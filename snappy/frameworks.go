@@ -0,0 +1,278 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// the framework of ubuntu-core itself, it is always available
+const coreFramework = "ubuntu-core-15.04-dev1"
+
+// the relations that can be used in framework version constraints
+const (
+	relationLess         = "<<"
+	relationLessEqual    = "<="
+	relationEqual        = "="
+	relationGreaterEqual = ">="
+	relationGreater      = ">>"
+)
+
+// a single entry of the framework field, e.g. "docker (>= 1.6)"
+var frameworkDepRegexp = regexp.MustCompile(`^([^\s(),]+)\s*(?:\(\s*(<<|<=|=|>=|>>)\s*([^\s()]+)\s*\))?$`)
+
+// frameworkDep is a framework a snap needs with an optional constraint
+// on its version
+type frameworkDep struct {
+	name     string
+	relation string
+	version  string
+}
+
+func (d frameworkDep) String() string {
+	if d.relation == "" {
+		return d.name
+	}
+
+	return fmt.Sprintf("%s (%s %s)", d.name, d.relation, d.version)
+}
+
+// satisfiedBy returns true if the given version of the framework
+// meets the constraint
+func (d frameworkDep) satisfiedBy(version string) bool {
+	cmp := VersionCompare(version, d.version)
+	switch d.relation {
+	case relationLess:
+		return cmp < 0
+	case relationLessEqual:
+		return cmp <= 0
+	case relationEqual:
+		return cmp == 0
+	case relationGreaterEqual:
+		return cmp >= 0
+	case relationGreater:
+		return cmp > 0
+	}
+
+	return true
+}
+
+// parseFrameworkDeps takes the framework field of the package.yaml,
+// e.g. "docker (>= 1.6), foo-fw (<< 2)" and returns the dependencies
+func parseFrameworkDeps(field string) (deps []frameworkDep, err error) {
+	for _, entry := range strings.Split(field, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		match := frameworkDepRegexp.FindStringSubmatch(entry)
+		if match == nil {
+			return nil, &ErrInvalidFrameworkDep{entry: entry}
+		}
+		deps = append(deps, frameworkDep{name: match[1], relation: match[2], version: match[3]})
+	}
+
+	return deps, nil
+}
+
+// parseFrameworks takes the framework field of the package.yaml and
+// returns the names of the frameworks, invalid entries are skipped
+func parseFrameworks(field string) (frameworks []string) {
+	for _, entry := range strings.Split(field, ",") {
+		deps, err := parseFrameworkDeps(entry)
+		if err != nil || len(deps) == 0 {
+			continue
+		}
+		frameworks = append(frameworks, deps[0].name)
+	}
+
+	return frameworks
+}
+
+// missingFrameworks returns the frameworks from the given list that
+// are not installed
+func missingFrameworks(frameworks []string) (missing []string, err error) {
+	installed, err := InstalledSnapNamesByType(SnapTypeFramework)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fw := range frameworks {
+		if fw == coreFramework || contains(installed, fw) {
+			continue
+		}
+		missing = append(missing, fw)
+	}
+
+	return missing, nil
+}
+
+// checkFrameworks returns a error if any of the frameworks in the
+// given framework field is not installed or if its version does not
+// meet the constraint
+func checkFrameworks(field string) error {
+	deps, err := parseFrameworkDeps(field)
+	if err != nil {
+		return err
+	}
+
+	installed, err := InstalledSnapsByType(SnapTypeFramework)
+	if err != nil {
+		return err
+	}
+
+	var missing, unsatisfied []string
+	for _, dep := range deps {
+		if dep.name == coreFramework {
+			continue
+		}
+
+		fws := FindSnapsByName(dep.name, installed)
+		if len(fws) == 0 {
+			missing = append(missing, dep.name)
+			continue
+		}
+		if !dep.satisfiedBy(fws[0].Version()) {
+			unsatisfied = append(unsatisfied, fmt.Sprintf("%s but %s is installed", dep, fws[0].Version()))
+		}
+	}
+
+	if len(missing) > 0 {
+		return &ErrMissingFrameworks{frameworks: missing}
+	}
+	if len(unsatisfied) > 0 {
+		return &ErrUnsatisfiedFrameworks{deps: unsatisfied}
+	}
+
+	return nil
+}
+
+// checkFrameworkUpdate returns a error if the given version of the
+// framework with the given name does not meet the constraints of the
+// active snaps that use it
+func checkFrameworkUpdate(name, version string) error {
+	active, err := InstalledSnapsByType(SnapTypeApp, SnapTypeOem)
+	if err != nil {
+		return err
+	}
+
+	var broken []string
+	for _, part := range active {
+		snap, ok := part.(*SnapPart)
+		if !ok {
+			continue
+		}
+		deps, err := parseFrameworkDeps(snap.m.Framework)
+		if err != nil {
+			// it got installed so this can not happen
+			return err
+		}
+		for _, dep := range deps {
+			if dep.name == name && !dep.satisfiedBy(version) {
+				broken = append(broken, fmt.Sprintf("%s needs %s", snap.Name(), dep))
+			}
+		}
+	}
+
+	if len(broken) > 0 {
+		return &ErrFrameworkUpdateBreaks{framework: name, version: version, broken: broken}
+	}
+
+	return nil
+}
+
+// installMissingFrameworks installs the frameworks from the given
+// list that are not installed yet from the repositories
+func installMissingFrameworks(frameworks []string, flags InstallFlags) error {
+	missing, err := missingFrameworks(frameworks)
+	if err != nil {
+		return err
+	}
+
+	var notFound []string
+	m := newMetaRepository()
+	for _, fw := range missing {
+		// the framework can be installed if one of the
+		// repositories has it, even if others failed
+		found, err := m.Details(fw)
+		part := firstNotInstalled(found)
+		if part == nil && err != nil {
+			return err
+		}
+		if part == nil {
+			notFound = append(notFound, fw)
+			continue
+		}
+
+		pbar := NewTextProgress(part.Name())
+		if err := part.Install(pbar, flags); err != nil {
+			return err
+		}
+	}
+	if len(notFound) > 0 {
+		return &ErrMissingFrameworks{frameworks: notFound}
+	}
+
+	return nil
+}
+
+// frameworkUsers returns the names of the active snaps that use the
+// framework with the given name
+func frameworkUsers(name string) (users []string, err error) {
+	active, err := InstalledSnapsByType(SnapTypeApp, SnapTypeOem)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range active {
+		frameworks, err := part.Frameworks()
+		if err != nil {
+			return nil, err
+		}
+		if contains(frameworks, name) {
+			users = append(users, part.Name())
+		}
+	}
+
+	return users, nil
+}
+
+// firstNotInstalled returns the first part that can be installed or
+// nil if there is none
+func firstNotInstalled(parts []Part) Part {
+	for _, part := range parts {
+		if !part.IsInstalled() {
+			return part
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, needle string) bool {
+	for _, s := range list {
+		if s == needle {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+)
+
+const (
+	frameworkYaml = "name: fw\nversion: 1.0\ntype: framework\nvendor: Foo Bar <foo@example.com>\n"
+	fwAppYaml     = "name: app\nversion: 1.0\nframework: fw, ubuntu-core-15.04-dev1\nvendor: Foo Bar <foo@example.com>\n"
+)
+
+// makeFrameworkMirror puts a snap with the given package.yaml into the
+// default mirror
+func makeFrameworkMirror(c *C, packageYaml string) {
+	c.Assert(os.MkdirAll(snapMirrorDir, 0755), IsNil)
+	snapFile := makeTestSnapPackage(c, packageYaml)
+	c.Assert(os.Rename(snapFile, filepath.Join(snapMirrorDir, filepath.Base(snapFile))), IsNil)
+	c.Assert(BuildMirrorIndex(snapMirrorDir), IsNil)
+}
+
+func (s *SnapTestSuite) TestParseFrameworks(c *C) {
+	c.Assert(parseFrameworks(""), HasLen, 0)
+	c.Assert(parseFrameworks("docker"), DeepEquals, []string{"docker"})
+	c.Assert(parseFrameworks("docker, foo-fw,bar "), DeepEquals, []string{"docker", "foo-fw", "bar"})
+	c.Assert(parseFrameworks("docker (>= 1.6), foo-fw (<< 2)"), DeepEquals, []string{"docker", "foo-fw"})
+}
+
+func (s *SnapTestSuite) TestParseFrameworkDeps(c *C) {
+	deps, err := parseFrameworkDeps("docker (>= 1.6), foo-fw (<<2),bar, baz ( = 1.0-1 )")
+	c.Assert(err, IsNil)
+	c.Assert(deps, DeepEquals, []frameworkDep{
+		{name: "docker", relation: ">=", version: "1.6"},
+		{name: "foo-fw", relation: "<<", version: "2"},
+		{name: "bar"},
+		{name: "baz", relation: "=", version: "1.0-1"},
+	})
+	c.Assert(deps[0].String(), Equals, "docker (>= 1.6)")
+	c.Assert(deps[2].String(), Equals, "bar")
+}
+
+func (s *SnapTestSuite) TestParseFrameworkDepsInvalid(c *C) {
+	for _, field := range []string{"docker (> 1.6)", "docker (>= 1.6", "docker >= 1.6", "docker (>=)"} {
+		_, err := parseFrameworkDeps(field)
+		c.Assert(err, FitsTypeOf, &ErrInvalidFrameworkDep{}, Commentf(field))
+	}
+}
+
+func (s *SnapTestSuite) TestFrameworkDepSatisfiedBy(c *C) {
+	for _, t := range []struct {
+		dep      frameworkDep
+		version  string
+		expected bool
+	}{
+		{frameworkDep{name: "fw"}, "1.0", true},
+		{frameworkDep{"fw", "<<", "2"}, "1.9", true},
+		{frameworkDep{"fw", "<<", "2"}, "2", false},
+		{frameworkDep{"fw", "<=", "2"}, "2", true},
+		{frameworkDep{"fw", "<=", "2"}, "2.1", false},
+		{frameworkDep{"fw", "=", "1.0"}, "1.0", true},
+		{frameworkDep{"fw", "=", "1.0"}, "1.0.1", false},
+		{frameworkDep{"fw", ">=", "1.6"}, "1.10", true},
+		{frameworkDep{"fw", ">=", "1.6"}, "1.5", false},
+		{frameworkDep{"fw", ">>", "1.6"}, "1.6", false},
+		{frameworkDep{"fw", ">>", "1.6"}, "1.6.1", true},
+	} {
+		c.Check(t.dep.satisfiedBy(t.version), Equals, t.expected, Commentf("%s %s", t.dep, t.version))
+	}
+}
+
+func (s *SnapTestSuite) TestInstallClickMissingFramework(c *C) {
+	snapFile := makeTestSnapPackage(c, fwAppYaml)
+
+	err := installClick(snapFile, AllowUnauthenticated, nil)
+	c.Assert(err, FitsTypeOf, &ErrMissingFrameworks{})
+	c.Assert(err, ErrorMatches, "missing frameworks: fw")
+	c.Assert(ActiveSnapByName("app"), IsNil)
+}
+
+func (s *SnapTestSuite) TestInstallResolvesFrameworks(c *C) {
+	makeFrameworkMirror(c, frameworkYaml)
+	snapFile := makeTestSnapPackage(c, fwAppYaml)
+
+	c.Assert(Install(snapFile, AllowUnauthenticated), IsNil)
+	c.Assert(ActiveSnapByName("fw"), NotNil)
+	c.Assert(ActiveSnapByName("app"), NotNil)
+}
+
+func (s *SnapTestSuite) TestInstallFromRepositoryResolvesFrameworks(c *C) {
+	makeFrameworkMirror(c, frameworkYaml)
+	makeFrameworkMirror(c, fwAppYaml)
+
+	c.Assert(Install("app", AllowUnauthenticated), IsNil)
+	c.Assert(ActiveSnapByName("fw"), NotNil)
+	c.Assert(ActiveSnapByName("app"), NotNil)
+}
+
+func (s *SnapTestSuite) TestInstallFrameworkNotFound(c *C) {
+	snapFile := makeTestSnapPackage(c, fwAppYaml)
+
+	err := Install(snapFile, AllowUnauthenticated)
+	c.Assert(err, ErrorMatches, "missing frameworks: fw")
+	c.Assert(ActiveSnapByName("app"), IsNil)
+}
+
+func (s *SnapTestSuite) TestInstallFrameworkLookupFails(c *C) {
+	c.Assert(os.MkdirAll(snapAppsDir, 0755), IsNil)
+	newMetaRepository = func() *MetaRepository {
+		return &MetaRepository{all: []Repository{
+			NewLocalSnapRepository(snapAppsDir),
+			&fakeRepository{name: "store", err: errors.New("store is down")},
+		}}
+	}
+	snapFile := makeTestSnapPackage(c, fwAppYaml)
+
+	err := Install(snapFile, AllowUnauthenticated)
+	c.Assert(err, ErrorMatches, "1 repositories failed: store: store is down")
+	c.Assert(ActiveSnapByName("app"), IsNil)
+}
+
+func (s *SnapTestSuite) TestRemoveFrameworkInUse(c *C) {
+	snapFile := makeTestSnapPackage(c, frameworkYaml)
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+	snapFile = makeTestSnapPackage(c, fwAppYaml)
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	err := Remove("fw", 0)
+	c.Assert(err, FitsTypeOf, &ErrFrameworkInUse{})
+	c.Assert(err, ErrorMatches, "framework fw is used by: app")
+	c.Assert(ActiveSnapByName("fw"), NotNil)
+
+	c.Assert(Remove("fw", ForceRemove), IsNil)
+	c.Assert(ActiveSnapByName("fw"), IsNil)
+}
+
+func (s *SnapTestSuite) TestRemoveUnusedFramework(c *C) {
+	snapFile := makeTestSnapPackage(c, frameworkYaml)
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	c.Assert(Remove("fw", 0), IsNil)
+	c.Assert(ActiveSnapByName("fw"), IsNil)
+}
+
+func (s *SnapTestSuite) TestInstallClickUnsatisfiedFrameworkVersion(c *C) {
+	snapFile := makeTestSnapPackage(c, frameworkYaml)
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	snapFile = makeTestSnapPackage(c, "name: app\nversion: 1.0\nframework: fw (>= 1.6)\nvendor: Foo Bar <foo@example.com>\n")
+	err := installClick(snapFile, AllowUnauthenticated, nil)
+	c.Assert(err, FitsTypeOf, &ErrUnsatisfiedFrameworks{})
+	c.Assert(err, ErrorMatches, `framework dependencies not met: fw \(>= 1.6\) but 1.0 is installed`)
+	c.Assert(ActiveSnapByName("app"), IsNil)
+}
+
+func (s *SnapTestSuite) TestInstallClickSatisfiedFrameworkVersion(c *C) {
+	snapFile := makeTestSnapPackage(c, frameworkYaml)
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	snapFile = makeTestSnapPackage(c, "name: app\nversion: 1.0\nframework: fw (>= 1.0), fw (<< 2)\nvendor: Foo Bar <foo@example.com>\n")
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+	c.Assert(ActiveSnapByName("app"), NotNil)
+}
+
+func (s *SnapTestSuite) TestFrameworkUpdateBreaksApp(c *C) {
+	snapFile := makeTestSnapPackage(c, frameworkYaml)
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+	snapFile = makeTestSnapPackage(c, "name: app\nversion: 1.0\nframework: fw (<< 2)\nvendor: Foo Bar <foo@example.com>\n")
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	// 1.5 is fine
+	snapFile = makeTestSnapPackage(c, "name: fw\nversion: 1.5\ntype: framework\nvendor: Foo Bar <foo@example.com>\n")
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+	c.Assert(ActiveSnapByName("fw").Version(), Equals, "1.5")
+
+	// 2.0 is not
+	snapFile = makeTestSnapPackage(c, "name: fw\nversion: 2.0\ntype: framework\nvendor: Foo Bar <foo@example.com>\n")
+	err := installClick(snapFile, AllowUnauthenticated, nil)
+	c.Assert(err, FitsTypeOf, &ErrFrameworkUpdateBreaks{})
+	c.Assert(err, ErrorMatches, `fw 2.0 would break: app needs fw \(<< 2\)`)
+	c.Assert(ActiveSnapByName("fw").Version(), Equals, "1.5")
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"launchpad.net/snappy/helpers"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the number of versions of a snap that are kept if there is no gc
+// config, the active one and one to rollback to
+const defaultKeepVersions = 2
+
+// the /etc/snappy/gc.yaml file
+type gcConfig struct {
+	// the number of installed versions that are kept per snap,
+	// including the active one
+	KeepVersions int `yaml:"keep-versions"`
+	// remove the old versions of a snap after each install, their
+	// data is kept until "snappy gc" is run; on unless set to false
+	RemoveOnInstall bool `yaml:"remove-on-install"`
+}
+
+// readGCConfig reads the gc config, the number of versions to keep
+// defaults to defaultKeepVersions and they are removed on install
+func readGCConfig() (*gcConfig, error) {
+	cfg := &gcConfig{
+		KeepVersions:    defaultKeepVersions,
+		RemoveOnInstall: true,
+	}
+
+	content, err := ioutil.ReadFile(snappyGCConfig)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(content, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.KeepVersions < 1 {
+		return nil, fmt.Errorf("%s: keep-versions must be at least 1", snappyGCConfig)
+	}
+
+	return cfg, nil
+}
+
+// snapDataDirs returns the system and the per user data dirs of the
+// given version of the snap, or of all versions if version is "*"
+func snapDataDirs(name, version string) (dirs []string, err error) {
+	for _, glob := range []string{snapDataHomeGlob, snapDataDir} {
+		matches, err := filepath.Glob(filepath.Join(glob, name, version))
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, matches...)
+	}
+
+	return dirs, nil
+}
+
+// purgeSnapData removes the data dirs of the given version of the snap
+// (or of all versions if version is "*") and the snap dirs that are
+// empty then
+func purgeSnapData(name, version string) error {
+	dirs, err := snapDataDirs(name, version)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		// fails if there is still data of other versions
+		os.Remove(filepath.Dir(dir))
+	}
+
+	return nil
+}
+
+// snapIsInstalled returns true if the given version of the snap is
+// installed, any version if the version is empty
+func snapIsInstalled(name, version string) bool {
+	if version == "" {
+		version = "*"
+	}
+	for _, dir := range []string{snapAppsDir, snapOemDir} {
+		matches, _ := filepath.Glob(filepath.Join(dir, name, version, "meta", "package.yaml"))
+		if len(matches) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeSnapVersion removes the given inactive snap version and, with
+// purgeData, its data
+func removeSnapVersion(part *SnapPart, purgeData bool) error {
+	if err := removeClick(part.basedir); err != nil {
+		return err
+	}
+	if !purgeData {
+		return nil
+	}
+
+	return purgeSnapData(part.Name(), part.Version())
+}
+
+// oldSnapVersions returns the installed versions of the snap that are
+// not kept by the retention policy
+func oldSnapVersions(name string, keep int) (old []*SnapPart, err error) {
+	var versions []Part
+	for _, dir := range []string{snapAppsDir, snapOemDir} {
+		repo := NewLocalSnapRepository(dir)
+		if repo == nil {
+			continue
+		}
+		parts, err := repo.Details(name)
+		if err == ErrPackageNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, parts...)
+	}
+
+	// newest first, the active version is always kept
+	sort.Sort(sort.Reverse(BySnapVersion(versions)))
+	kept := 1
+	for _, part := range versions {
+		snap, ok := part.(*SnapPart)
+		if !ok || snap.IsActive() {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		old = append(old, snap)
+	}
+
+	return old, nil
+}
+
+// gcSnapVersions removes the installed versions of the given snap that
+// are not among the keep newest ones, with purgeData together with
+// their data, and returns the removed dirs
+func gcSnapVersions(name string, keep int, purgeData, dryRun bool) (removed []string, err error) {
+	old, err := oldSnapVersions(name, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snap := range old {
+		var dataDirs []string
+		if purgeData {
+			dataDirs, err = snapDataDirs(snap.Name(), snap.Version())
+			if err != nil {
+				return removed, err
+			}
+		}
+		if !dryRun {
+			if err := removeSnapVersion(snap, purgeData); err != nil {
+				return removed, err
+			}
+		}
+		removed = append(removed, snap.basedir)
+		removed = append(removed, dataDirs...)
+	}
+
+	return removed, nil
+}
+
+// gcOnInstall removes the old versions of the given snap after a
+// install unless "remove-on-install" is false in the gc config. Their
+// data is kept, it is only removed by GarbageCollect.
+func gcOnInstall(name string) error {
+	cfg, err := readGCConfig()
+	if err != nil {
+		return err
+	}
+	if !cfg.RemoveOnInstall {
+		return nil
+	}
+
+	removed, err := gcSnapVersions(name, cfg.KeepVersions, false, false)
+	for _, dir := range removed {
+		log.Printf("Removed old version %s", dir)
+	}
+
+	return err
+}
+
+// OrphanedDataDirs returns the data dirs of snap versions that are no
+// longer installed
+func OrphanedDataDirs() (orphaned []string, err error) {
+	dirs, err := snapDataDirs("*", "*")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		if !helpers.IsDirectory(dir) {
+			continue
+		}
+		name := filepath.Base(filepath.Dir(dir))
+		if !snapIsInstalled(name, filepath.Base(dir)) {
+			orphaned = append(orphaned, dir)
+		}
+	}
+
+	return orphaned, nil
+}
+
+// GarbageCollect removes the versions of all installed snaps that are
+// not kept by the retention policy and the data dirs of versions that
+// are no longer installed. It returns the removed dirs, with dryRun
+// nothing is removed.
+func GarbageCollect(dryRun bool) (removed []string, err error) {
+	cfg, err := readGCConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, dir := range []string{snapAppsDir, snapOemDir} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*", "*", "meta", "package.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			name := filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(m))))
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	for _, name := range names {
+		dirs, err := gcSnapVersions(name, cfg.KeepVersions, true, dryRun)
+		removed = append(removed, dirs...)
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	orphaned, err := OrphanedDataDirs()
+	if err != nil {
+		return removed, err
+	}
+	for _, dir := range orphaned {
+		if !dryRun {
+			if err := os.RemoveAll(dir); err != nil {
+				return removed, err
+			}
+			os.Remove(filepath.Dir(dir))
+		}
+		removed = append(removed, dir)
+	}
+
+	return removed, nil
+}
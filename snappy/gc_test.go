@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"launchpad.net/snappy/helpers"
+
+	. "launchpad.net/gocheck"
+)
+
+func installTestSnapVersion(c *C, version string) {
+	snapFile := makeTestSnapPackage(c, "name: foo\nversion: "+version+"\nvendor: Foo Bar <foo@example.com>\n")
+	c.Assert(installClick(snapFile, 0, nil), IsNil)
+}
+
+func writeTestGCConfig(c *C, content string) {
+	c.Assert(os.MkdirAll(filepath.Dir(snappyGCConfig), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(snappyGCConfig, []byte(content), 0644), IsNil)
+}
+
+func (s *SnapTestSuite) TestGCOnInstallDisabled(c *C) {
+	writeTestGCConfig(c, "remove-on-install: false\n")
+
+	installTestSnapVersion(c, "1.0")
+	installTestSnapVersion(c, "2.0")
+	installTestSnapVersion(c, "3.0")
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "1.0")), Equals, true)
+	c.Assert(helpers.FileExists(filepath.Join(snapDataDir, "foo", "1.0")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestGCOnInstallKeepsTwoVersions(c *C) {
+	// no gc config, the defaults apply
+	installTestSnapVersion(c, "1.0")
+	installTestSnapVersion(c, "2.0")
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "1.0")), Equals, true)
+
+	installTestSnapVersion(c, "3.0")
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "1.0")), Equals, false)
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "2.0")), Equals, true)
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "3.0")), Equals, true)
+	// the data is only removed by a explicit gc
+	c.Assert(helpers.FileExists(filepath.Join(snapDataDir, "foo", "1.0")), Equals, true)
+
+	removed, err := GarbageCollect(false)
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []string{filepath.Join(snapDataDir, "foo", "1.0")})
+	c.Assert(helpers.FileExists(filepath.Join(snapDataDir, "foo", "1.0")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestGCOnInstallKeepVersionsConfig(c *C) {
+	writeTestGCConfig(c, "keep-versions: 1\n")
+
+	installTestSnapVersion(c, "1.0")
+	installTestSnapVersion(c, "2.0")
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "1.0")), Equals, false)
+	c.Assert(ActiveSnapByName("foo").Version(), Equals, "2.0")
+}
+
+func (s *SnapTestSuite) TestGCKeepsActiveVersion(c *C) {
+	writeTestGCConfig(c, "keep-versions: 1\n")
+	installTestSnapVersion(c, "2.0")
+	// an older version that gets active, e.g. from a rollback
+	installTestSnapVersion(c, "1.0")
+
+	c.Assert(ActiveSnapByName("foo").Version(), Equals, "1.0")
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "2.0")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestGCInvalidConfigKeepsEverything(c *C) {
+	writeTestGCConfig(c, "keep-versions: -1\n")
+
+	installTestSnapVersion(c, "1.0")
+	installTestSnapVersion(c, "2.0")
+	installTestSnapVersion(c, "3.0")
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "1.0")), Equals, true)
+
+	_, err := GarbageCollect(false)
+	c.Assert(err, ErrorMatches, ".*keep-versions must be at least 1")
+}
+
+func (s *SnapTestSuite) TestGarbageCollectOldVersions(c *C) {
+	writeTestGCConfig(c, "remove-on-install: false\n")
+	installTestSnapVersion(c, "1.0")
+	installTestSnapVersion(c, "2.0")
+	installTestSnapVersion(c, "3.0")
+
+	removed, err := GarbageCollect(false)
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []string{
+		filepath.Join(snapAppsDir, "foo", "1.0"),
+		filepath.Join(snapDataDir, "foo", "1.0"),
+	})
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "2.0")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestGarbageCollectOrphanedData(c *C) {
+	installTestSnapVersion(c, "1.0")
+	orphans := []string{
+		filepath.Join(snapDataDir, "bar", "1.0"),
+		filepath.Join(snapDataDir, "foo", "0.9"),
+		filepath.Join(s.tempdir, "home", "user1", "apps", "bar", "1.0"),
+	}
+	for _, dir := range orphans {
+		c.Assert(os.MkdirAll(dir, 0755), IsNil)
+	}
+
+	orphaned, err := OrphanedDataDirs()
+	c.Assert(err, IsNil)
+	c.Assert(orphaned, HasLen, 3)
+
+	removed, err := GarbageCollect(true)
+	c.Assert(err, IsNil)
+	c.Assert(removed, HasLen, 3)
+	for _, dir := range orphans {
+		c.Assert(helpers.FileExists(dir), Equals, true)
+	}
+
+	removed, err = GarbageCollect(false)
+	c.Assert(err, IsNil)
+	c.Assert(removed, HasLen, 3)
+	for _, dir := range orphans {
+		c.Assert(helpers.FileExists(dir), Equals, false)
+	}
+	c.Assert(helpers.FileExists(filepath.Join(snapDataDir, "bar")), Equals, false)
+	c.Assert(helpers.FileExists(filepath.Join(snapDataDir, "foo", "1.0")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestRemovePurge(c *C) {
+	installTestSnapVersion(c, "1.0")
+	homeData := filepath.Join(s.tempdir, "home", "user1", "apps", "foo", "1.0")
+	c.Assert(os.MkdirAll(homeData, 0755), IsNil)
+
+	c.Assert(Remove("foo", PurgeData), IsNil)
+	c.Assert(helpers.FileExists(filepath.Join(snapDataDir, "foo")), Equals, false)
+	c.Assert(helpers.FileExists(homeData), Equals, false)
+}
+
+func (s *SnapTestSuite) TestRemoveKeepsData(c *C) {
+	installTestSnapVersion(c, "1.0")
+
+	c.Assert(Remove("foo", 0), IsNil)
+	c.Assert(helpers.FileExists(filepath.Join(snapDataDir, "foo", "1.0")), Equals, true)
+}
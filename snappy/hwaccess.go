@@ -21,15 +21,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"launchpad.net/snappy/helpers"
 )
 
-var aaClickHookCmd = "aa-clickhook"
-
 type appArmorAdditionalJSON struct {
 	WritePath []string `json:"write_path"`
 }
@@ -77,18 +74,16 @@ func writeHWAccessJSONFile(snapname string, appArmorAdditional appArmorAdditiona
 	return nil
 }
 
-func regenerateAppArmorRulesImpl() error {
-	if err := exec.Command(aaClickHookCmd, "-f").Run(); err != nil {
-		if exitCode, err := helpers.ExitCode(err); err != nil {
-			return &ErrHookFailed{
-				cmd:      aaClickHookCmd,
-				exitCode: exitCode,
-			}
-		}
-		return err
+// regenerateAppArmorRulesImpl generates the profiles of the active
+// version of the snap again, e.g. after its hw-assign additions changed
+func regenerateAppArmorRulesImpl(snapname string) error {
+	part, ok := ActiveSnapByName(snapname).(*SnapPart)
+	if !ok {
+		// not active, the profiles are generated on activation
+		return nil
 	}
 
-	return nil
+	return addPackageAppArmorProfiles(part.basedir, false)
 }
 
 var regenerateAppArmorRules = regenerateAppArmorRulesImpl
@@ -132,7 +127,7 @@ func AddHWAccess(snapname, device string) error {
 	}
 
 	// re-generate apparmor fules
-	return regenerateAppArmorRules()
+	return regenerateAppArmorRules(snapname)
 }
 
 // ListHWAccess returns a list of hardware-device strings that the snap
@@ -177,5 +172,5 @@ func RemoveHWAccess(snapname, device string) error {
 	}
 
 	// re-generate apparmor fules
-	return regenerateAppArmorRules()
+	return regenerateAppArmorRules(snapname)
 }
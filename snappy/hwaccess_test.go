@@ -20,13 +20,14 @@ package snappy
 import (
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 
 	. "launchpad.net/gocheck"
 )
 
 func mockRegenerateAppArmorRules() *bool {
 	regenerateAppArmorRulesWasCalled := false
-	regenerateAppArmorRules = func() error {
+	regenerateAppArmorRules = func(snapname string) error {
 		regenerateAppArmorRulesWasCalled = true
 		return nil
 	}
@@ -61,7 +62,6 @@ func (s *SnapTestSuite) TestAddHWAccessInvalidDevice(c *C) {
 }
 
 func (s *SnapTestSuite) TestAddHWAccessMultiplePaths(c *C) {
-	aaClickHookCmd = "true"
 	makeInstalledMockSnap(s.tempdir, "")
 
 	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
@@ -82,7 +82,6 @@ func (s *SnapTestSuite) TestAddHWAccessMultiplePaths(c *C) {
 }
 
 func (s *SnapTestSuite) TestAddHWAccessAddSameDeviceTwice(c *C) {
-	aaClickHookCmd = "true"
 	makeInstalledMockSnap(s.tempdir, "")
 
 	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
@@ -104,11 +103,33 @@ func (s *SnapTestSuite) TestAddHWAccessUnknownPackage(c *C) {
 }
 
 func (s *SnapTestSuite) TestAddHWAccessHookFails(c *C) {
-	aaClickHookCmd = "false"
-	makeInstalledMockSnap(s.tempdir, "")
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+	runAppArmorParser = func(args ...string) error {
+		return &ErrHookFailed{cmd: "apparmor_parser", exitCode: 1}
+	}
 
-	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
-	c.Assert(err.Error(), Equals, "exit status 1")
+	err = AddHWAccess("hello-app", "/dev/ttyUSB0")
+	c.Assert(err, ErrorMatches, "hook command apparmor_parser failed with exit status 1")
+}
+
+func (s *SnapTestSuite) TestAddHWAccessRegeneratesProfiles(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+	var loaded []string
+	runAppArmorParser = func(args ...string) error {
+		loaded = append(loaded, filepath.Base(args[len(args)-1]))
+		return nil
+	}
+
+	c.Assert(AddHWAccess("hello-app", "/dev/ttyUSB0"), IsNil)
+	c.Assert(loaded, DeepEquals, []string{"hello-app_svc1_1.10", "hello-app_hello_1.10"})
+
+	content, err := ioutil.ReadFile(filepath.Join(snapAppArmorProfilesDir, "hello-app_hello_1.10"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(content), "\n  \"/dev/ttyUSB0\" rwk,\n"), Equals, true)
 }
 
 func (s *SnapTestSuite) TestListHWAccessNoAdditionalAccess(c *C) {
@@ -135,8 +156,6 @@ func (s *SnapTestSuite) TestRemoveHWAccessInvalidDevice(c *C) {
 }
 
 func (s *SnapTestSuite) TestRemoveHWAccess(c *C) {
-	aaClickHookCmd = "true"
-
 	makeInstalledMockSnap(s.tempdir, "")
 	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
 
@@ -155,7 +174,6 @@ func (s *SnapTestSuite) TestRemoveHWAccess(c *C) {
 }
 
 func (s *SnapTestSuite) TestRemoveHWAccessMultipleDevices(c *C) {
-	aaClickHookCmd = "true"
 	makeInstalledMockSnap(s.tempdir, "")
 
 	// setup
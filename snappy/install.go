@@ -22,6 +22,7 @@ import (
 	"os"
 	"strings"
 
+	"launchpad.net/snappy/clickdeb"
 	"launchpad.net/snappy/logger"
 )
 
@@ -34,6 +35,9 @@ const (
 	AllowUnauthenticated InstallFlags = 1 << iota
 	// InhibitHooks will ensure that the hooks are not run
 	InhibitHooks
+	// IgnoreArchitecture allows to install a snap that is not built
+	// for the architecture of the system
+	IgnoreArchitecture
 )
 
 // check if the image is in developer mode
@@ -59,7 +63,8 @@ func inDeveloperMode() bool {
 }
 
 // Install the givens snap names provided via args. This can be local
-// files or snaps that are queried from the store
+// files or snaps that are queried from the store. Snaps from the store
+// can be given as "name/channel" to install from a specific channel.
 func Install(name string, flags InstallFlags) (err error) {
 
 	// consume local parts
@@ -70,20 +75,54 @@ func Install(name string, flags InstallFlags) (err error) {
 			flags |= AllowUnauthenticated
 		}
 
+		frameworks, err := snapFileFrameworks(name)
+		if err != nil {
+			return err
+		}
+		if err := installMissingFrameworks(frameworks, flags); err != nil {
+			return err
+		}
+
 		pbar := NewTextProgress(name)
 		return installClick(name, flags, pbar)
 	}
 
 	// check repos next
+	if _, channel := splitChannel(name); channel != "" && !validChannel(channel) {
+		return ErrInvalidChannel
+	}
 	m := NewMetaRepository()
 	found, _ := m.Details(name)
-	for _, part := range found {
-		// act only on parts that are downloadable
-		if !part.IsInstalled() {
-			pbar := NewTextProgress(part.Name())
-			return logger.LogError(part.Install(pbar, flags))
-		}
+	// act only on parts that are downloadable
+	part := firstNotInstalled(found)
+	if part == nil {
+		return ErrPackageNotFound
+	}
+
+	frameworks, err := part.Frameworks()
+	if err != nil {
+		return err
+	}
+	if err := installMissingFrameworks(frameworks, flags); err != nil {
+		return logger.LogError(err)
+	}
+
+	pbar := NewTextProgress(part.Name())
+	return logger.LogError(part.Install(pbar, flags))
+}
+
+// snapFileFrameworks returns the frameworks needed by the given snap
+// file
+func snapFileFrameworks(snapFile string) ([]string, error) {
+	d := clickdeb.ClickDeb{Path: snapFile}
+	yamlData, err := d.MetaMember("package.yaml")
+	if err != nil {
+		return nil, err
+	}
+	m, err := parsePackageYamlData(yamlData)
+	if err != nil {
+		return nil, err
 	}
 
-	return ErrPackageNotFound
+	return parseFrameworks(m.Framework), nil
 }
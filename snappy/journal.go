@@ -0,0 +1,243 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"launchpad.net/snappy/helpers"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the steps of a install, each step is recorded in the journal before
+// it is started
+const (
+	journalStepUnpack     = "unpack"
+	journalStepDeactivate = "deactivate"
+	journalStepCopyData   = "copy-data"
+	journalStepActivate   = "activate"
+)
+
+// installJournal is the write-ahead journal of a install. If snappy
+// gets interrupted (e.g. by a power cut) during a install the journal
+// is used to finish or revert it.
+type installJournal struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+
+	// the directory of the new and of the previously active version
+	NewDir string `yaml:"new-dir"`
+	OldDir string `yaml:"old-dir,omitempty"`
+
+	// the data directories that are created by the install
+	NewDataDirs []string `yaml:"new-data-dirs,omitempty"`
+
+	// the services of the old version that are disabled, they stay
+	// disabled in whichever version ends up active
+	DisabledServices []string `yaml:"disabled-services,omitempty"`
+
+	InhibitHooks bool   `yaml:"inhibit-hooks,omitempty"`
+	Step         string `yaml:"step"`
+}
+
+// record writes the journal with the given step to disk, it only
+// returns once the journal is really on the disk
+func (j *installJournal) record(step string) error {
+	j.Step = step
+
+	content, err := yaml.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(snappyInstallJournal)
+	if err := helpers.EnsureDir(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp := snappyInstallJournal + ".new"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(content); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, snappyInstallJournal); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// done removes the journal, the install is finished
+func (j *installJournal) done() {
+	if err := os.Remove(snappyInstallJournal); err != nil {
+		log.Printf("WARNING: failed to remove %s: %s", snappyInstallJournal, err)
+		return
+	}
+	syncDir(filepath.Dir(snappyInstallJournal))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func readInstallJournal() (*installJournal, error) {
+	content, err := ioutil.ReadFile(snappyInstallJournal)
+	if err != nil {
+		return nil, err
+	}
+
+	var j installJournal
+	if err := yaml.Unmarshal(content, &j); err != nil {
+		return nil, err
+	}
+
+	return &j, nil
+}
+
+// newDataDirs returns the data directories of the new version that do
+// not exist yet and will be created by copying the data of the old
+// version (or as a new empty data dir if there is no old version)
+func newDataDirs(name, oldVersion, newVersion string) (dirs []string, err error) {
+	if oldVersion == "" {
+		dataDir := filepath.Join(snapDataDir, name, newVersion)
+		if !helpers.FileExists(dataDir) {
+			dirs = append(dirs, dataDir)
+		}
+		return dirs, nil
+	}
+
+	// see copySnapData
+	oldDataDirs, err := filepath.Glob(filepath.Join(snapDataHomeGlob, name, oldVersion))
+	if err != nil {
+		return nil, err
+	}
+	oldDataDirs = append(oldDataDirs, filepath.Join(snapDataDir, name, oldVersion))
+
+	for _, oldDir := range oldDataDirs {
+		newDir := filepath.Join(filepath.Dir(oldDir), newVersion)
+		if helpers.FileExists(oldDir) && !helpers.FileExists(newDir) {
+			dirs = append(dirs, newDir)
+		}
+	}
+
+	return dirs, nil
+}
+
+// forceActive makes the given snap dir active and regenerates all its
+// hooks, binaries and services even if it is already active, the given
+// services are not enabled
+func forceActive(baseDir string, inhibitHooks bool, disabledServices []string) error {
+	currentSymlink := filepath.Join(baseDir, "..", "current")
+	if _, err := os.Lstat(currentSymlink); err == nil {
+		if err := os.Remove(currentSymlink); err != nil {
+			return err
+		}
+	}
+
+	return activateClick(baseDir, inhibitHooks, disabledServices)
+}
+
+// rollForward finishes the install
+func (j *installJournal) rollForward() error {
+	return forceActive(j.NewDir, j.InhibitHooks, j.DisabledServices)
+}
+
+// rollBack reverts the install and makes the old version active again
+func (j *installJournal) rollBack() error {
+	for _, dir := range j.NewDataDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+
+	// reinstalling the same version, there is nothing to remove
+	if j.NewDir != j.OldDir {
+		currentSymlink := filepath.Join(j.NewDir, "..", "current")
+		if p, _ := filepath.EvalSymlinks(currentSymlink); p == j.NewDir || p == "" {
+			os.Remove(currentSymlink)
+		}
+		if err := os.RemoveAll(j.NewDir); err != nil {
+			return err
+		}
+	}
+
+	if j.OldDir == "" {
+		return nil
+	}
+
+	return forceActive(j.OldDir, j.InhibitHooks, j.DisabledServices)
+}
+
+// NeedsRecovery returns true if a install was interrupted and needs to
+// be recovered with Recover
+func NeedsRecovery() bool {
+	return helpers.FileExists(snappyInstallJournal)
+}
+
+// Recover finishes or reverts a install that was interrupted. It
+// returns a description of what was done or "" if nothing needed to
+// be recovered.
+func Recover() (string, error) {
+	if !NeedsRecovery() {
+		return "", nil
+	}
+
+	j, err := readInstallJournal()
+	if err != nil {
+		return "", err
+	}
+
+	var msg string
+	switch j.Step {
+	case journalStepActivate:
+		// everything is in place already
+		if err := j.rollForward(); err != nil {
+			return "", err
+		}
+		msg = fmt.Sprintf("Finished the install of %s %s", j.Name, j.Version)
+	case journalStepUnpack, journalStepDeactivate, journalStepCopyData:
+		if err := j.rollBack(); err != nil {
+			return "", err
+		}
+		msg = fmt.Sprintf("Reverted the install of %s %s", j.Name, j.Version)
+	default:
+		return "", fmt.Errorf("unknown step %q in %s", j.Step, snappyInstallJournal)
+	}
+
+	j.done()
+
+	return msg, nil
+}
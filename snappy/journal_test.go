@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"os"
+	"path/filepath"
+
+	"launchpad.net/snappy/helpers"
+
+	. "launchpad.net/gocheck"
+)
+
+func (s *SnapTestSuite) TestRecoverNothingToDo(c *C) {
+	c.Assert(NeedsRecovery(), Equals, false)
+
+	msg, err := Recover()
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "")
+}
+
+func (s *SnapTestSuite) TestInstallClickRemovesJournal(c *C) {
+	snapFile := makeTestSnapPackage(c, "")
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	c.Assert(NeedsRecovery(), Equals, false)
+}
+
+func (s *SnapTestSuite) TestInstallJournalRecord(c *C) {
+	j := &installJournal{Name: "foo", Version: "1.0", NewDir: "/apps/foo/1.0"}
+	c.Assert(j.record(journalStepUnpack), IsNil)
+	c.Assert(NeedsRecovery(), Equals, true)
+
+	j2, err := readInstallJournal()
+	c.Assert(err, IsNil)
+	c.Assert(j2, DeepEquals, j)
+	c.Assert(j2.Step, Equals, journalStepUnpack)
+
+	j.done()
+	c.Assert(NeedsRecovery(), Equals, false)
+}
+
+func (s *SnapTestSuite) TestRecoverRollBack(c *C) {
+	makeTwoTestSnaps(c, SnapTypeApp)
+	oldDir := filepath.Join(snapAppsDir, "foo", "1.0")
+	newDir := filepath.Join(snapAppsDir, "foo", "2.0")
+	newDataDir := filepath.Join(snapDataDir, "foo", "2.0")
+
+	// simulate a power cut while the data was copied
+	c.Assert(os.Remove(filepath.Join(snapAppsDir, "foo", "current")), IsNil)
+	j := &installJournal{
+		Name:        "foo",
+		Version:     "2.0",
+		NewDir:      newDir,
+		OldDir:      oldDir,
+		NewDataDirs: []string{newDataDir},
+	}
+	c.Assert(j.record(journalStepCopyData), IsNil)
+
+	msg, err := Recover()
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "Reverted the install of foo 2.0")
+	c.Assert(NeedsRecovery(), Equals, false)
+
+	c.Assert(helpers.FileExists(newDir), Equals, false)
+	c.Assert(helpers.FileExists(newDataDir), Equals, false)
+	c.Assert(ActiveSnapByName("foo").Version(), Equals, "1.0")
+}
+
+func (s *SnapTestSuite) TestRecoverRollBackFirstInstall(c *C) {
+	snapFile := makeTestSnapPackage(c, "name: foo\nversion: 1.0\nvendor: Foo Bar <foo@example.com>\n")
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+	newDir := filepath.Join(snapAppsDir, "foo", "1.0")
+
+	// simulate a power cut while unpacking
+	j := &installJournal{Name: "foo", Version: "1.0", NewDir: newDir}
+	c.Assert(j.record(journalStepUnpack), IsNil)
+
+	msg, err := Recover()
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "Reverted the install of foo 1.0")
+
+	c.Assert(helpers.FileExists(newDir), Equals, false)
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "current")), Equals, false)
+	c.Assert(ActiveSnapByName("foo"), IsNil)
+}
+
+func (s *SnapTestSuite) TestRecoverRollForward(c *C) {
+	makeTwoTestSnaps(c, SnapTypeApp)
+	oldDir := filepath.Join(snapAppsDir, "foo", "1.0")
+	newDir := filepath.Join(snapAppsDir, "foo", "2.0")
+
+	// simulate a power cut while making the new version active
+	c.Assert(os.Remove(filepath.Join(snapAppsDir, "foo", "current")), IsNil)
+	j := &installJournal{Name: "foo", Version: "2.0", NewDir: newDir, OldDir: oldDir}
+	c.Assert(j.record(journalStepActivate), IsNil)
+
+	msg, err := Recover()
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "Finished the install of foo 2.0")
+	c.Assert(NeedsRecovery(), Equals, false)
+
+	c.Assert(ActiveSnapByName("foo").Version(), Equals, "2.0")
+}
+
+func (s *SnapTestSuite) TestRecoverUnknownStep(c *C) {
+	j := &installJournal{Name: "foo", Version: "1.0"}
+	c.Assert(j.record("dance"), IsNil)
+
+	_, err := Recover()
+	c.Assert(err, ErrorMatches, `unknown step "dance" in .*`)
+	c.Assert(NeedsRecovery(), Equals, true)
+}
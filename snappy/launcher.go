@@ -0,0 +1,133 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"launchpad.net/snappy/helpers"
+)
+
+// launcherCmd is the command the binaries and services of snaps are
+// started with, see RunConfined
+var launcherCmd = "/usr/bin/snappy"
+
+// launcherArgs returns the arguments of the launcher that run cmd
+// confined as the given app
+func launcherArgs(appID string, cmd ...string) []string {
+	return append([]string{"internal-launch", appID, "--"}, cmd...)
+}
+
+// launcherCommand returns the command line that runs cmd confined as
+// the given app
+func launcherCommand(appID, cmd string) string {
+	return launcherCmd + " " + strings.Join(launcherArgs(appID, cmd), " ")
+}
+
+// the apparmor securityfs, it only exists if apparmor is enabled
+var appArmorSecurityFS = "/sys/kernel/security/apparmor"
+
+// currentAppArmorProfile returns the profile of the current thread, e.g.
+// the one systemd set for a service with AppArmorProfile=
+func currentAppArmorProfile() (string, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/self/task/%d/attr/current", syscall.Gettid()))
+	if err != nil {
+		return "", err
+	}
+
+	// the mode is appended, e.g. "foo_bar_1.0 (enforce)"
+	label := strings.TrimSpace(string(content))
+	if i := strings.LastIndex(label, " ("); i >= 0 {
+		label = label[:i]
+	}
+
+	return label, nil
+}
+
+// changeAppArmorProfileOnExec makes the kernel switch to the given
+// profile on the next exec of the current thread, like aa-exec does
+func changeAppArmorProfileOnExec(profile string) error {
+	// never run apps unconfined
+	if !helpers.FileExists(appArmorSecurityFS) {
+		return fmt.Errorf("apparmor is not enabled, refusing to run %s unconfined", profile)
+	}
+
+	// nothing to change if systemd confined the launcher already
+	if current, err := currentAppArmorProfile(); err == nil && current == profile {
+		return nil
+	}
+
+	attrFile := fmt.Sprintf("/proc/self/task/%d/attr/exec", syscall.Gettid())
+	f, err := os.OpenFile(attrFile, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte("exec " + profile)); err != nil {
+		return fmt.Errorf("can not change to apparmor profile %q: %s", profile, err)
+	}
+
+	return nil
+}
+
+// RunConfined execs the given command under the apparmor profile and
+// seccomp filter of the app with the given APP_ID, it only returns if
+// that fails
+func RunConfined(appID string, args []string) error {
+	if appID == "" || filepath.Base(appID) != appID {
+		return fmt.Errorf("invalid app id %q", appID)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no command to run for %s", appID)
+	}
+	if !seccompSupported() {
+		return fmt.Errorf("seccomp is not supported on %s, refusing to run %s unconfined", runtime.GOARCH, appID)
+	}
+
+	filter, err := readSeccompFilter(seccompFilterFile(appID))
+	if err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	// the profile change and the filter only apply to the current
+	// thread, it is the one that needs to exec
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := changeAppArmorProfileOnExec(appID); err != nil {
+		return err
+	}
+	if err := loadSeccompFilter(filter); err != nil {
+		return err
+	}
+
+	return syscall.Exec(path, args, os.Environ())
+}
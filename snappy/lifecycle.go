@@ -0,0 +1,74 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"os/exec"
+	"path/filepath"
+
+	"launchpad.net/snappy/helpers"
+)
+
+// the lifecycle hooks a snap can ship in meta/hooks
+const (
+	// runs in the new snap after it was installed for the first time
+	hookInstall = "install"
+	// runs in the old version before it gets replaced by a new one
+	hookPreRefresh = "pre-refresh"
+	// runs in the new version after it replaced a old one
+	hookPostRefresh = "post-refresh"
+	// runs in the active version before the snap is removed
+	hookRemove = "remove"
+)
+
+// snapHooks are all the hooks in meta/hooks that run confined
+var snapHooks = []string{"config", hookInstall, hookPreRefresh, hookPostRefresh, hookRemove}
+
+// snapHookAppArmorName returns the name that is used in the click
+// manifest for the apparmor profile of the given hook
+func snapHookAppArmorName(hookName string) string {
+	return "snappy-" + hookName
+}
+
+// runSnapHook runs the given meta/hooks script of the snap in snapDir
+// if the snap has one
+func runSnapHook(snapDir, hookName string) error {
+	hookScript := filepath.Join(snapDir, "meta", "hooks", hookName)
+	if !helpers.FileExists(hookScript) {
+		return nil
+	}
+
+	part := NewInstalledSnapPart(filepath.Join(snapDir, "meta", "package.yaml"))
+	if part == nil {
+		return ErrPackageNotFound
+	}
+
+	appID := snapAppID(part.m, snapHookAppArmorName(hookName))
+
+	cmd := exec.Command(hookLauncher, launcherArgs(appID, hookScript)...)
+	cmd.Env = makeSnapHookEnv(part)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return &ErrSnapHookFailed{
+			snap:   part.Name(),
+			hook:   hookName,
+			output: string(output),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"launchpad.net/snappy/helpers"
+
+	. "launchpad.net/gocheck"
+)
+
+// makeTestSnapWithHooks builds a "foo" snap with the given version and
+// meta/hooks, the hooks log to hookLog
+func makeTestSnapWithHooks(c *C, version, hookLog string, failingHooks ...string) string {
+	sourceDir := c.MkDir()
+	hooksDir := filepath.Join(sourceDir, "meta", "hooks")
+	c.Assert(os.MkdirAll(hooksDir, 0755), IsNil)
+
+	packageYaml := fmt.Sprintf("name: foo\nversion: %s\nvendor: Foo Bar <foo@example.com>\n", version)
+	c.Assert(ioutil.WriteFile(filepath.Join(sourceDir, "meta", "package.yaml"), []byte(packageYaml), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(sourceDir, "meta", "readme.md"), []byte("Random\nExample"), 0644), IsNil)
+
+	for _, hook := range []string{hookInstall, hookPreRefresh, hookPostRefresh, hookRemove} {
+		script := fmt.Sprintf("#!/bin/sh\necho \"$SNAP_NAME $SNAP_VERSION %s\" >> %s\n", hook, hookLog)
+		for _, failing := range failingHooks {
+			if failing == hook {
+				script += "exit 1\n"
+			}
+		}
+		c.Assert(ioutil.WriteFile(filepath.Join(hooksDir, hook), []byte(script), 0755), IsNil)
+	}
+
+	var snapFile string
+	err := helpers.ChDir(sourceDir, func() {
+		var err error
+		snapFile, err = Build(sourceDir, "")
+		c.Assert(err, IsNil)
+	})
+	c.Assert(err, IsNil)
+
+	return filepath.Join(sourceDir, snapFile)
+}
+
+func readHookLog(c *C, hookLog string) string {
+	content, err := ioutil.ReadFile(hookLog)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	c.Assert(err, IsNil)
+
+	return string(content)
+}
+
+func (s *SnapTestSuite) TestLifecycleHooksInstallAndRefresh(c *C) {
+	hookLog := filepath.Join(s.tempdir, "hook.log")
+
+	c.Assert(installClick(makeTestSnapWithHooks(c, "1.0", hookLog), 0, nil), IsNil)
+	c.Assert(readHookLog(c, hookLog), Equals, "foo 1.0 install\n")
+
+	c.Assert(installClick(makeTestSnapWithHooks(c, "2.0", hookLog), 0, nil), IsNil)
+	c.Assert(readHookLog(c, hookLog), Equals, "foo 1.0 install\nfoo 1.0 pre-refresh\nfoo 2.0 post-refresh\n")
+}
+
+func (s *SnapTestSuite) TestLifecycleHooksInhibited(c *C) {
+	hookLog := filepath.Join(s.tempdir, "hook.log")
+
+	c.Assert(installClick(makeTestSnapWithHooks(c, "1.0", hookLog), InhibitHooks, nil), IsNil)
+	c.Assert(readHookLog(c, hookLog), Equals, "")
+}
+
+func (s *SnapTestSuite) TestLifecycleHooksInstallFails(c *C) {
+	hookLog := filepath.Join(s.tempdir, "hook.log")
+
+	err := installClick(makeTestSnapWithHooks(c, "1.0", hookLog, hookInstall), 0, nil)
+	c.Assert(err, FitsTypeOf, &ErrSnapHookFailed{})
+
+	// the install was reverted
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "1.0")), Equals, false)
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "current")), Equals, false)
+	c.Assert(helpers.FileExists(filepath.Join(snapDataDir, "foo", "1.0")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestLifecycleHooksPreRefreshFails(c *C) {
+	hookLog := filepath.Join(s.tempdir, "hook.log")
+
+	c.Assert(installClick(makeTestSnapWithHooks(c, "1.0", hookLog, hookPreRefresh), 0, nil), IsNil)
+	err := installClick(makeTestSnapWithHooks(c, "2.0", hookLog), 0, nil)
+	c.Assert(err, FitsTypeOf, &ErrSnapHookFailed{})
+	c.Assert(err, ErrorMatches, `pre-refresh hook of foo failed: .*`)
+
+	// the old version is still active, the new one is gone
+	p, err := filepath.EvalSymlinks(filepath.Join(snapAppsDir, "foo", "current"))
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, filepath.Join(snapAppsDir, "foo", "1.0"))
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "2.0")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestLifecycleHooksPostRefreshFails(c *C) {
+	hookLog := filepath.Join(s.tempdir, "hook.log")
+
+	c.Assert(installClick(makeTestSnapWithHooks(c, "1.0", hookLog), 0, nil), IsNil)
+	err := installClick(makeTestSnapWithHooks(c, "2.0", hookLog, hookPostRefresh), 0, nil)
+	c.Assert(err, FitsTypeOf, &ErrSnapHookFailed{})
+
+	// the old version is active again
+	p, err := filepath.EvalSymlinks(filepath.Join(snapAppsDir, "foo", "current"))
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, filepath.Join(snapAppsDir, "foo", "1.0"))
+	c.Assert(helpers.FileExists(filepath.Join(snapAppsDir, "foo", "2.0")), Equals, false)
+	c.Assert(helpers.FileExists(filepath.Join(snapDataDir, "foo", "2.0")), Equals, false)
+	c.Assert(helpers.FileExists(filepath.Join(snapDataDir, "foo", "1.0")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestLifecycleHooksRemove(c *C) {
+	hookLog := filepath.Join(s.tempdir, "hook.log")
+
+	c.Assert(installClick(makeTestSnapWithHooks(c, "1.0", hookLog), 0, nil), IsNil)
+	c.Assert(removeClick(filepath.Join(snapAppsDir, "foo", "1.0")), IsNil)
+	c.Assert(readHookLog(c, hookLog), Equals, "foo 1.0 install\nfoo 1.0 remove\n")
+}
+
+func (s *SnapTestSuite) TestLifecycleHooksRemoveFails(c *C) {
+	hookLog := filepath.Join(s.tempdir, "hook.log")
+
+	c.Assert(installClick(makeTestSnapWithHooks(c, "1.0", hookLog, hookRemove), 0, nil), IsNil)
+	err := removeClick(filepath.Join(snapAppsDir, "foo", "1.0"))
+	c.Assert(err, FitsTypeOf, &ErrSnapHookFailed{})
+
+	// still installed and active
+	p, err := filepath.EvalSymlinks(filepath.Join(snapAppsDir, "foo", "current"))
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, filepath.Join(snapAppsDir, "foo", "1.0"))
+}
+
+func (s *SnapTestSuite) TestBuildSnapHooksAppArmor(c *C) {
+	sourceDir := makeExampleSnapSourceDir(c, "name: hello\nversion: 1.0\nvendor: Foo <foo@example.com>\n")
+	hooksDir := filepath.Join(sourceDir, "meta", "hooks")
+	c.Assert(os.MkdirAll(hooksDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooksDir, hookInstall), nil, 0755), IsNil)
+
+	m, err := parsePackageYamlFile(filepath.Join(sourceDir, "meta", "package.yaml"))
+	c.Assert(err, IsNil)
+	m.Integration = make(map[string]clickAppHook)
+	c.Assert(handleSnapHooksApparmor(sourceDir, m), IsNil)
+
+	c.Assert(m.Integration["snappy-install"]["apparmor"], Equals, "meta/snappy-install.apparmor")
+	_, ok := m.Integration["snappy-remove"]
+	c.Assert(ok, Equals, false)
+	c.Assert(helpers.FileExists(filepath.Join(sourceDir, "meta", "snappy-install.apparmor")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestLifecycleHooksRunConfined(c *C) {
+	hookLog := filepath.Join(s.tempdir, "hook.log")
+	launcherLog := filepath.Join(s.tempdir, "launcher.log")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$2\" >> %s\nshift 3\nexec \"$@\"\n", launcherLog)
+	c.Assert(ioutil.WriteFile(hookLauncher, []byte(script), 0755), IsNil)
+
+	c.Assert(installClick(makeTestSnapWithHooks(c, "1.0", hookLog), 0, nil), IsNil)
+	c.Assert(readHookLog(c, launcherLog), Equals, "foo_snappy-install_1.0\n")
+	// the hook has a seccomp filter to run with
+	c.Assert(helpers.FileExists(seccompFilterFile("foo_snappy-install_1.0")), Equals, true)
+}
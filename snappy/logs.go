@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// journalReader is the output of a running journalctl, closing it
+// waits for journalctl to finish
+type journalReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *journalReader) Close() error {
+	r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
+
+// runJournalctl runs journalctl with the given arguments and returns
+// its output
+var runJournalctl = runJournalctlImpl
+
+func runJournalctlImpl(args ...string) (io.ReadCloser, error) {
+	cmd := exec.Command("journalctl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &journalReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// journalArgs returns the journalctl arguments for the logs of the
+// given units, lines < 0 means all lines
+func journalArgs(units []string, lines int, follow bool) []string {
+	args := []string{"-o", "json", "--no-pager"}
+	if lines >= 0 {
+		args = append(args, "-n", strconv.Itoa(lines))
+	}
+	if follow {
+		args = append(args, "-f")
+	}
+	for _, unit := range units {
+		args = append(args, "-u", unit)
+	}
+
+	return args
+}
+
+// journalMessage returns the MESSAGE field of a journal entry, journalctl
+// exports it as a list of bytes if it is not valid utf-8
+func journalMessage(field interface{}) string {
+	switch msg := field.(type) {
+	case string:
+		return msg
+	case []interface{}:
+		buf := make([]byte, 0, len(msg))
+		for _, b := range msg {
+			if f, ok := b.(float64); ok {
+				buf = append(buf, byte(f))
+			}
+		}
+		return string(buf)
+	}
+
+	return ""
+}
+
+// formatJournalEntry formats a journal entry of the given services
+// (by unit) as a line of the log
+func formatJournalEntry(entry map[string]interface{}, services map[string]string) string {
+	var t time.Time
+	if s, ok := entry["__REALTIME_TIMESTAMP"].(string); ok {
+		if usec, err := strconv.ParseInt(s, 10, 64); err == nil {
+			t = time.Unix(0, usec*int64(time.Microsecond))
+		}
+	}
+
+	name, _ := entry["_SYSTEMD_UNIT"].(string)
+	if service, ok := services[name]; ok {
+		name = service
+	}
+
+	return fmt.Sprintf("%s %s: %s\n", t.UTC().Format(time.RFC3339), name, journalMessage(entry["MESSAGE"]))
+}
+
+// ServiceLogs writes the last lines of the journal of the given services
+// to w, all lines if lines < 0. With follow new lines are written until
+// journalctl is stopped.
+func ServiceLogs(services []*SnapService, lines int, follow bool, w io.Writer) error {
+	names := make(map[string]string, len(services))
+	units := make([]string, len(services))
+	for i, service := range services {
+		units[i] = service.Unit()
+		names[units[i]] = service.FullName()
+	}
+
+	r, err := runJournalctl(journalArgs(units, lines, follow)...)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(r)
+	for {
+		var entry map[string]interface{}
+		if err := dec.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			r.Close()
+			return err
+		}
+
+		if _, err := io.WriteString(w, formatJournalEntry(entry, names)); err != nil {
+			r.Close()
+			return err
+		}
+	}
+
+	return r.Close()
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+const journalOutput = `{"__REALTIME_TIMESTAMP":"1431424800000000","_SYSTEMD_UNIT":"foo_svc1_1.0.service","MESSAGE":"hello"}
+{"__REALTIME_TIMESTAMP":"1431424801500000","_SYSTEMD_UNIT":"foo_svc2_1.0.service","MESSAGE":[119,111,114,108,100,255]}
+{"__REALTIME_TIMESTAMP":"1431424802000000","_SYSTEMD_UNIT":"other.service","MESSAGE":"unrelated"}
+`
+
+func (s *SnapTestSuite) TestJournalArgs(c *C) {
+	units := []string{"foo_svc1_1.0.service", "foo_svc2_1.0.service"}
+
+	c.Assert(journalArgs(units, 10, false), DeepEquals, []string{
+		"-o", "json", "--no-pager", "-n", "10",
+		"-u", "foo_svc1_1.0.service", "-u", "foo_svc2_1.0.service"})
+	c.Assert(journalArgs(units[:1], -1, true), DeepEquals, []string{
+		"-o", "json", "--no-pager", "-f", "-u", "foo_svc1_1.0.service"})
+}
+
+func (s *SnapTestSuite) TestServiceLogs(c *C) {
+	s.installServiceSnaps(c)
+
+	var journalctlArgs []string
+	runJournalctl = func(args ...string) (io.ReadCloser, error) {
+		journalctlArgs = args
+		return ioutil.NopCloser(strings.NewReader(journalOutput)), nil
+	}
+	defer func() { runJournalctl = runJournalctlImpl }()
+
+	services, err := FindServices("foo")
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(ServiceLogs(services, 5, true, &buf), IsNil)
+	c.Assert(journalctlArgs, DeepEquals, []string{
+		"-o", "json", "--no-pager", "-n", "5", "-f",
+		"-u", "foo_svc1_1.0.service", "-u", "foo_svc2_1.0.service"})
+	c.Assert(buf.String(), Equals, ""+
+		"2015-05-12T10:00:00Z foo.svc1: hello\n"+
+		"2015-05-12T10:00:01Z foo.svc2: world\xff\n"+
+		"2015-05-12T10:00:02Z other.service: unrelated\n")
+}
+
+func (s *SnapTestSuite) TestServiceLogsBadJournal(c *C) {
+	runJournalctl = func(args ...string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("{garbage")), nil
+	}
+	defer func() { runJournalctl = runJournalctlImpl }()
+
+	var buf bytes.Buffer
+	c.Assert(ServiceLogs(nil, 10, false, &buf), NotNil)
+}
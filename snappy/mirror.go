@@ -0,0 +1,393 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"launchpad.net/snappy/clickdeb"
+	"launchpad.net/snappy/helpers"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the index file at the top of a mirror directory
+const mirrorIndexFile = "index.yaml"
+
+// mirrorSnap is a single snap entry in the mirror index
+type mirrorSnap struct {
+	Name          string   `yaml:"name"`
+	Version       string   `yaml:"version"`
+	Type          SnapType `yaml:"type,omitempty"`
+	Vendor        string   `yaml:"vendor,omitempty"`
+	Title         string   `yaml:"title,omitempty"`
+	Architectures []string `yaml:"architectures,omitempty"`
+	Framework     string   `yaml:"framework,omitempty"`
+
+	// relative to the mirror directory
+	File           string `yaml:"file"`
+	Size           int64  `yaml:"size"`
+	DownloadSha512 string `yaml:"download-sha512"`
+	ArchiveSha512  string `yaml:"archive-sha512,omitempty"`
+}
+
+// the mirror index file
+type mirrorIndex struct {
+	Snaps []mirrorSnap `yaml:"snaps"`
+}
+
+func readMirrorIndex(dir string) (*mirrorIndex, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, mirrorIndexFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var index mirrorIndex
+	if err := yaml.Unmarshal(content, &index); err != nil {
+		return nil, err
+	}
+
+	return &index, nil
+}
+
+// mirrorSnapFromFile reads the package.yaml and the hashes.yaml of the
+// given snap file and returns the index entry for it
+func mirrorSnapFromFile(dir, snapFile string) (*mirrorSnap, error) {
+	d := clickdeb.ClickDeb{Path: snapFile}
+
+	yamlData, err := d.MetaMember("package.yaml")
+	if err != nil {
+		return nil, err
+	}
+	m, err := parsePackageYamlData(yamlData)
+	if err != nil {
+		return nil, err
+	}
+
+	hashesData, err := d.ControlMember("hashes.yaml")
+	if err != nil {
+		return nil, err
+	}
+	var h hashesYaml
+	if err := yaml.Unmarshal(hashesData, &h); err != nil {
+		return nil, err
+	}
+
+	// the title is only available in the click manifest
+	manifestData, err := d.ControlMember("manifest")
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readClickManifest(manifestData)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := os.Stat(snapFile)
+	if err != nil {
+		return nil, err
+	}
+	sha512, err := helpers.Sha512sum(snapFile)
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := filepath.Rel(dir, snapFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mirrorSnap{
+		Name:           m.Name,
+		Version:        m.Version,
+		Type:           m.Type,
+		Vendor:         m.Vendor,
+		Title:          manifest.Title,
+		Architectures:  m.Architectures,
+		Framework:      m.Framework,
+		File:           rel,
+		Size:           st.Size(),
+		DownloadSha512: sha512,
+		ArchiveSha512:  h.ArchiveSha512,
+	}, nil
+}
+
+// BuildMirrorIndex scans the given directory for snap files and writes
+// the index that is needed to use the directory as a mirror
+func BuildMirrorIndex(dir string) error {
+	var index mirrorIndex
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".snap") {
+			return nil
+		}
+
+		snap, err := mirrorSnapFromFile(dir, path)
+		if err != nil {
+			return fmt.Errorf("can not index %s: %s", path, err)
+		}
+		index.Snaps = append(index.Snaps, *snap)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	content, err := yaml.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(filepath.Join(dir, mirrorIndexFile), content, 0644)
+}
+
+// MirrorSnapPart represents a snap available in a mirror directory
+type MirrorSnapPart struct {
+	snap mirrorSnap
+	dir  string
+}
+
+// Type returns the type of the MirrorSnapPart (app, oem, ...)
+func (s *MirrorSnapPart) Type() SnapType {
+	if s.snap.Type != "" {
+		return s.snap.Type
+	}
+
+	// if not declared its a app
+	return SnapTypeApp
+}
+
+// Name returns the name
+func (s *MirrorSnapPart) Name() string {
+	return s.snap.Name
+}
+
+// Version returns the version
+func (s *MirrorSnapPart) Version() string {
+	return s.snap.Version
+}
+
+// Description returns the description
+func (s *MirrorSnapPart) Description() string {
+	return s.snap.Title
+}
+
+// Hash returns the hash
+func (s *MirrorSnapPart) Hash() string {
+	return s.snap.ArchiveSha512
+}
+
+// Channel returns the channel used, mirrors have no channels
+func (s *MirrorSnapPart) Channel() string {
+	return ""
+}
+
+// Frameworks returns the frameworks needed by the snap
+func (s *MirrorSnapPart) Frameworks() ([]string, error) {
+	return parseFrameworks(s.snap.Framework), nil
+}
+
+// Icon returns the icon
+func (s *MirrorSnapPart) Icon() string {
+	return ""
+}
+
+// IsActive returns true if the snap is active
+func (s *MirrorSnapPart) IsActive() bool {
+	return false
+}
+
+// IsInstalled returns true if the snap is installed
+func (s *MirrorSnapPart) IsInstalled() bool {
+	return false
+}
+
+// InstalledSize returns the size of the installed snap
+func (s *MirrorSnapPart) InstalledSize() int64 {
+	return -1
+}
+
+// DownloadSize returns the size of the snap file
+func (s *MirrorSnapPart) DownloadSize() int64 {
+	return s.snap.Size
+}
+
+// Date returns the modification time of the snap file
+func (s *MirrorSnapPart) Date() time.Time {
+	st, err := os.Stat(s.snapFile())
+	if err != nil {
+		return time.Time{}
+	}
+
+	return st.ModTime()
+}
+
+func (s *MirrorSnapPart) snapFile() string {
+	return filepath.Join(s.dir, s.snap.File)
+}
+
+// Install installs the snap from the mirror
+func (s *MirrorSnapPart) Install(pbar ProgressMeter, flags InstallFlags) error {
+	snapFile := s.snapFile()
+
+	sha512, err := helpers.Sha512sum(snapFile)
+	if err != nil {
+		return err
+	}
+	if sha512 != s.snap.DownloadSha512 {
+		return &ErrHashMismatch{
+			file:     snapFile,
+			expected: s.snap.DownloadSha512,
+			got:      sha512,
+		}
+	}
+
+	return installClick(snapFile, flags, pbar)
+}
+
+// SetActive sets the snap active
+func (s *MirrorSnapPart) SetActive() error {
+	return ErrNotInstalled
+}
+
+// Uninstall remove the snap from the system
+func (s *MirrorSnapPart) Uninstall() error {
+	return ErrNotInstalled
+}
+
+// Config is used to to configure the snap
+func (s *MirrorSnapPart) Config(configuration []byte) (new string, err error) {
+	return "", err
+}
+
+// NeedsReboot returns true if the snap becomes active on the next reboot
+func (s *MirrorSnapPart) NeedsReboot() bool {
+	return false
+}
+
+// SnapMirrorRepository is a repository backed by a directory of snap
+// files, e.g. a mounted USB stick
+type SnapMirrorRepository struct {
+	path string
+}
+
+// NewMirrorSnapRepository returns a new SnapMirrorRepository for the
+// given path or nil if the path contains no mirror index
+func NewMirrorSnapRepository(path string) *SnapMirrorRepository {
+	if !helpers.FileExists(filepath.Join(path, mirrorIndexFile)) {
+		return nil
+	}
+
+	return &SnapMirrorRepository{path: path}
+}
+
+// Description describes the mirror repository
+func (s *SnapMirrorRepository) Description() string {
+	return fmt.Sprintf("Snap mirror repository for %s", s.path)
+}
+
+// parts returns the parts in the mirror for which the filter returns
+// true, newest version first
+func (s *SnapMirrorRepository) parts(filter func(snap *mirrorSnap) bool) (parts []Part, err error) {
+	index, err := readMirrorIndex(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range index.Snaps {
+		if filter(&index.Snaps[i]) {
+			parts = append(parts, &MirrorSnapPart{snap: index.Snaps[i], dir: s.path})
+		}
+	}
+	sort.Sort(sort.Reverse(BySnapVersion(parts)))
+
+	return parts, nil
+}
+
+// Search searches the mirror for the given terms
+func (s *SnapMirrorRepository) Search(terms string) (parts []Part, err error) {
+	// mirrors have no channels
+	terms, _ = splitChannel(terms)
+
+	return s.parts(func(snap *mirrorSnap) bool {
+		return strings.Contains(snap.Name, terms) || strings.Contains(snap.Title, terms)
+	})
+}
+
+// Details returns details for the given snap
+func (s *SnapMirrorRepository) Details(name string) (parts []Part, err error) {
+	name, _ = splitChannel(name)
+
+	parts, err = s.parts(func(snap *mirrorSnap) bool {
+		return snap.Name == name
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, ErrPackageNotFound
+	}
+
+	return parts, nil
+}
+
+// Updates returns the snaps in the mirror that are newer than the
+// installed ones
+func (s *SnapMirrorRepository) Updates() (parts []Part, err error) {
+	installed, err := InstalledSnapNamesByType(SnapTypeApp, SnapTypeFramework, SnapTypeOem)
+	if err != nil || len(installed) == 0 {
+		return nil, err
+	}
+
+	for _, name := range installed {
+		current := ActiveSnapByName(name)
+		if current == nil {
+			continue
+		}
+
+		available, err := s.Details(name)
+		if err == ErrPackageNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// newest first
+		if VersionCompare(available[0].Version(), current.Version()) > 0 {
+			parts = append(parts, available[0])
+		}
+	}
+
+	return parts, nil
+}
+
+// Installed returns the installed snaps from this repository
+func (s *SnapMirrorRepository) Installed() (parts []Part, err error) {
+	return nil, err
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+)
+
+// makeTestMirror creates a mirror directory with a "foo" snap in
+// version 1.0 and 2.0 and a "bar" snap in version 1.0
+func makeTestMirror(c *C) string {
+	mirrorDir := c.MkDir()
+
+	for _, yaml := range []string{
+		"name: foo\nversion: 1.0\nvendor: Foo Bar <foo@example.com>\n",
+		"name: foo\nversion: 2.0\nvendor: Foo Bar <foo@example.com>\n",
+		"name: bar\nversion: 1.0\nvendor: Foo Bar <foo@example.com>\n",
+	} {
+		snapFile := makeTestSnapPackage(c, yaml)
+		err := os.Rename(snapFile, filepath.Join(mirrorDir, filepath.Base(snapFile)))
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(BuildMirrorIndex(mirrorDir), IsNil)
+
+	return mirrorDir
+}
+
+func (s *SnapTestSuite) TestMirrorRepositoryNoIndex(c *C) {
+	c.Assert(NewMirrorSnapRepository(c.MkDir()), IsNil)
+}
+
+func (s *SnapTestSuite) TestBuildMirrorIndex(c *C) {
+	mirrorDir := makeTestMirror(c)
+
+	index, err := readMirrorIndex(mirrorDir)
+	c.Assert(err, IsNil)
+	c.Assert(index.Snaps, HasLen, 3)
+	for _, snap := range index.Snaps {
+		c.Assert(snap.File, Matches, snap.Name+"_"+snap.Version+"_all.snap")
+		c.Assert(snap.Title, Equals, "Random")
+		c.Assert(snap.DownloadSha512, HasLen, 128)
+		c.Assert(snap.ArchiveSha512, HasLen, 128)
+		c.Assert(snap.Size > 0, Equals, true)
+	}
+}
+
+func (s *SnapTestSuite) TestMirrorRepositoryDetails(c *C) {
+	repo := NewMirrorSnapRepository(makeTestMirror(c))
+	c.Assert(repo, NotNil)
+
+	parts, err := repo.Details("foo")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 2)
+	// newest first
+	c.Assert(parts[0].Version(), Equals, "2.0")
+	c.Assert(parts[1].Version(), Equals, "1.0")
+	c.Assert(parts[0].IsInstalled(), Equals, false)
+	c.Assert(parts[0].Description(), Equals, "Random")
+
+	_, err = repo.Details("no-such-snap")
+	c.Assert(err, Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestMirrorRepositorySearch(c *C) {
+	repo := NewMirrorSnapRepository(makeTestMirror(c))
+	c.Assert(repo, NotNil)
+
+	parts, err := repo.Search("ba")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	c.Assert(parts[0].Name(), Equals, "bar")
+}
+
+func (s *SnapTestSuite) TestMirrorRepositoryInstallAndUpdates(c *C) {
+	repo := NewMirrorSnapRepository(makeTestMirror(c))
+	c.Assert(repo, NotNil)
+
+	parts, err := repo.Details("foo")
+	c.Assert(err, IsNil)
+	// install the old version
+	c.Assert(parts[1].Install(nil, 0), IsNil)
+	c.Assert(ActiveSnapByName("foo").Version(), Equals, "1.0")
+
+	mockInstalledSnapNamesByType([]string{"foo"})
+	updates, err := repo.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(updates, HasLen, 1)
+	c.Assert(updates[0].Version(), Equals, "2.0")
+
+	c.Assert(updates[0].Install(nil, 0), IsNil)
+	c.Assert(ActiveSnapByName("foo").Version(), Equals, "2.0")
+
+	updates, err = repo.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(updates, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestMirrorRepositoryInstallHashMismatch(c *C) {
+	mirrorDir := makeTestMirror(c)
+	repo := NewMirrorSnapRepository(mirrorDir)
+	c.Assert(repo, NotNil)
+
+	err := ioutil.WriteFile(filepath.Join(mirrorDir, "bar_1.0_all.snap"), []byte("corrupted"), 0644)
+	c.Assert(err, IsNil)
+
+	parts, err := repo.Details("bar")
+	c.Assert(err, IsNil)
+	err = parts[0].Install(nil, 0)
+	c.Assert(err, FitsTypeOf, &ErrHashMismatch{})
+	c.Assert(ActiveSnapByName("bar"), IsNil)
+}
+
+func (s *SnapTestSuite) TestInstallFromMirror(c *C) {
+	mirrorDir := makeTestMirror(c)
+	c.Assert(os.MkdirAll(filepath.Dir(snapMirrorDir), 0755), IsNil)
+	c.Assert(os.Symlink(mirrorDir, snapMirrorDir), IsNil)
+
+	c.Assert(Install("foo", 0), IsNil)
+	c.Assert(ActiveSnapByName("foo").Version(), Equals, "2.0")
+}
@@ -19,8 +19,12 @@ package snappy
 
 import (
 	"fmt"
+	"log"
 	"net"
+	"sync"
 	"time"
+
+	"launchpad.net/snappy/helpers"
 )
 
 // SnapType represents the kind of snap (app, core, frameworks, oem)
@@ -65,6 +69,9 @@ type Part interface {
 	// Returns app, framework, core
 	Type() SnapType
 
+	// returns the frameworks the part needs
+	Frameworks() ([]string, error)
+
 	InstalledSize() int64
 	DownloadSize() int64
 
@@ -93,97 +100,145 @@ type Repository interface {
 	Installed() ([]Part, error)
 }
 
+// the time a single repository has to answer a query of the
+// MetaRepository
+var repositoryTimeout = 30 * time.Second
+
 // MetaRepository contains all available single repositories can can be used
 // to query in a single place
 type MetaRepository struct {
 	all []Repository
 }
 
-// NewMetaRepository returns a new MetaRepository
+// NewMetaRepository returns a new MetaRepository with the repositories
+// from the repositories config (or the default ones if there is none)
 func NewMetaRepository() *MetaRepository {
-	// FIXME: make this a configuration file
+	configs := defaultRepositories()
+	if helpers.FileExists(snappyRepositoriesConfig) {
+		cfg, err := readRepositoriesConfig(snappyRepositoriesConfig)
+		if err != nil {
+			log.Printf("WARNING: using the default repositories: %s", err)
+		} else {
+			configs = cfg
+		}
+	}
 
 	m := new(MetaRepository)
 	m.all = []Repository{}
 	// its ok if repos fail if e.g. no dbus is available
-	if repo := NewSystemImageRepository(); repo != nil {
-		m.all = append(m.all, repo)
+	for _, cfg := range configs {
+		if repo := newRepositoryFromConfig(cfg); repo != nil {
+			m.all = append(m.all, repo)
+		}
 	}
-	if repo := NewUbuntuStoreSnapRepository(); repo != nil {
-		m.all = append(m.all, repo)
+
+	return m
+}
+
+// repositoryResult is the answer of a single repository
+type repositoryResult struct {
+	parts []Part
+	err   error
+}
+
+// query calls f for all repositories concurrently and returns the
+// merged results in the order of the repositories. If some of the
+// repositories fail or do not answer in time the results of the
+// others are returned together with a *ErrRepositories.
+func (m *MetaRepository) query(f func(r Repository) ([]Part, error)) (parts []Part, err error) {
+	results := make([]repositoryResult, len(m.all))
+
+	var wg sync.WaitGroup
+	for i, r := range m.all {
+		wg.Add(1)
+		go func(i int, r Repository) {
+			defer wg.Done()
+
+			// buffered so that a late answer does not block
+			done := make(chan repositoryResult, 1)
+			go func() {
+				parts, err := f(r)
+				done <- repositoryResult{parts, err}
+			}()
+
+			select {
+			case results[i] = <-done:
+			case <-time.After(repositoryTimeout):
+				results[i] = repositoryResult{err: ErrRepositoryTimeout}
+			}
+		}(i, r)
 	}
-	if repo := NewLocalSnapRepository(snapAppsDir); repo != nil {
-		m.all = append(m.all, repo)
+	wg.Wait()
+
+	var repoErrs []repositoryError
+	for i, res := range results {
+		if res.err != nil {
+			repoErrs = append(repoErrs, repositoryError{m.all[i].Description(), res.err})
+			continue
+		}
+		parts = append(parts, res.parts...)
 	}
-	if repo := NewLocalSnapRepository(snapOemDir); repo != nil {
-		m.all = append(m.all, repo)
+	if len(repoErrs) > 0 {
+		return parts, &ErrRepositories{repoErrs}
 	}
 
-	return m
+	return parts, nil
 }
 
 // Installed returns all installed parts
 func (m *MetaRepository) Installed() (parts []Part, err error) {
-	for _, r := range m.all {
-		installed, err := r.Installed()
-		if err != nil {
-			return parts, err
-		}
-		parts = append(parts, installed...)
-	}
-
-	return parts, err
+	return m.query(func(r Repository) ([]Part, error) {
+		return r.Installed()
+	})
 }
 
 // Updates returns all updatable parts
 func (m *MetaRepository) Updates() (parts []Part, err error) {
-	for _, r := range m.all {
-		updates, err := r.Updates()
-		if err != nil {
-			return parts, err
-		}
-		parts = append(parts, updates...)
-	}
-
-	return parts, err
+	return m.query(func(r Repository) ([]Part, error) {
+		return r.Updates()
+	})
 }
 
 // Search searches all repositories for the given search term
 func (m *MetaRepository) Search(terms string) (parts []Part, err error) {
-	for _, r := range m.all {
-		results, err := r.Search(terms)
-		if err != nil {
-			return parts, err
-		}
-		parts = append(parts, results...)
-	}
-
-	return parts, err
+	return m.query(func(r Repository) ([]Part, error) {
+		return r.Search(terms)
+	})
 }
 
 // Details returns details for the given snap name
 func (m *MetaRepository) Details(snapyName string) (parts []Part, err error) {
-	for _, r := range m.all {
+	return m.query(func(r Repository) ([]Part, error) {
 		results, err := r.Details(snapyName)
 		// ignore network errors here, we will also collect
 		// local results
-		_, netError := err.(net.Error)
-		switch {
-		case err == ErrPackageNotFound || netError:
-			continue
-		case err != nil:
-			return parts, err
+		if _, netError := err.(net.Error); err == ErrPackageNotFound || netError {
+			return nil, nil
 		}
-		parts = append(parts, results...)
+
+		return results, err
+	})
+}
+
+// newMetaRepository is the MetaRepository used to find installed snaps
+var newMetaRepository = NewMetaRepository
+
+// installedParts returns the installed parts of all repositories; the
+// repositories that failed are logged and the results of the others
+// are used
+func installedParts() ([]Part, error) {
+	installed, err := newMetaRepository().Installed()
+	if _, ok := err.(*ErrRepositories); ok {
+		log.Printf("WARNING: %s", err)
+		return installed, nil
 	}
 
-	return parts, err
+	return installed, err
 }
 
 // InstalledSnapsByType returns all installed snaps with the given type
 func InstalledSnapsByType(snapTs ...SnapType) (res []Part, err error) {
-	m := NewMetaRepository()
-	installed, err := m.Installed()
+	installed, err := installedParts()
 	if err != nil {
 		return nil, err
 	}
@@ -207,6 +262,10 @@ var InstalledSnapNamesByType = installedSnapNamesByTypeImpl
 
 func installedSnapNamesByTypeImpl(snapTs ...SnapType) (res []string, err error) {
 	installed, err := InstalledSnapsByType(snapTs...)
+	if err != nil {
+		return nil, err
+	}
+
 	for _, part := range installed {
 		res = append(res, part.Name())
 	}
@@ -216,8 +275,7 @@ func installedSnapNamesByTypeImpl(snapTs ...SnapType) (res []string, err error)
 
 // ActiveSnapByName returns all active snaps with the given name
 func ActiveSnapByName(needle string) Part {
-	m := NewMetaRepository()
-	installed, err := m.Installed()
+	installed, err := installedParts()
 	if err != nil {
 		return nil
 	}
@@ -258,8 +316,7 @@ func FindSnapByNameAndVersion(needle, version string, haystack []Part) Part {
 // MakeSnapActiveByNameAndVersion makes the given snap version the active
 // version
 func makeSnapActiveByNameAndVersion(pkg, ver string) error {
-	m := NewMetaRepository()
-	installed, err := m.Installed()
+	installed, err := installedParts()
 	if err != nil {
 		return err
 	}
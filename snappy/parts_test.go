@@ -18,6 +18,9 @@
 package snappy
 
 import (
+	"errors"
+	"time"
+
 	. "launchpad.net/gocheck"
 )
 
@@ -81,3 +84,106 @@ func (s *SnapTestSuite) FindSnapsByNameFound(c *C) {
 	c.Assert(parts, HasLen, 1)
 	c.Assert(parts[0].Name(), Equals, "hello-app")
 }
+
+// fakeRepository is a Repository that answers every query with the
+// same parts or error after the given delay
+type fakeRepository struct {
+	name  string
+	parts []Part
+	err   error
+	delay time.Duration
+}
+
+func (r *fakeRepository) answer() ([]Part, error) {
+	time.Sleep(r.delay)
+	return r.parts, r.err
+}
+
+func (r *fakeRepository) Description() string                     { return r.name }
+func (r *fakeRepository) Search(terms string) ([]Part, error)     { return r.answer() }
+func (r *fakeRepository) Details(snapName string) ([]Part, error) { return r.answer() }
+func (r *fakeRepository) Updates() ([]Part, error)                { return r.answer() }
+func (r *fakeRepository) Installed() ([]Part, error)              { return r.answer() }
+
+func fakeRemotePart(name string) Part {
+	return &RemoteSnapPart{pkg: remoteSnap{Name: name}}
+}
+
+func (s *SnapTestSuite) TestMetaRepositoryQueryOrder(c *C) {
+	m := &MetaRepository{all: []Repository{
+		&fakeRepository{name: "slow", parts: []Part{fakeRemotePart("a")}, delay: 50 * time.Millisecond},
+		&fakeRepository{name: "fast", parts: []Part{fakeRemotePart("b"), fakeRemotePart("c")}},
+	}}
+
+	parts, err := m.Search("foo")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 3)
+	// the results are in the order of the repositories
+	c.Assert(parts[0].Name(), Equals, "a")
+	c.Assert(parts[1].Name(), Equals, "b")
+	c.Assert(parts[2].Name(), Equals, "c")
+}
+
+func (s *SnapTestSuite) TestMetaRepositoryQueryPartialResults(c *C) {
+	m := &MetaRepository{all: []Repository{
+		&fakeRepository{name: "system-image", parts: []Part{fakeRemotePart("ubuntu-core")}},
+		&fakeRepository{name: "store", err: errors.New("store is down")},
+		&fakeRepository{name: "other-store", err: errors.New("no network")},
+	}}
+
+	parts, err := m.Updates()
+	c.Assert(parts, HasLen, 1)
+	c.Assert(parts[0].Name(), Equals, "ubuntu-core")
+	c.Assert(err, FitsTypeOf, &ErrRepositories{})
+	c.Assert(err, ErrorMatches, "2 repositories failed: store: store is down, other-store: no network")
+}
+
+func (s *SnapTestSuite) TestInstalledPartsIgnoresFailedRepositories(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	makeSnapActive(yamlPath)
+
+	newMetaRepository = func() *MetaRepository {
+		return &MetaRepository{all: []Repository{
+			NewLocalSnapRepository(snapAppsDir),
+			&fakeRepository{name: "system-image", err: errors.New("no dbus")},
+		}}
+	}
+
+	part := ActiveSnapByName("hello-app")
+	c.Assert(part, NotNil)
+	c.Assert(part.Name(), Equals, "hello-app")
+
+	parts, err := InstalledSnapsByType(SnapTypeApp)
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+}
+
+func (s *SnapTestSuite) TestMetaRepositoryQueryTimeout(c *C) {
+	oldRepositoryTimeout := repositoryTimeout
+	defer func() { repositoryTimeout = oldRepositoryTimeout }()
+	repositoryTimeout = 10 * time.Millisecond
+
+	m := &MetaRepository{all: []Repository{
+		&fakeRepository{name: "local", parts: []Part{fakeRemotePart("a")}},
+		&fakeRepository{name: "hanging", parts: []Part{fakeRemotePart("b")}, delay: time.Second},
+	}}
+
+	start := time.Now()
+	parts, err := m.Installed()
+	c.Assert(time.Since(start) < time.Second, Equals, true)
+	c.Assert(parts, HasLen, 1)
+	c.Assert(parts[0].Name(), Equals, "a")
+	c.Assert(err, ErrorMatches, "1 repositories failed: hanging: repository did not answer in time")
+}
+
+func (s *SnapTestSuite) TestMetaRepositoryDetailsIgnoresNotFound(c *C) {
+	m := &MetaRepository{all: []Repository{
+		&fakeRepository{name: "store", err: ErrPackageNotFound},
+		&fakeRepository{name: "local", parts: []Part{fakeRemotePart("a")}},
+	}}
+
+	parts, err := m.Details("a")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+}
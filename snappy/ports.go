@@ -0,0 +1,304 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"launchpad.net/snappy/helpers"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the highest port number
+const maxPort = 65535
+
+// portClaim is a external port of a service of a active snap
+type portClaim struct {
+	Snap    string `yaml:"snap"`
+	Service string `yaml:"service"`
+	Tag     string `yaml:"tag"`
+	// the port the service got, this is not the port it asked
+	// for if that was taken and the port is negotiable
+	Port string `yaml:"port"`
+}
+
+// the /var/lib/snappy/ports.yaml file
+type portRegistry struct {
+	Claims []portClaim `yaml:"claims"`
+}
+
+// parsePort splits a port like "80/tcp" into its number and protocol
+func parsePort(port string) (num int, proto string, err error) {
+	l := strings.SplitN(port, "/", 2)
+	if len(l) != 2 {
+		return 0, "", fmt.Errorf("invalid port %q, expected number/protocol", port)
+	}
+	num, err = strconv.Atoi(l[0])
+	if err != nil || num < 1 || num > maxPort {
+		return 0, "", fmt.Errorf("invalid port number in %q", port)
+	}
+	if l[1] != "tcp" && l[1] != "udp" {
+		return 0, "", fmt.Errorf("invalid protocol in %q, expected tcp or udp", port)
+	}
+
+	return num, l[1], nil
+}
+
+func readPortRegistry() (*portRegistry, error) {
+	var r portRegistry
+
+	content, err := ioutil.ReadFile(snapPortsFile)
+	if os.IsNotExist(err) {
+		return &r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, &r); err != nil {
+		return nil, fmt.Errorf("%s: %s", snapPortsFile, err)
+	}
+
+	return &r, nil
+}
+
+func (r *portRegistry) save() error {
+	content, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := helpers.EnsureDir(filepath.Dir(snapPortsFile), 0755); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(snapPortsFile, content, 0644)
+}
+
+// claimsOf returns the claims of the given snap
+func (r *portRegistry) claimsOf(name string) (claims []portClaim) {
+	for _, claim := range r.Claims {
+		if claim.Snap == name {
+			claims = append(claims, claim)
+		}
+	}
+
+	return claims
+}
+
+// setClaims replaces the claims of the given snap
+func (r *portRegistry) setClaims(name string, claims []portClaim) {
+	var kept []portClaim
+	for _, claim := range r.Claims {
+		if claim.Snap != name {
+			kept = append(kept, claim)
+		}
+	}
+
+	r.Claims = append(kept, claims...)
+}
+
+// sortedPortTags returns the tags of the ports in a stable order
+func sortedPortTags(ports map[string]Port) []string {
+	tags := make([]string, 0, len(ports))
+	for tag := range ports {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+// assignPorts returns the claims for the external ports of the services
+// of the given snap. Ports that are used by other snaps are replaced
+// by free ones if they are negotiable, otherwise a ErrPortConflict is
+// returned.
+func (r *portRegistry) assignPorts(m *packageYaml) (claims []portClaim, err error) {
+	taken := make(map[string]portClaim)
+	previous := make(map[string]string)
+	for _, claim := range r.Claims {
+		if claim.Snap == m.Name {
+			previous[claim.Service+"/"+claim.Tag] = claim.Port
+			continue
+		}
+		// claims of snaps that went away without releasing them
+		if !snapIsInstalled(claim.Snap, "") {
+			continue
+		}
+		taken[claim.Port] = claim
+	}
+
+	for _, service := range m.Services {
+		if service.Ports == nil {
+			continue
+		}
+		for _, tag := range sortedPortTags(service.Ports.External) {
+			want := service.Ports.External[tag]
+			if want.Port == "" {
+				continue
+			}
+			claim := portClaim{Snap: m.Name, Service: service.Name, Tag: tag, Port: want.Port}
+
+			// negotiable ports keep what they got last time
+			if prev, ok := previous[service.Name+"/"+tag]; ok && want.Negotiable {
+				if _, used := taken[prev]; !used {
+					claim.Port = prev
+				}
+			}
+
+			if owner, used := taken[claim.Port]; used {
+				if !want.Negotiable {
+					return nil, &ErrPortConflict{
+						snap:  m.Name + "." + service.Name,
+						port:  claim.Port,
+						owner: owner.Snap + "." + owner.Service,
+					}
+				}
+				if claim.Port, err = freePort(want.Port, taken); err != nil {
+					return nil, err
+				}
+			}
+
+			taken[claim.Port] = claim
+			claims = append(claims, claim)
+		}
+	}
+
+	return claims, nil
+}
+
+// freePort returns the first port after the given one that is not taken
+func freePort(port string, taken map[string]portClaim) (string, error) {
+	num, proto, err := parsePort(port)
+	if err != nil {
+		return "", err
+	}
+
+	for i := num + 1; i <= maxPort; i++ {
+		candidate := fmt.Sprintf("%d/%s", i, proto)
+		if _, used := taken[candidate]; !used {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("no free port after %s", port)
+}
+
+// claim records the given claims of the snap, it returns a function
+// that restores the previous claims of the snap
+func (r *portRegistry) claim(name string, claims []portClaim) (restore func() error, err error) {
+	old := r.claimsOf(name)
+	r.setClaims(name, claims)
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		r.setClaims(name, old)
+		return r.save()
+	}, nil
+}
+
+// releasePorts drops the claims of the given snap
+func releasePorts(name string) error {
+	r, err := readPortRegistry()
+	if err != nil {
+		return err
+	}
+	if len(r.claimsOf(name)) == 0 {
+		return nil
+	}
+
+	r.setClaims(name, nil)
+
+	return r.save()
+}
+
+// servicePorts returns the ports of the given service of the snap by tag
+func servicePorts(name, service string) (map[string]string, error) {
+	r, err := readPortRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make(map[string]string)
+	for _, claim := range r.claimsOf(name) {
+		if claim.Service == service {
+			ports[claim.Tag] = claim.Port
+		}
+	}
+
+	return ports, nil
+}
+
+// portEnvName returns the environment variable that passes the port
+// with the given tag to a service, e.g. SNAP_PORT_UI
+func portEnvName(tag string) string {
+	return "SNAP_PORT_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, tag)
+}
+
+// portsConfig returns the config that tells the snap which ports its
+// services got, as the "ports" key that all apps with ports support
+func portsConfig(name string, claims []portClaim) (string, error) {
+	ports := make(map[string]string, len(claims))
+	for _, claim := range claims {
+		ports[claim.Tag] = claim.Port
+	}
+
+	config := map[string]map[string]map[string]map[string]string{
+		"config": {name: {"ports": ports}},
+	}
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+// configSnapPorts passes the ports of the snap in the given dir to its
+// config hook, if it has one
+func configSnapPorts(snapDir, name string, claims []portClaim) error {
+	if len(claims) == 0 {
+		return nil
+	}
+
+	config, err := portsConfig(name, claims)
+	if err != nil {
+		return err
+	}
+
+	if _, err := snapConfig(snapDir, config); err != nil && err != ErrConfigNotFound {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"launchpad.net/snappy/helpers"
+
+	. "launchpad.net/gocheck"
+)
+
+const portsPackageYaml = `name: %s
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: web
+   start: bin/foo
+   ports:
+     external:
+       ui:
+         port: 80/tcp
+         negotiable: %v
+`
+
+// makeTestSnapWithPorts builds a snap with a web service that wants
+// port 80/tcp and a config hook that writes its input to configLog
+func makeTestSnapWithPorts(c *C, name string, negotiable bool, configLog string) string {
+	sourceDir := makeExampleSnapSourceDir(c, fmt.Sprintf(portsPackageYaml, name, negotiable))
+	hooksDir := filepath.Join(sourceDir, "meta", "hooks")
+	c.Assert(os.MkdirAll(hooksDir, 0755), IsNil)
+	script := fmt.Sprintf("#!/bin/sh\ncat > %s\n", configLog)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooksDir, "config"), []byte(script), 0755), IsNil)
+
+	var snapFile string
+	err := helpers.ChDir(sourceDir, func() {
+		var err error
+		snapFile, err = Build(sourceDir, "")
+		c.Assert(err, IsNil)
+	})
+	c.Assert(err, IsNil)
+
+	return filepath.Join(sourceDir, snapFile)
+}
+
+func (s *SnapTestSuite) TestParsePort(c *C) {
+	num, proto, err := parsePort("80/tcp")
+	c.Assert(err, IsNil)
+	c.Assert(num, Equals, 80)
+	c.Assert(proto, Equals, "tcp")
+
+	for _, port := range []string{"80", "0/tcp", "65536/udp", "http/tcp", "80/sctp"} {
+		_, _, err := parsePort(port)
+		c.Check(err, NotNil, Commentf(port))
+	}
+}
+
+func (s *SnapTestSuite) TestSortedPortTags(c *C) {
+	c.Assert(sortedPortTags(map[string]Port{"ui": {}, "admin": {}, "api": {}}), DeepEquals, []string{"admin", "api", "ui"})
+	c.Assert(sortedPortTags(nil), HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestPortConflict(c *C) {
+	configLog := filepath.Join(s.tempdir, "config.log")
+	c.Assert(installClick(makeTestSnapWithPorts(c, "foo", false, configLog), 0, nil), IsNil)
+
+	err := installClick(makeTestSnapWithPorts(c, "bar", false, configLog), 0, nil)
+	c.Assert(err, FitsTypeOf, &ErrPortConflict{})
+	c.Assert(err, ErrorMatches, "bar.web can not use port 80/tcp, it is already used by foo.web")
+	c.Assert(snapIsInstalled("bar", ""), Equals, false)
+
+	r, err := readPortRegistry()
+	c.Assert(err, IsNil)
+	c.Assert(r.Claims, DeepEquals, []portClaim{
+		{Snap: "foo", Service: "web", Tag: "ui", Port: "80/tcp"},
+	})
+}
+
+func (s *SnapTestSuite) TestPortNegotiation(c *C) {
+	configLog := filepath.Join(s.tempdir, "config.log")
+	c.Assert(installClick(makeTestSnapWithPorts(c, "foo", false, configLog), 0, nil), IsNil)
+	c.Assert(installClick(makeTestSnapWithPorts(c, "bar", true, configLog), 0, nil), IsNil)
+
+	// the service and the config hook learn about the other port
+	content, err := ioutil.ReadFile(filepath.Join(snapServicesDir, "bar_web_1.0.service"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(content), "\nEnvironment=\"SNAP_PORT_UI=81/tcp\"\n"), Equals, true)
+
+	content, err = ioutil.ReadFile(configLog)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "config:\n  bar:\n    ports:\n      ui: 81/tcp\n")
+
+	// and keep it on updates
+	c.Assert(installClick(makeTestSnapWithPorts(c, "bar", true, configLog), 0, nil), IsNil)
+	ports, err := servicePorts("bar", "web")
+	c.Assert(err, IsNil)
+	c.Assert(ports, DeepEquals, map[string]string{"ui": "81/tcp"})
+}
+
+func (s *SnapTestSuite) TestPortsReleasedOnRemove(c *C) {
+	configLog := filepath.Join(s.tempdir, "config.log")
+	c.Assert(installClick(makeTestSnapWithPorts(c, "foo", false, configLog), 0, nil), IsNil)
+	c.Assert(removeClick(filepath.Join(snapAppsDir, "foo", "1.0")), IsNil)
+
+	r, err := readPortRegistry()
+	c.Assert(err, IsNil)
+	c.Assert(r.Claims, HasLen, 0)
+
+	c.Assert(installClick(makeTestSnapWithPorts(c, "bar", false, configLog), 0, nil), IsNil)
+}
+
+func (s *SnapTestSuite) TestAssignPortsIgnoresStaleClaims(c *C) {
+	r := &portRegistry{Claims: []portClaim{
+		{Snap: "gone", Service: "web", Tag: "ui", Port: "80/tcp"},
+	}}
+	m, err := parsePackageYamlData([]byte(fmt.Sprintf(portsPackageYaml, "foo", false)))
+	c.Assert(err, IsNil)
+
+	claims, err := r.assignPorts(m)
+	c.Assert(err, IsNil)
+	c.Assert(claims, DeepEquals, []portClaim{
+		{Snap: "foo", Service: "web", Tag: "ui", Port: "80/tcp"},
+	})
+}
+
+func (s *SnapTestSuite) TestValidatePorts(c *C) {
+	err := s.validatePackageYaml(c, `name: foo
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: web
+   start: bin/foo
+   ports:
+     internal:
+       db:
+         port: 5432
+     external:
+       ui:
+         port: 80/tcp
+ - name: admin
+   start: bin/foo
+   ports:
+     external:
+       ui:
+         port: 8080/tcp
+`)
+	c.Assert(err, FitsTypeOf, &ErrInvalidPackageYaml{})
+	c.Assert(err.(*ErrInvalidPackageYaml).problems, DeepEquals, []string{
+		`services[0].ports.internal.db.port: invalid port "5432", expected number/protocol`,
+		`services[1].ports.external.ui: duplicate name "ui"`,
+	})
+}
@@ -23,15 +23,27 @@ import (
 	"launchpad.net/snappy/logger"
 )
 
+// RemoveFlags can be used to pass additional flags to the removal of a
+// snap
+type RemoveFlags uint
+
+const (
+	// ForceRemove removes a framework even if active snaps use it
+	ForceRemove RemoveFlags = 1 << iota
+	// PurgeData removes the data of the removed version too, and all
+	// data of the snap if no other version is left
+	PurgeData
+)
+
 // Remove a part by a partSpec string, this can be "name" or "name=version"
-func Remove(partSpec string) error {
+func Remove(partSpec string, flags RemoveFlags) error {
 	var part Part
 	// Note that "=" is not legal in a snap name or a snap version
 	l := strings.Split(partSpec, "=")
 	if len(l) == 2 {
 		name := l[0]
 		version := l[1]
-		installed, err := NewMetaRepository().Installed()
+		installed, err := installedParts()
 		if err != nil {
 			return err
 		}
@@ -44,5 +56,36 @@ func Remove(partSpec string) error {
 		return ErrPackageNotFound
 	}
 
-	return logger.LogError(part.Uninstall())
+	// the apps need the active version of the framework
+	if part.Type() == SnapTypeFramework && part.IsActive() && flags&ForceRemove == 0 {
+		users, err := frameworkUsers(part.Name())
+		if err != nil {
+			return err
+		}
+		if len(users) > 0 {
+			return &ErrFrameworkInUse{framework: part.Name(), users: users}
+		}
+	}
+
+	if err := part.Uninstall(); err != nil {
+		return logger.LogError(err)
+	}
+
+	if flags&PurgeData != 0 {
+		if err := purgeSnapData(part.Name(), part.Version()); err != nil {
+			return logger.LogError(err)
+		}
+	}
+
+	// nothing is left of the snap, a new install starts from scratch
+	if !snapIsInstalled(part.Name(), "") {
+		if err := removeSnapChannel(part.Name()); err != nil {
+			return logger.LogError(err)
+		}
+		if flags&PurgeData != 0 {
+			return logger.LogError(purgeSnapData(part.Name(), "*"))
+		}
+	}
+
+	return nil
 }
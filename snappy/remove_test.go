@@ -23,7 +23,7 @@ import (
 
 func (s *SnapTestSuite) TestRemoveNonExistingRaisesError(c *C) {
 	pkgName := "some-random-non-existing-stuff"
-	err := Remove(pkgName)
+	err := Remove(pkgName, 0)
 	c.Assert(err, NotNil)
 	c.Assert(err, Equals, ErrPackageNotFound)
 }
@@ -31,7 +31,7 @@ func (s *SnapTestSuite) TestRemoveNonExistingRaisesError(c *C) {
 func (s *SnapTestSuite) TestSnapRemoveByVersion(c *C) {
 	makeTwoTestSnaps(c, SnapTypeApp)
 
-	err := Remove("foo=1.0")
+	err := Remove("foo=1.0", 0)
 
 	m := NewMetaRepository()
 	installed, err := m.Installed()
@@ -42,7 +42,7 @@ func (s *SnapTestSuite) TestSnapRemoveByVersion(c *C) {
 func (s *SnapTestSuite) TestSnapRemoveActive(c *C) {
 	makeTwoTestSnaps(c, SnapTypeApp)
 
-	err := Remove("foo")
+	err := Remove("foo", 0)
 
 	m := NewMetaRepository()
 	installed, err := m.Installed()
@@ -53,13 +53,13 @@ func (s *SnapTestSuite) TestSnapRemoveActive(c *C) {
 func (s *SnapTestSuite) TestSnapRemoveActiveOemFails(c *C) {
 	makeTwoTestSnaps(c, SnapTypeOem)
 
-	err := Remove("foo")
+	err := Remove("foo", 0)
 	c.Assert(err, DeepEquals, ErrPackageNotRemovable)
 
-	err = Remove("foo=1.0")
+	err = Remove("foo=1.0", 0)
 	c.Assert(err, IsNil)
 
-	err = Remove("foo")
+	err = Remove("foo", 0)
 	c.Assert(err, DeepEquals, ErrPackageNotRemovable)
 
 	m := NewMetaRepository()
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the repository types that can be used in the repositories config
+const (
+	repoTypeSystemImage = "system-image"
+	repoTypeStore       = "store"
+	repoTypeMirror      = "mirror"
+	repoTypeLocal       = "local"
+)
+
+// repositoryConfig is a single entry in the repositories config
+type repositoryConfig struct {
+	Type string `yaml:"type"`
+
+	// mirror and local only
+	Path string `yaml:"path,omitempty"`
+
+	// store only, the base uri of the store api and the store id
+	// that is sent to it
+	URI     string `yaml:"uri,omitempty"`
+	StoreID string `yaml:"store-id,omitempty"`
+
+	// repositories with a higher priority are asked first
+	Priority int `yaml:"priority,omitempty"`
+}
+
+// the /etc/snappy/repositories.yaml file
+type repositoriesConfig struct {
+	Repositories []repositoryConfig `yaml:"repositories"`
+}
+
+// byPriority sorts repository configs with the highest priority first
+type byPriority []repositoryConfig
+
+func (bp byPriority) Less(a, b int) bool {
+	return bp[a].Priority > bp[b].Priority
+}
+func (bp byPriority) Swap(a, b int) {
+	bp[a], bp[b] = bp[b], bp[a]
+}
+func (bp byPriority) Len() int {
+	return len(bp)
+}
+
+// the repositories that are used if there is no repositories config
+func defaultRepositories() []repositoryConfig {
+	return []repositoryConfig{
+		{Type: repoTypeSystemImage},
+		{Type: repoTypeMirror, Path: snapMirrorDir},
+		{Type: repoTypeStore},
+		{Type: repoTypeLocal, Path: snapAppsDir},
+		{Type: repoTypeLocal, Path: snapOemDir},
+	}
+}
+
+// readRepositoriesConfig reads the repositories config from the given
+// path and returns the repositories in the order they should be used
+func readRepositoriesConfig(path string) ([]repositoryConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg repositoriesConfig
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return nil, err
+	}
+
+	hasLocal := false
+	for i, repo := range cfg.Repositories {
+		switch repo.Type {
+		case repoTypeSystemImage, repoTypeStore:
+			// nothing to check
+		case repoTypeMirror, repoTypeLocal:
+			if repo.Path == "" {
+				return nil, fmt.Errorf("%s: repository %d of type %s needs a path", path, i, repo.Type)
+			}
+			// paths are relative to the global root
+			cfg.Repositories[i].Path = filepath.Join(globalRootDir, repo.Path)
+			hasLocal = hasLocal || repo.Type == repoTypeLocal
+		default:
+			return nil, fmt.Errorf("%s: repository %d has unknown type %q", path, i, repo.Type)
+		}
+	}
+
+	// without the local repositories snappy can not know what is
+	// installed, so they are always there
+	if !hasLocal {
+		cfg.Repositories = append(cfg.Repositories,
+			repositoryConfig{Type: repoTypeLocal, Path: snapAppsDir},
+			repositoryConfig{Type: repoTypeLocal, Path: snapOemDir},
+		)
+	}
+
+	sort.Stable(byPriority(cfg.Repositories))
+
+	return cfg.Repositories, nil
+}
+
+// newStoreRepositoryFromConfig returns a SnapUbuntuStoreRepository for
+// the given config or nil if the store is disabled
+func newStoreRepositoryFromConfig(cfg repositoryConfig) *SnapUbuntuStoreRepository {
+	if cfg.URI == "" {
+		repo := NewUbuntuStoreSnapRepository()
+		if repo != nil {
+			repo.storeID = cfg.StoreID
+		}
+		return repo
+	}
+
+	// see https://wiki.ubuntu.com/AppStore/Interfaces/ClickPackageIndex
+	baseURI := strings.TrimSuffix(cfg.URI, "/")
+	return &SnapUbuntuStoreRepository{
+		searchURI:  baseURI + "/search?q=%s",
+		detailsURI: baseURI + "/package/%s",
+		bulkURI:    baseURI + "/click-metadata",
+		storeID:    cfg.StoreID,
+	}
+}
+
+// newRepositoryFromConfig returns the repository for the given config
+// or nil if the repository is not available
+func newRepositoryFromConfig(cfg repositoryConfig) Repository {
+	// careful to not return typed nil pointers here
+	switch cfg.Type {
+	case repoTypeSystemImage:
+		if repo := NewSystemImageRepository(); repo != nil {
+			return repo
+		}
+	case repoTypeStore:
+		if repo := newStoreRepositoryFromConfig(cfg); repo != nil {
+			return repo
+		}
+	case repoTypeMirror:
+		if repo := NewMirrorSnapRepository(cfg.Path); repo != nil {
+			return repo
+		}
+	case repoTypeLocal:
+		if repo := NewLocalSnapRepository(cfg.Path); repo != nil {
+			return repo
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+)
+
+func writeRepositoriesConfig(c *C, content string) {
+	c.Assert(os.MkdirAll(filepath.Dir(snappyRepositoriesConfig), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(snappyRepositoriesConfig, []byte(content), 0644), IsNil)
+}
+
+// makeTestMirrorInRoot makes a test mirror at /media/snaps in the
+// test root dir
+func (s *SnapTestSuite) makeTestMirrorInRoot(c *C) string {
+	mirrorDir := filepath.Join(s.tempdir, "media", "snaps")
+	c.Assert(os.MkdirAll(filepath.Dir(mirrorDir), 0755), IsNil)
+	c.Assert(os.Rename(makeTestMirror(c), mirrorDir), IsNil)
+
+	return mirrorDir
+}
+
+func repositoryDescriptions(m *MetaRepository) (res []string) {
+	for _, repo := range m.all {
+		res = append(res, repo.Description())
+	}
+	return res
+}
+
+func (s *SnapTestSuite) TestReadRepositoriesConfigPriority(c *C) {
+	writeRepositoriesConfig(c, `repositories:
+ - type: store
+   uri: https://store.example.com/api/v1/
+   store-id: my-store
+ - type: mirror
+   path: /media/snaps
+   priority: 10
+ - type: system-image
+`)
+
+	repos, err := readRepositoriesConfig(snappyRepositoriesConfig)
+	c.Assert(err, IsNil)
+	c.Assert(repos, DeepEquals, []repositoryConfig{
+		{Type: "mirror", Path: filepath.Join(s.tempdir, "/media/snaps"), Priority: 10},
+		{Type: "store", URI: "https://store.example.com/api/v1/", StoreID: "my-store"},
+		{Type: "system-image"},
+		// the local repositories are always added
+		{Type: "local", Path: snapAppsDir},
+		{Type: "local", Path: snapOemDir},
+	})
+}
+
+func (s *SnapTestSuite) TestReadRepositoriesConfigLocal(c *C) {
+	writeRepositoriesConfig(c, `repositories:
+ - type: local
+   path: /apps
+`)
+
+	repos, err := readRepositoriesConfig(snappyRepositoriesConfig)
+	c.Assert(err, IsNil)
+	c.Assert(repos, DeepEquals, []repositoryConfig{
+		{Type: "local", Path: snapAppsDir},
+	})
+}
+
+func (s *SnapTestSuite) TestReadRepositoriesConfigErrors(c *C) {
+	writeRepositoriesConfig(c, `repositories:
+ - type: ftp
+`)
+	_, err := readRepositoriesConfig(snappyRepositoriesConfig)
+	c.Assert(err, ErrorMatches, `.*repository 0 has unknown type "ftp"`)
+
+	writeRepositoriesConfig(c, `repositories:
+ - type: mirror
+`)
+	_, err = readRepositoriesConfig(snappyRepositoriesConfig)
+	c.Assert(err, ErrorMatches, `.*repository 0 of type mirror needs a path`)
+}
+
+func (s *SnapTestSuite) TestMetaRepositoryDefault(c *C) {
+	storeSearchURI = "https://search.example.com/%s"
+	storeDetailsURI = "https://details.example.com/%s"
+	storeBulkURI = "https://bulk.example.com/"
+	c.Assert(os.MkdirAll(snapAppsDir, 0755), IsNil)
+
+	m := NewMetaRepository()
+	c.Assert(repositoryDescriptions(m), DeepEquals, []string{
+		"SystemImageRepository",
+		"Snap remote repository for https://search.example.com/%s",
+		"Snap local repository for " + snapAppsDir,
+	})
+}
+
+func (s *SnapTestSuite) TestMetaRepositoryFromConfig(c *C) {
+	mirrorDir := s.makeTestMirrorInRoot(c)
+	c.Assert(os.MkdirAll(snapAppsDir, 0755), IsNil)
+
+	writeRepositoriesConfig(c, `repositories:
+ - type: store
+   uri: https://store.example.com/api/v1
+ - type: mirror
+   path: /media/snaps
+   priority: 10
+`)
+
+	m := NewMetaRepository()
+	c.Assert(repositoryDescriptions(m), DeepEquals, []string{
+		"Snap mirror repository for " + mirrorDir,
+		"Snap remote repository for https://store.example.com/api/v1/search?q=%s",
+		"Snap local repository for " + snapAppsDir,
+	})
+}
+
+func (s *SnapTestSuite) TestMetaRepositoryNoStore(c *C) {
+	storeSearchURI = "https://search.example.com/%s"
+	c.Assert(os.MkdirAll(snapAppsDir, 0755), IsNil)
+
+	writeRepositoriesConfig(c, `repositories:
+ - type: system-image
+`)
+
+	m := NewMetaRepository()
+	c.Assert(repositoryDescriptions(m), DeepEquals, []string{
+		"SystemImageRepository",
+		"Snap local repository for " + snapAppsDir,
+	})
+}
+
+func (s *SnapTestSuite) TestMetaRepositoryInvalidConfig(c *C) {
+	c.Assert(os.MkdirAll(snapAppsDir, 0755), IsNil)
+	writeRepositoriesConfig(c, `repositories: [`)
+
+	m := NewMetaRepository()
+	c.Assert(repositoryDescriptions(m), DeepEquals, []string{
+		"SystemImageRepository",
+		"Snap local repository for " + snapAppsDir,
+	})
+}
+
+func (s *SnapTestSuite) TestMetaRepositoryDetailsPriority(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the store id from the config is used
+		c.Assert(r.Header.Get("X-Ubuntu-Store"), Equals, "my-store")
+		c.Assert(r.URL.Path, Equals, "/api/v1/package/foo")
+		io.WriteString(w, MockDetailsJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	s.makeTestMirrorInRoot(c)
+
+	writeRepositoriesConfig(c, `repositories:
+ - type: store
+   uri: `+mockServer.URL+`/api/v1/
+   store-id: my-store
+   priority: 10
+ - type: mirror
+   path: /media/snaps
+`)
+
+	m := NewMetaRepository()
+	parts, err := m.Details("foo")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 3)
+	// the store has the higher priority so its results come first
+	c.Assert(parts[0].(*RemoteSnapPart).storeID, Equals, "my-store")
+	c.Assert(parts[1].(*MirrorSnapPart).Version(), Equals, "2.0")
+	c.Assert(parts[2].(*MirrorSnapPart).Version(), Equals, "1.0")
+}
@@ -25,23 +25,50 @@ import (
 // Rollback will roll the given pkg back to the given ver. If the version
 // is empty the previous installed version will be used.
 //
-// The version needs to be installed on disk
-func Rollback(pkg, ver string) (version string, err error) {
+// The version needs to be installed on disk or available in the
+// download cache, the flags are used to install it from the cache
+func Rollback(pkg, ver string, flags InstallFlags) (version string, err error) {
+	installed, err := installedParts()
+	if err != nil {
+		return "", err
+	}
 
 	// no version specified, find the previous one
 	if ver == "" {
-		m := NewMetaRepository()
-		installed, err := m.Installed()
-		if err != nil {
-			return "", err
-		}
-		snaps := FindSnapsByName(pkg, installed)
-		if len(snaps) < 2 {
+		ver = previousVersion(pkg, FindSnapsByName(pkg, installed))
+		if ver == "" {
 			return "", fmt.Errorf("no version to rollback to")
 		}
-		sort.Sort(BySnapVersion(snaps))
-		// -1 is the most recent, -2 the previous one
-		ver = snaps[len(snaps)-2].Version()
+	}
+
+	// a version that is no longer installed can be installed again
+	// from the download cache
+	if FindSnapByNameAndVersion(pkg, ver, installed) == nil {
+		if snapFile := cachedSnapFile(pkg, ver, ""); snapFile != "" {
+			if err := installClick(snapFile, flags, nil); err != nil {
+				return "", err
+			}
+			return ver, nil
+		}
+	}
+
+	// the data of the version we roll back to is kept as it was
+	// before the update, only versions that have no data yet get a
+	// copy of the current data
+	if current := ActiveSnapByName(pkg); current != nil && current.Version() != ver {
+		// the services must not write to the data while it is copied
+		snap, ok := current.(*SnapPart)
+		if ok {
+			if err := stopSnapServices(snap.basedir); err != nil {
+				return "", err
+			}
+		}
+		if err := copySnapData(pkg, current.Version(), ver); err != nil {
+			if ok {
+				startSnapServices(snap.basedir)
+			}
+			return "", err
+		}
 	}
 
 	if err := makeSnapActiveByNameAndVersion(pkg, ver); err != nil {
@@ -50,3 +77,36 @@ func Rollback(pkg, ver string) (version string, err error) {
 
 	return ver, nil
 }
+
+// previousVersion returns the version before the most recent one of
+// the given installed snaps, if only one version is installed the
+// download cache is used to find the previous one
+func previousVersion(pkg string, snaps []Part) string {
+	if len(snaps) == 0 {
+		return ""
+	}
+
+	sort.Sort(BySnapVersion(snaps))
+	// -1 is the most recent, -2 the previous one
+	if len(snaps) > 1 {
+		return snaps[len(snaps)-2].Version()
+	}
+
+	current := snaps[0].Version()
+	cached, err := CachedSnaps()
+	if err != nil {
+		return ""
+	}
+
+	previous := ""
+	for _, snap := range cached {
+		if snap.Name != pkg || VersionCompare(snap.Version, current) >= 0 {
+			continue
+		}
+		if previous == "" || VersionCompare(snap.Version, previous) > 0 {
+			previous = snap.Version
+		}
+	}
+
+	return previous
+}
@@ -18,6 +18,10 @@
 package snappy
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	. "launchpad.net/gocheck"
 )
 
@@ -26,7 +30,7 @@ func (s *SnapTestSuite) TestRollbackWithVersion(c *C) {
 	c.Assert(ActiveSnapByName("foo").Version(), Equals, "2.0")
 
 	// rollback with version
-	version, err := Rollback("foo", "1.0")
+	version, err := Rollback("foo", "1.0", 0)
 	c.Assert(err, IsNil)
 	c.Assert(version, Equals, "1.0")
 
@@ -38,9 +42,47 @@ func (s *SnapTestSuite) TestRollbackFindVersion(c *C) {
 	c.Assert(ActiveSnapByName("foo").Version(), Equals, "2.0")
 
 	// rollback without version
-	version, err := Rollback("foo", "")
+	version, err := Rollback("foo", "", 0)
 	c.Assert(err, IsNil)
 	c.Assert(version, Equals, "1.0")
 
 	c.Assert(ActiveSnapByName("foo").Version(), Equals, "1.0")
 }
+
+func (s *SnapTestSuite) TestRollbackRestoresData(c *C) {
+	snapFile := makeTestSnapPackage(c, "name: foo\nversion: 1.0\nvendor: Foo Bar <foo@example.com>\n")
+	c.Assert(installClick(snapFile, 0, nil), IsNil)
+	dataFile1 := filepath.Join(snapDataDir, "foo", "1.0", "db")
+	c.Assert(ioutil.WriteFile(dataFile1, []byte("v1"), 0644), IsNil)
+
+	snapFile = makeTestSnapPackage(c, "name: foo\nversion: 2.0\nvendor: Foo Bar <foo@example.com>\n")
+	c.Assert(installClick(snapFile, 0, nil), IsNil)
+
+	// the new version migrates its copy of the data in place
+	dataFile2 := filepath.Join(snapDataDir, "foo", "2.0", "db")
+	f, err := os.OpenFile(dataFile2, os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("v2"))
+	c.Assert(err, IsNil)
+	f.Close()
+
+	_, err = Rollback("foo", "1.0", 0)
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadFile(dataFile1)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "v1")
+}
+
+func (s *SnapTestSuite) TestRollbackCopiesMissingData(c *C) {
+	makeTwoTestSnaps(c, SnapTypeApp)
+	c.Assert(os.RemoveAll(filepath.Join(snapDataDir, "foo", "1.0")), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(snapDataDir, "foo", "2.0", "db"), []byte("v2"), 0644), IsNil)
+
+	_, err := Rollback("foo", "1.0", 0)
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadFile(filepath.Join(snapDataDir, "foo", "1.0", "db"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "v2")
+}
@@ -0,0 +1,747 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"launchpad.net/snappy/helpers"
+)
+
+// the seccomp policy that is used if the system does not ship its own,
+// the policies are lists of the syscalls that are allowed
+var builtinSeccompTemplates = map[string]string{
+	defaultSecurityTemplate: `# Description: Allows the syscalls of a basic runtime
+# Usage: common
+
+# files and directories
+access
+chdir
+chmod
+chown
+chown32
+close
+close_range
+creat
+dup
+dup2
+dup3
+faccessat
+faccessat2
+fadvise64
+fadvise64_64
+fallocate
+fchdir
+fchmod
+fchmodat
+fchmodat2
+fchown
+fchown32
+fchownat
+fcntl
+fcntl64
+fdatasync
+fgetxattr
+flistxattr
+flock
+fstat
+fstat64
+fstatat64
+fstatfs
+fstatfs64
+fsync
+ftruncate
+ftruncate64
+futimesat
+getcwd
+getdents
+getdents64
+getxattr
+inotify_add_watch
+inotify_init
+inotify_init1
+inotify_rm_watch
+ioctl
+lchown
+lchown32
+lgetxattr
+link
+linkat
+listxattr
+llistxattr
+_llseek
+lseek
+lstat
+lstat64
+mkdir
+mkdirat
+newfstatat
+open
+openat
+openat2
+pread64
+preadv
+preadv2
+pwrite64
+pwritev
+pwritev2
+read
+readahead
+readlink
+readlinkat
+readv
+rename
+renameat
+renameat2
+rmdir
+sendfile
+sendfile64
+copy_file_range
+splice
+stat
+stat64
+statfs
+statfs64
+statx
+symlink
+symlinkat
+sync
+sync_file_range
+syncfs
+tee
+truncate
+truncate64
+umask
+unlink
+unlinkat
+utime
+utimensat
+utimensat_time64
+utimes
+write
+writev
+
+# memory
+brk
+madvise
+memfd_create
+mincore
+membarrier
+mlock
+mlock2
+mmap
+mmap2
+mprotect
+mremap
+msync
+munlock
+munmap
+
+# processes and threads
+arch_prctl
+capget
+clone
+clone3
+execve
+execveat
+exit
+exit_group
+fork
+get_robust_list
+getcpu
+getegid
+getegid32
+geteuid
+geteuid32
+getgid
+getgid32
+getgroups
+getgroups32
+getpgid
+getpgrp
+getpid
+getppid
+pidfd_open
+pidfd_send_signal
+getpriority
+getresgid
+getresgid32
+getresuid
+getresuid32
+getrlimit
+getrusage
+getsid
+gettid
+getuid
+getuid32
+kill
+prctl
+prlimit64
+rseq
+sched_get_priority_max
+sched_get_priority_min
+sched_getaffinity
+sched_getparam
+sched_getscheduler
+sched_rr_get_interval
+sched_rr_get_interval_time64
+sched_yield
+set_robust_list
+set_thread_area
+set_tid_address
+setpgid
+setsid
+tgkill
+tkill
+ugetrlimit
+uname
+vfork
+wait4
+waitid
+waitpid
+
+# dropping privileges
+setfsgid
+setfsgid32
+setfsuid
+setfsuid32
+setgid
+setgid32
+setgroups
+setgroups32
+setregid
+setregid32
+setresgid
+setresgid32
+setresuid
+setresuid32
+setreuid
+setreuid32
+setuid
+setuid32
+
+# signals
+pause
+restart_syscall
+rt_sigaction
+rt_sigpending
+rt_sigprocmask
+rt_sigqueueinfo
+rt_sigreturn
+rt_sigsuspend
+rt_sigtimedwait
+rt_sigtimedwait_time64
+rt_tgsigqueueinfo
+sigaltstack
+signalfd
+signalfd4
+sigreturn
+
+# time
+alarm
+clock_getres
+clock_getres_time64
+clock_gettime
+clock_gettime64
+clock_nanosleep
+clock_nanosleep_time64
+getitimer
+gettimeofday
+nanosleep
+setitimer
+time
+timer_create
+timer_delete
+timer_getoverrun
+timer_gettime
+timer_gettime64
+timer_settime
+timer_settime64
+timerfd_create
+timerfd_gettime
+timerfd_gettime64
+timerfd_settime
+timerfd_settime64
+times
+
+# waiting for events
+_newselect
+epoll_create
+epoll_create1
+epoll_ctl
+epoll_pwait
+epoll_pwait2
+epoll_wait
+eventfd
+eventfd2
+futex
+futex_time64
+futex_waitv
+pipe
+pipe2
+poll
+ppoll
+ppoll_time64
+pselect6
+pselect6_time64
+select
+
+# local sockets
+connect
+getpeername
+getsockname
+getsockopt
+recv
+recvfrom
+recvmsg
+send
+sendmsg
+sendto
+setsockopt
+shutdown
+socket
+socketcall
+socketpair
+
+# shared memory and semaphores
+ipc
+semctl
+semget
+semop
+semtimedop
+semtimedop_time64
+shmat
+shmctl
+shmdt
+shmget
+
+# misc
+getrandom
+sysinfo
+
+# ARM private syscalls
+breakpoint
+cacheflush
+set_tls
+`,
+}
+
+// the seccomp policy groups (caps) that are used if the system does not
+// ship its own
+var builtinSeccompPolicyGroups = map[string]string{
+	defaultSecurityCap: `# Description: Can access the network as a client.
+# Usage: common
+accept
+accept4
+bind
+listen
+recvmmsg
+recvmmsg_time64
+sendmmsg
+`,
+}
+
+// a policy with this line does not restrict the syscalls at all
+const seccompUnrestricted = "@unrestricted"
+
+var validSyscallName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// seccompArch is the syscall table of an architecture the filters know
+type seccompArch struct {
+	auditArch     uint32
+	x32SyscallBit uint32
+	syscalls      map[string]uint32
+}
+
+// nativeSeccompArch returns the syscall table of the architecture snappy
+// runs on
+func nativeSeccompArch() seccompArch {
+	return seccompArch{
+		auditArch:     seccompAuditArch,
+		x32SyscallBit: seccompX32SyscallBit,
+		syscalls:      seccompSyscalls,
+	}
+}
+
+// see seccomp(2) and linux/filter.h
+const (
+	prSetNoNewPrivs   = 38
+	prSetSeccomp      = 22
+	seccompModeFilter = 2
+
+	seccompRetKill  = 0x00000000
+	seccompRetErrno = 0x00050000
+	seccompRetAllow = 0x7fff0000
+
+	// the offsets in struct seccomp_data
+	seccompDataNr   = 0
+	seccompDataArch = 4
+
+	bpfLoad = syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS
+	bpfJeq  = syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K
+	bpfJge  = syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K
+	bpfJa   = syscall.BPF_JMP | syscall.BPF_JA
+	bpfRet  = syscall.BPF_RET | syscall.BPF_K
+
+	// the size of a syscall.SockFilter
+	bpfInstructionSize = 8
+)
+
+// parseSeccompPolicy returns the syscalls of the given policy, it has
+// one syscall per line and comments start with "#"
+func parseSeccompPolicy(policy string) (syscalls []string, err error) {
+	scanner := bufio.NewScanner(strings.NewReader(policy))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line != seccompUnrestricted && !validSyscallName.MatchString(line) {
+			return nil, fmt.Errorf("invalid syscall %q", line)
+		}
+		syscalls = append(syscalls, line)
+	}
+
+	return syscalls, scanner.Err()
+}
+
+// readSeccompPolicyFile returns the syscalls of the policy in the file
+func readSeccompPolicyFile(policyFile string) ([]string, error) {
+	content, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	syscalls, err := parseSeccompPolicy(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", policyFile, err)
+	}
+
+	return syscalls, nil
+}
+
+// seccompAppSyscalls returns the syscalls the app of the snap in baseDir
+// may use, either the ones from its security-policy or the ones from its
+// security-template, caps and security-override
+func seccompAppSyscalls(baseDir string, app snapApp) ([]string, error) {
+	if app.SecurityPolicy != nil && app.SecurityPolicy.Seccomp != "" {
+		return readSeccompPolicyFile(filepath.Join(baseDir, app.SecurityPolicy.Seccomp))
+	}
+
+	templateName := app.SecurityTemplate
+	if templateName == "" {
+		templateName = defaultSecurityTemplate
+	}
+	template, ok, err := readSecurityPolicy(snapSeccompTemplatesDir, templateName, builtinSeccompTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &ErrUnknownSecurityTemplate{template: templateName}
+	}
+	syscalls, err := parseSeccompPolicy(template)
+	if err != nil {
+		return nil, fmt.Errorf("security-template %s: %s", templateName, err)
+	}
+
+	caps := app.SecurityCaps
+	if caps == nil {
+		caps = []string{defaultSecurityCap}
+	}
+	for _, cap := range caps {
+		policyGroup, ok, err := readSecurityPolicy(snapSeccompPolicyGroupsDir, cap, builtinSeccompPolicyGroups)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, &ErrUnknownCap{cap: cap}
+		}
+		more, err := parseSeccompPolicy(policyGroup)
+		if err != nil {
+			return nil, fmt.Errorf("cap %s: %s", cap, err)
+		}
+		syscalls = append(syscalls, more...)
+	}
+
+	if app.SecurityOverride != nil && app.SecurityOverride.Seccomp != "" {
+		more, err := readSeccompPolicyFile(filepath.Join(baseDir, app.SecurityOverride.Seccomp))
+		if err != nil {
+			return nil, err
+		}
+		syscalls = append(syscalls, more...)
+	}
+
+	return syscalls, nil
+}
+
+func bpfStmt(code uint16, k uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// sortedSyscallNumbers returns the numbers of the given set in order
+func sortedSyscallNumbers(set map[uint32]bool) []uint32 {
+	nrs := make([]int, 0, len(set))
+	for nr := range set {
+		nrs = append(nrs, int(nr))
+	}
+	sort.Ints(nrs)
+
+	sorted := make([]uint32, len(nrs))
+	for i, nr := range nrs {
+		sorted[i] = uint32(nr)
+	}
+
+	return sorted
+}
+
+// compileSeccompFilter returns the BPF program that allows the given
+// syscalls and makes all others fail with EPERM. The policy is shared by
+// all architectures, syscalls that one of them does not have are skipped.
+// Syscalls that are not in its table fail with ENOSYS, libc falls back to
+// the older syscalls then like it does on older kernels. Syscalls of
+// architectures other than the given ones are killed.
+func compileSeccompFilter(archs []seccompArch, syscalls []string) []syscall.SockFilter {
+	for _, name := range syscalls {
+		if name == seccompUnrestricted {
+			return []syscall.SockFilter{bpfStmt(bpfRet, seccompRetAllow)}
+		}
+	}
+
+	// the syscall numbers of other architectures mean something
+	// else, each architecture jumps to its own block of the filter
+	filter := []syscall.SockFilter{bpfStmt(bpfLoad, seccompDataArch)}
+	var blocks []syscall.SockFilter
+	for i, a := range archs {
+		// from the instruction after the jump, past the jumps of
+		// the other architectures and the kill, to the block
+		offset := 2*(len(archs)-i-1) + 1 + len(blocks)
+		filter = append(filter,
+			bpfJump(bpfJeq, a.auditArch, 0, 1),
+			bpfStmt(bpfJa, uint32(offset)))
+		blocks = append(blocks, compileSeccompArchFilter(a, syscalls)...)
+	}
+	filter = append(filter, bpfStmt(bpfRet, seccompRetKill))
+	filter = append(filter, blocks...)
+
+	return filter
+}
+
+// compileSeccompArchFilter returns the block of the filter for the
+// syscalls of the given architecture
+func compileSeccompArchFilter(a seccompArch, syscalls []string) []syscall.SockFilter {
+	allowed := make(map[uint32]bool)
+	for _, name := range syscalls {
+		if nr, ok := a.syscalls[name]; ok {
+			allowed[nr] = true
+		}
+	}
+	denied := make(map[uint32]bool)
+	for _, nr := range a.syscalls {
+		if !allowed[nr] {
+			denied[nr] = true
+		}
+	}
+
+	eperm := seccompRetErrno | uint32(syscall.EPERM)
+	enosys := seccompRetErrno | uint32(syscall.ENOSYS)
+	filter := []syscall.SockFilter{bpfStmt(bpfLoad, seccompDataNr)}
+	if a.x32SyscallBit != 0 {
+		filter = append(filter,
+			bpfJump(bpfJge, a.x32SyscallBit, 0, 1),
+			bpfStmt(bpfRet, eperm))
+	}
+	for _, nr := range sortedSyscallNumbers(allowed) {
+		filter = append(filter,
+			bpfJump(bpfJeq, nr, 0, 1),
+			bpfStmt(bpfRet, seccompRetAllow))
+	}
+	for _, nr := range sortedSyscallNumbers(denied) {
+		filter = append(filter,
+			bpfJump(bpfJeq, nr, 0, 1),
+			bpfStmt(bpfRet, eperm))
+	}
+
+	return append(filter, bpfStmt(bpfRet, enosys))
+}
+
+func snapHasArchitecture(m *packageYaml, arch ArchitectureType) bool {
+	for _, a := range m.Architectures {
+		if ArchitectureType(a) == arch {
+			return true
+		}
+	}
+
+	return false
+}
+
+// seccompSnapArchs returns the syscall tables the filters of the snap
+// need, the native one and the ones of the foreign architectures the snap
+// is built for. The native one is needed by foreign snaps too, they run
+// the binaries of the system.
+func seccompSnapArchs(m *packageYaml) ([]seccompArch, error) {
+	archs := []seccompArch{nativeSeccompArch()}
+
+	foreign, err := foreignArchitectures()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range foreign {
+		if !snapHasArchitecture(m, f) {
+			continue
+		}
+		a, ok := seccompForeignArchs[f]
+		if !ok {
+			return nil, fmt.Errorf("can not confine %s snaps on %s, there is no seccomp syscall table for them", f, Architecture())
+		}
+		archs = append(archs, a)
+	}
+
+	return archs, nil
+}
+
+// seccompSupported returns whether there is a syscall table for this
+// architecture, the filters can not be compiled without one
+func seccompSupported() bool {
+	return len(seccompSyscalls) > 0
+}
+
+// seccompFilterFile returns the file the compiled filter of the app is
+// written to
+func seccompFilterFile(appID string) string {
+	return filepath.Join(snapSeccompFiltersDir, appID)
+}
+
+func writeSeccompFilter(filterFile string, filter []syscall.SockFilter) error {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, filter); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(filterFile, buf.Bytes(), 0644)
+}
+
+func readSeccompFilter(filterFile string) ([]syscall.SockFilter, error) {
+	content, err := ioutil.ReadFile(filterFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 || len(content)%bpfInstructionSize != 0 {
+		return nil, fmt.Errorf("%s: invalid seccomp filter", filterFile)
+	}
+
+	filter := make([]syscall.SockFilter, len(content)/bpfInstructionSize)
+	if err := binary.Read(bytes.NewReader(content), binary.LittleEndian, filter); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// loadSeccompFilter sets no_new_privs and loads the filter, both only
+// apply to the current thread and the processes it execs, so the caller
+// needs to runtime.LockOSThread()
+func loadSeccompFilter(filter []syscall.SockFilter) error {
+	if len(filter) == 0 {
+		return fmt.Errorf("empty seccomp filter")
+	}
+	prog := syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("prctl(PR_SET_NO_NEW_PRIVS) failed: %s", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fmt.Errorf("prctl(PR_SET_SECCOMP) failed: %s", errno)
+	}
+
+	return nil
+}
+
+// addPackageSeccompFilters compiles and writes the filters of the apps of
+// the snap in baseDir
+func addPackageSeccompFilters(baseDir string) error {
+	if !seccompSupported() {
+		return nil
+	}
+
+	m, err := parsePackageYamlFile(filepath.Join(baseDir, "meta", "package.yaml"))
+	if err != nil {
+		return err
+	}
+
+	if err := helpers.EnsureDir(snapSeccompFiltersDir, 0755); err != nil {
+		return err
+	}
+
+	archs, err := seccompSnapArchs(m)
+	if err != nil {
+		return err
+	}
+
+	for _, app := range snapApps(m, baseDir) {
+		syscalls, err := seccompAppSyscalls(baseDir, app)
+		if err != nil {
+			return err
+		}
+
+		filterFile := seccompFilterFile(snapAppID(m, app.name))
+		if err := writeSeccompFilter(filterFile, compileSeccompFilter(archs, syscalls)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removePackageSeccompFilters removes the filters of the apps of the snap
+// in baseDir
+func removePackageSeccompFilters(baseDir string) error {
+	m, err := parsePackageYamlFile(filepath.Join(baseDir, "meta", "package.yaml"))
+	if err != nil {
+		return err
+	}
+
+	for _, app := range snapApps(m, baseDir) {
+		if err := os.Remove(seccompFilterFile(snapAppID(m, app.name))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
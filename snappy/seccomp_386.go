@@ -0,0 +1,28 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+const seccompAuditArch = seccompAuditArchI386
+
+// only x86_64 has x32 syscalls
+const seccompX32SyscallBit = 0
+
+var seccompSyscalls = seccompSyscallsI386
+
+// there is no other architecture whose binaries i386 runs
+var seccompForeignArchs map[ArchitectureType]seccompArch
@@ -0,0 +1,350 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"launchpad.net/snappy/helpers"
+
+	. "launchpad.net/gocheck"
+)
+
+const seccompPackageYaml = `name: foo
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+binaries:
+ - name: bin/foo
+ - name: bin/bar
+   security-policy:
+     seccomp: meta/bar.seccomp
+services:
+ - name: svc
+   start: bin/foo
+   caps: []
+   security-override:
+     seccomp: meta/svc.seccomp
+`
+
+// the syscall the helper process is not allowed to use
+const seccompTestDeniedSyscall = "getppid"
+
+// when the test binary is started with this set it runs
+// seccompTestHelper with the value of it instead of the tests
+const seccompTestHelperEnv = "SNAPPY_SECCOMP_TEST_HELPER"
+
+func init() {
+	if helper := os.Getenv(seccompTestHelperEnv); helper != "" {
+		seccompTestHelper(helper)
+		os.Exit(0)
+	}
+}
+
+// seccompTestHelper loads a filter and runs the given test under it
+func seccompTestHelper(helper string) {
+	var syscalls []string
+	switch helper {
+	case "eperm":
+		// everything but seccompTestDeniedSyscall
+		for name := range seccompSyscalls {
+			if name != seccompTestDeniedSyscall {
+				syscalls = append(syscalls, name)
+			}
+		}
+	case "default":
+		var err error
+		if syscalls, err = seccompAppSyscalls("", snapApp{name: "test"}); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	runtime.LockOSThread()
+	if err := loadSeccompFilter(compileSeccompFilter([]seccompArch{nativeSeccompArch()}, syscalls)); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	switch helper {
+	case "eperm":
+		_, _, errno := syscall.RawSyscall(uintptr(seccompSyscalls[seccompTestDeniedSyscall]), 0, 0, 0)
+		fmt.Printf("errno %d", errno)
+	case "default":
+		// the filter is inherited, so libc and coreutils of the
+		// system fork and stat under it
+		output, err := exec.Command("/bin/sh", "-c", "stat -c %n / && (ls -d /)").CombinedOutput()
+		fmt.Printf("%s%v", output, err)
+	}
+}
+
+// runSeccompFilter returns the result of the filter for the given syscall,
+// it only knows the instructions compileSeccompFilter uses
+func runSeccompFilter(c *C, filter []syscall.SockFilter, arch, nr uint32) uint32 {
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		insn := filter[pc]
+		switch insn.Code {
+		case bpfLoad:
+			switch insn.K {
+			case seccompDataNr:
+				acc = nr
+			case seccompDataArch:
+				acc = arch
+			default:
+				c.Fatalf("unexpected load of offset %d", insn.K)
+			}
+		case bpfJeq:
+			if acc == insn.K {
+				pc += int(insn.Jt)
+			} else {
+				pc += int(insn.Jf)
+			}
+		case bpfJge:
+			if acc >= insn.K {
+				pc += int(insn.Jt)
+			} else {
+				pc += int(insn.Jf)
+			}
+		case bpfJa:
+			pc += int(insn.K)
+		case bpfRet:
+			return insn.K
+		default:
+			c.Fatalf("unexpected instruction %#v", insn)
+		}
+	}
+
+	c.Fatalf("filter does not return")
+	return 0
+}
+
+func (s *SnapTestSuite) TestParseSeccompPolicy(c *C) {
+	syscalls, err := parseSeccompPolicy("# Description: test\nopen\n\n  read # for reading\n")
+	c.Assert(err, IsNil)
+	c.Assert(syscalls, DeepEquals, []string{"open", "read"})
+
+	_, err = parseSeccompPolicy("open read\n")
+	c.Assert(err, ErrorMatches, `invalid syscall "open read"`)
+}
+
+func (s *SnapTestSuite) TestCompileSeccompFilter(c *C) {
+	filter := compileSeccompFilter([]seccompArch{nativeSeccompArch()}, []string{"read", "write", "read", "no_such_syscall"})
+	eperm := seccompRetErrno | uint32(syscall.EPERM)
+
+	c.Assert(runSeccompFilter(c, filter, seccompAuditArch, seccompSyscalls["read"]), Equals, uint32(seccompRetAllow))
+	c.Assert(runSeccompFilter(c, filter, seccompAuditArch, seccompSyscalls["write"]), Equals, uint32(seccompRetAllow))
+	c.Assert(runSeccompFilter(c, filter, seccompAuditArch, seccompSyscalls["getppid"]), Equals, eperm)
+	// syscalls that are newer than the table are not known to libc
+	// either, it falls back to the older ones on ENOSYS
+	c.Assert(runSeccompFilter(c, filter, seccompAuditArch, 0x3fff0000), Equals, seccompRetErrno|uint32(syscall.ENOSYS))
+	// syscalls of other architectures are killed
+	c.Assert(runSeccompFilter(c, filter, seccompAuditArch+1, seccompSyscalls["read"]), Equals, uint32(seccompRetKill))
+	if seccompX32SyscallBit != 0 {
+		c.Assert(runSeccompFilter(c, filter, seccompAuditArch, seccompX32SyscallBit|seccompSyscalls["read"]), Equals, eperm)
+	}
+
+	filter = compileSeccompFilter([]seccompArch{nativeSeccompArch()}, []string{"read", seccompUnrestricted})
+	c.Assert(runSeccompFilter(c, filter, seccompAuditArch, seccompSyscalls["getppid"]), Equals, uint32(seccompRetAllow))
+}
+
+func (s *SnapTestSuite) TestSeccompFilterFile(c *C) {
+	filterFile := filepath.Join(c.MkDir(), "foo_bar_1.0")
+	filter := compileSeccompFilter([]seccompArch{nativeSeccompArch()}, []string{"read", "write"})
+
+	c.Assert(writeSeccompFilter(filterFile, filter), IsNil)
+	read, err := readSeccompFilter(filterFile)
+	c.Assert(err, IsNil)
+	c.Assert(read, DeepEquals, filter)
+
+	c.Assert(ioutil.WriteFile(filterFile, []byte("garbage"), 0644), IsNil)
+	_, err = readSeccompFilter(filterFile)
+	c.Assert(err, ErrorMatches, ".*: invalid seccomp filter")
+}
+
+func (s *SnapTestSuite) TestSeccompAppSyscalls(c *C) {
+	baseDir := c.MkDir()
+	s.writeAppArmorPolicy(c, snapSeccompTemplatesDir, "test", "read\nwrite\n")
+	s.writeAppArmorPolicy(c, snapSeccompPolicyGroupsDir, "video", "ioctl\n")
+	s.writeAppArmorPolicy(c, filepath.Join(baseDir, "meta"), "svc.seccomp", "# more\nmount\n")
+	s.writeAppArmorPolicy(c, filepath.Join(baseDir, "meta"), "bar.seccomp", "@unrestricted\n")
+
+	syscalls, err := seccompAppSyscalls(baseDir, snapApp{name: "svc", SecurityDefinitions: SecurityDefinitions{
+		SecurityTemplate: "test",
+		SecurityCaps:     []string{"video"},
+		SecurityOverride: &SecurityOverrideDefinition{Seccomp: "meta/svc.seccomp"},
+	}})
+	c.Assert(err, IsNil)
+	c.Assert(syscalls, DeepEquals, []string{"read", "write", "ioctl", "mount"})
+
+	syscalls, err = seccompAppSyscalls(baseDir, snapApp{name: "bar", SecurityDefinitions: SecurityDefinitions{
+		SecurityPolicy: &SecurityPolicyDefinition{Seccomp: "meta/bar.seccomp"},
+	}})
+	c.Assert(err, IsNil)
+	c.Assert(syscalls, DeepEquals, []string{seccompUnrestricted})
+
+	// the builtin default gets the builtin network-client cap
+	syscalls, err = seccompAppSyscalls(baseDir, snapApp{name: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Join(syscalls, " "), Matches, ".*\\bexecve\\b.*\\baccept4\\b.*")
+	for _, name := range syscalls {
+		c.Check(name, Not(Equals), "ptrace")
+	}
+
+	_, err = seccompAppSyscalls(baseDir, snapApp{name: "foo", SecurityDefinitions: SecurityDefinitions{SecurityTemplate: "no-such-template"}})
+	c.Assert(err, ErrorMatches, `unknown security-template "no-such-template"`)
+	_, err = seccompAppSyscalls(baseDir, snapApp{name: "foo", SecurityDefinitions: SecurityDefinitions{SecurityCaps: []string{"no-such-cap"}}})
+	c.Assert(err, ErrorMatches, `unknown cap "no-such-cap"`)
+}
+
+func (s *SnapTestSuite) TestSeccompFiltersOnInstallAndRemove(c *C) {
+	sourceDir := makeExampleSnapSourceDir(c, seccompPackageYaml)
+	c.Assert(ioutil.WriteFile(filepath.Join(sourceDir, "meta", "bar.seccomp"), []byte("read\n"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(sourceDir, "meta", "svc.seccomp"), []byte("mount\n"), 0644), IsNil)
+	var snapFile string
+	err := helpers.ChDir(sourceDir, func() {
+		var err error
+		snapFile, err = Build(sourceDir, "")
+		c.Assert(err, IsNil)
+	})
+	c.Assert(err, IsNil)
+	c.Assert(installClick(filepath.Join(sourceDir, snapFile), 0, nil), IsNil)
+
+	filters := make(map[string][]syscall.SockFilter)
+	for _, app := range []string{"svc", "foo", "bar"} {
+		filter, err := readSeccompFilter(filepath.Join(snapSeccompFiltersDir, "foo_"+app+"_1.0"))
+		c.Assert(err, IsNil)
+		filters[app] = filter
+	}
+	allowed := func(app, name string) bool {
+		return runSeccompFilter(c, filters[app], seccompAuditArch, seccompSyscalls[name]) == seccompRetAllow
+	}
+	c.Check(allowed("svc", "mount"), Equals, true)
+	c.Check(allowed("svc", "accept4"), Equals, false)
+	c.Check(allowed("foo", "accept4"), Equals, true)
+	c.Check(allowed("foo", "mount"), Equals, false)
+	c.Check(allowed("bar", "read"), Equals, true)
+	c.Check(allowed("bar", "write"), Equals, false)
+
+	c.Assert(removeClick(filepath.Join(snapAppsDir, "foo", "1.0")), IsNil)
+	for _, app := range []string{"svc", "foo", "bar"} {
+		c.Assert(helpers.FileExists(filepath.Join(snapSeccompFiltersDir, "foo_"+app+"_1.0")), Equals, false)
+	}
+}
+
+func (s *SnapTestSuite) installForeignSeccompSnap(c *C, foreign string) error {
+	c.Assert(os.MkdirAll(filepath.Dir(snappyArchitecturesConfig), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(snappyArchitecturesConfig, []byte("foreign-architectures: ["+foreign+"]\n"), 0644), IsNil)
+
+	sourceDir := makeExampleSnapSourceDir(c, seccompPackageYaml+"architecture: ["+foreign+"]\n")
+	c.Assert(ioutil.WriteFile(filepath.Join(sourceDir, "meta", "bar.seccomp"), []byte("read\n"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(sourceDir, "meta", "svc.seccomp"), []byte("mount\n"), 0644), IsNil)
+	var snapFile string
+	err := helpers.ChDir(sourceDir, func() {
+		var err error
+		snapFile, err = Build(sourceDir, "")
+		c.Assert(err, IsNil)
+	})
+	c.Assert(err, IsNil)
+
+	return installClick(filepath.Join(sourceDir, snapFile), 0, nil)
+}
+
+func (s *SnapTestSuite) TestSeccompFilterI386OnAmd64(c *C) {
+	i386, ok := seccompForeignArchs[Archi386]
+	if !ok || Architecture() != ArchAmd64 {
+		c.Skip("i386 snaps only run on amd64")
+	}
+	c.Assert(s.installForeignSeccompSnap(c, "i386"), IsNil)
+
+	filter, err := readSeccompFilter(filepath.Join(snapSeccompFiltersDir, "foo_foo_1.0"))
+	c.Assert(err, IsNil)
+	eperm := seccompRetErrno | uint32(syscall.EPERM)
+
+	// the i386 binaries of the snap
+	c.Check(runSeccompFilter(c, filter, i386.auditArch, i386.syscalls["accept4"]), Equals, uint32(seccompRetAllow))
+	c.Check(runSeccompFilter(c, filter, i386.auditArch, i386.syscalls["socketcall"]), Equals, uint32(seccompRetAllow))
+	c.Check(runSeccompFilter(c, filter, i386.auditArch, i386.syscalls["mount"]), Equals, eperm)
+	// and the amd64 binaries of the system they run
+	c.Check(runSeccompFilter(c, filter, seccompAuditArch, seccompSyscalls["accept4"]), Equals, uint32(seccompRetAllow))
+	c.Check(runSeccompFilter(c, filter, seccompAuditArch, seccompSyscalls["mount"]), Equals, eperm)
+	c.Check(runSeccompFilter(c, filter, seccompAuditArch+1, seccompSyscalls["accept4"]), Equals, uint32(seccompRetKill))
+}
+
+func (s *SnapTestSuite) TestSeccompFilterForeignArchitectureWithoutTable(c *C) {
+	if _, ok := seccompForeignArchs[ArchArmhf]; ok {
+		c.Skip("armhf snaps can be confined here")
+	}
+
+	err := s.installForeignSeccompSnap(c, "armhf")
+	c.Assert(err, ErrorMatches, "can not confine armhf snaps on .*, there is no seccomp syscall table for them")
+}
+
+func (s *SnapTestSuite) TestLoadSeccompFilterDeniesWithEPERM(c *C) {
+	output := runSeccompTestHelper(c, "eperm")
+	c.Assert(output, Equals, fmt.Sprintf("errno %d", syscall.EPERM))
+}
+
+func (s *SnapTestSuite) TestDefaultSeccompFilterForkAndStat(c *C) {
+	output := runSeccompTestHelper(c, "default")
+	c.Assert(output, Equals, "/\n/\n<nil>")
+}
+
+func runSeccompTestHelper(c *C, helper string) string {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), seccompTestHelperEnv+"="+helper)
+	output, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", output))
+
+	return string(output)
+}
+
+func (s *SnapTestSuite) TestRunConfinedInvalid(c *C) {
+	c.Assert(RunConfined("../foo_bar_1.0", []string{"true"}), ErrorMatches, `invalid app id "../foo_bar_1.0"`)
+	c.Assert(RunConfined("foo_bar_1.0", nil), ErrorMatches, "no command to run for foo_bar_1.0")
+	err := RunConfined("foo_bar_1.0", []string{"true"})
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SnapTestSuite) TestRunConfinedWithoutAppArmor(c *C) {
+	c.Assert(os.MkdirAll(snapSeccompFiltersDir, 0755), IsNil)
+	c.Assert(writeSeccompFilter(seccompFilterFile("foo_bar_1.0"), compileSeccompFilter([]seccompArch{nativeSeccompArch()}, []string{seccompUnrestricted})), IsNil)
+	appArmorSecurityFS = filepath.Join(s.tempdir, "no-apparmor")
+
+	err := RunConfined("foo_bar_1.0", []string{"true"})
+	c.Assert(err, ErrorMatches, `apparmor is not enabled, refusing to run foo_bar_1.0 unconfined`)
+}
+
+func (s *SnapTestSuite) TestRunConfinedUnsupportedArch(c *C) {
+	syscalls := seccompSyscalls
+	seccompSyscalls = nil
+	defer func() { seccompSyscalls = syscalls }()
+
+	err := RunConfined("foo_bar_1.0", []string{"true"})
+	c.Assert(err, ErrorMatches, `seccomp is not supported on .*, refusing to run foo_bar_1.0 unconfined`)
+
+	// no filters are written that could not be loaded anyway
+	c.Assert(addPackageSeccompFilters(makeExampleSnapSourceDir(c, seccompPackageYaml)), IsNil)
+	c.Assert(helpers.FileExists(seccompFilterFile("foo_bar_1.0")), Equals, false)
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"launchpad.net/snappy/helpers"
+)
+
+// the confinement of apps that do not ask for anything else
+const (
+	defaultSecurityTemplate = "default"
+	defaultSecurityCap      = "network-client"
+)
+
+// snapApp is a service, binary or hook of a snap, each app is confined
+// by its own apparmor profile and seccomp filter
+type snapApp struct {
+	name string
+	SecurityDefinitions
+}
+
+// snapAppID returns the APP_ID of the given app of the snap, this is the
+// name of its apparmor profile and seccomp filter
+func snapAppID(m *packageYaml, appName string) string {
+	return fmt.Sprintf("%s_%s_%s", m.Name, appName, m.Version)
+}
+
+// snapApps returns the apps of the snap in baseDir
+func snapApps(m *packageYaml, baseDir string) (apps []snapApp) {
+	for _, service := range m.Services {
+		apps = append(apps, snapApp{name: service.Name, SecurityDefinitions: service.SecurityDefinitions})
+	}
+	for _, binary := range m.Binaries {
+		apps = append(apps, snapApp{name: filepath.Base(binary.Name), SecurityDefinitions: binary.SecurityDefinitions})
+	}
+	for _, hook := range snapHooks {
+		if helpers.FileExists(filepath.Join(baseDir, "meta", "hooks", hook)) {
+			apps = append(apps, snapApp{name: snapHookAppArmorName(hook)})
+		}
+	}
+
+	return apps
+}
+
+// readSecurityPolicy reads the template or policy group with the given
+// name from dir, if the system does not have it the builtin one is used
+func readSecurityPolicy(dir, name string, builtin map[string]string) (string, bool, error) {
+	// names are not paths
+	if name == "" || filepath.Base(name) != name {
+		return "", false, nil
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		policy, ok := builtin[name]
+		return policy, ok, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(content), true, nil
+}
@@ -0,0 +1,367 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SnapService is a service of an active snap
+type SnapService struct {
+	m       *packageYaml
+	service Service
+}
+
+// Snap returns the name of the snap the service belongs to
+func (s *SnapService) Snap() string {
+	return s.m.Name
+}
+
+// Version returns the version of the snap the service belongs to
+func (s *SnapService) Version() string {
+	return s.m.Version
+}
+
+// Name returns the name of the service inside the snap
+func (s *SnapService) Name() string {
+	return s.service.Name
+}
+
+// FullName returns the name of the service as $snap.$service, this is
+// the name the user knows the service by
+func (s *SnapService) FullName() string {
+	return s.m.Name + "." + s.service.Name
+}
+
+// Unit returns the name of the systemd unit of the service
+func (s *SnapService) Unit() string {
+	return filepath.Base(generateServiceFileName(s.m, s.service))
+}
+
+func (s *SnapService) systemctl(cmd string) error {
+	_, err := runSystemctl(cmd, s.Unit())
+	return err
+}
+
+// Start starts the service
+func (s *SnapService) Start() error {
+	return s.systemctl("start")
+}
+
+// Stop stops the service and waits until it is stopped, if it does not
+// stop in time it is killed and a ErrServiceStopTimeout is returned
+func (s *SnapService) Stop() error {
+	return stopService(s.m, s.service)
+}
+
+// Restart restarts the service
+func (s *SnapService) Restart() error {
+	return s.systemctl("restart")
+}
+
+// Enable makes the service start on boot
+func (s *SnapService) Enable() error {
+	return s.systemctl("enable")
+}
+
+// Disable makes the service no longer start on boot
+func (s *SnapService) Disable() error {
+	return s.systemctl("disable")
+}
+
+// ServiceStatus is the state of a snap service as systemd sees it
+type ServiceStatus struct {
+	Name    string `yaml:"name"`
+	Unit    string `yaml:"unit"`
+	Enabled bool   `yaml:"enabled"`
+	// e.g. active, inactive, failed
+	Active string `yaml:"active"`
+	// e.g. running, dead, exited
+	Sub string `yaml:"sub"`
+}
+
+// Status returns the state of the service
+func (s *SnapService) Status() (*ServiceStatus, error) {
+	props, err := systemctlShow(s.Unit(), "ActiveState", "SubState", "UnitFileState")
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceStatus{
+		Name:    s.FullName(),
+		Unit:    s.Unit(),
+		Enabled: props["UnitFileState"] == "enabled",
+		Active:  props["ActiveState"],
+		Sub:     props["SubState"],
+	}, nil
+}
+
+// systemctlShow returns the given properties of the unit
+func systemctlShow(unit string, properties ...string) (map[string]string, error) {
+	output, err := runSystemctl("show", "-p", strings.Join(properties, ","), unit)
+	if err != nil {
+		return nil, err
+	}
+
+	props := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		l := strings.SplitN(scanner.Text(), "=", 2)
+		if len(l) == 2 {
+			props[l[0]] = l[1]
+		}
+	}
+
+	return props, scanner.Err()
+}
+
+// the time a service gets to stop if its stop-timeout is not set
+const defaultStopTimeout = 30 * time.Second
+
+// how often the state of a stopping service is checked
+var stopPollInterval = 100 * time.Millisecond
+
+// the time a killed service gets to go away
+var killStopTimeout = 5 * time.Second
+
+// parseStopTimeout parses a stop-timeout, which is either in seconds or
+// a duration like "1m30s"
+func parseStopTimeout(timeout string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(timeout); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, nil
+	}
+	if d, err := time.ParseDuration(timeout); err == nil && d >= 0 {
+		return d, nil
+	}
+
+	return 0, fmt.Errorf("invalid timeout %q", timeout)
+}
+
+// serviceStopTimeout returns the time the service gets to stop
+func serviceStopTimeout(service Service) time.Duration {
+	if service.StopTimeout == "" {
+		return defaultStopTimeout
+	}
+
+	timeout, err := parseStopTimeout(service.StopTimeout)
+	if err != nil {
+		log.Printf("WARNING: %s of service %s, using %s", err, service.Name, defaultStopTimeout)
+		return defaultStopTimeout
+	}
+
+	return timeout
+}
+
+// serviceStopped returns true if the unit is no longer running
+func serviceStopped(unit string) (bool, error) {
+	props, err := systemctlShow(unit, "ActiveState")
+	if err != nil {
+		return false, err
+	}
+
+	switch props["ActiveState"] {
+	case "inactive", "failed":
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// waitServiceStopped polls the unit until it is stopped, for at most the
+// given time, and returns whether it stopped
+func waitServiceStopped(unit string, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		stopped, err := serviceStopped(unit)
+		if err != nil || stopped {
+			return stopped, err
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		time.Sleep(stopPollInterval)
+	}
+}
+
+// stopService stops the service and waits until it is stopped, for at
+// most its stop timeout. Services that do not stop in time are killed,
+// once they are gone a ErrServiceStopTimeout is returned.
+func stopService(m *packageYaml, service Service) error {
+	unit := filepath.Base(generateServiceFileName(m, service))
+	// a blocking stop only returns after systemd applied its own
+	// timeout, the deadline is enforced here instead
+	if _, err := runSystemctl("stop", "--no-block", unit); err != nil {
+		return err
+	}
+
+	timeout := serviceStopTimeout(service)
+	stopped, err := waitServiceStopped(unit, timeout)
+	if err != nil || stopped {
+		return err
+	}
+
+	if _, err := runSystemctl("kill", "--signal=SIGKILL", unit); err != nil {
+		return err
+	}
+	stopped, err = waitServiceStopped(unit, killStopTimeout)
+	if err != nil {
+		return err
+	}
+	if !stopped {
+		return &ErrServiceNotStopped{unit: unit}
+	}
+
+	return &ErrServiceStopTimeout{unit: unit, timeout: timeout}
+}
+
+// serviceEnabled returns whether the unit is enabled, systemctl is-enabled
+// fails with exit status 1 if it is not
+func serviceEnabled(unit string) (bool, error) {
+	_, err := runSystemctl("is-enabled", unit)
+	if e, ok := err.(*ErrSystemCtl); ok && e.exitCode == 1 {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// disabledSnapServices returns the names of the services of the snap in
+// the given dir that are disabled, e.g. with "snappy service disable"
+func disabledSnapServices(baseDir string) (disabled []string, err error) {
+	m, err := parsePackageYamlFile(filepath.Join(baseDir, "meta", "package.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, service := range m.Services {
+		enabled, err := serviceEnabled(filepath.Base(generateServiceFileName(m, service)))
+		if err != nil {
+			return nil, err
+		}
+		if !enabled {
+			disabled = append(disabled, service.Name)
+		}
+	}
+
+	return disabled, nil
+}
+
+// stopSnapServices stops all services of the snap in the given dir
+func stopSnapServices(baseDir string) error {
+	m, err := parsePackageYamlFile(filepath.Join(baseDir, "meta", "package.yaml"))
+	if err != nil {
+		return err
+	}
+
+	for _, service := range m.Services {
+		if err := stopService(m, service); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// startSnapServices starts all services of the snap in the given dir
+func startSnapServices(baseDir string) error {
+	m, err := parsePackageYamlFile(filepath.Join(baseDir, "meta", "package.yaml"))
+	if err != nil {
+		return err
+	}
+
+	for _, service := range m.Services {
+		if _, err := runSystemctl("start", filepath.Base(generateServiceFileName(m, service))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snapServices returns the services of the active snap
+func snapServices(part *SnapPart) (services []*SnapService) {
+	for _, service := range part.m.Services {
+		services = append(services, &SnapService{m: part.m, service: service})
+	}
+
+	return services
+}
+
+// activeSnapPart returns the active version of the given snap or nil
+func activeSnapPart(active []Part, name string) *SnapPart {
+	for _, part := range active {
+		if snap, ok := part.(*SnapPart); ok && snap.Name() == name {
+			return snap
+		}
+	}
+
+	return nil
+}
+
+// FindServices returns the services of the active snaps that match the
+// given spec. The spec is empty for all services, the name of a snap for
+// all services of that snap or $snap.$service for a single service. It
+// returns ErrServiceNotFound if a snap that is named has no matching
+// service.
+func FindServices(spec string) (services []*SnapService, err error) {
+	active, err := InstalledSnapsByType(SnapTypeApp, SnapTypeFramework, SnapTypeOem)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec == "" {
+		for _, part := range active {
+			if snap, ok := part.(*SnapPart); ok {
+				services = append(services, snapServices(snap)...)
+			}
+		}
+		return services, nil
+	}
+
+	if snap := activeSnapPart(active, spec); snap != nil {
+		services = snapServices(snap)
+		if len(services) == 0 {
+			return nil, ErrServiceNotFound
+		}
+		return services, nil
+	}
+
+	// snap names may contain dots too, so try all the ways to split
+	// the spec into $snap.$service
+	for i := strings.LastIndex(spec, "."); i > 0; i = strings.LastIndex(spec[:i], ".") {
+		snap := activeSnapPart(active, spec[:i])
+		if snap == nil {
+			continue
+		}
+		for _, service := range snapServices(snap) {
+			if service.Name() == spec[i+1:] {
+				return []*SnapService{service}, nil
+			}
+		}
+		return nil, ErrServiceNotFound
+	}
+
+	return nil, ErrPackageNotFound
+}
@@ -0,0 +1,333 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"launchpad.net/snappy/helpers"
+
+	. "launchpad.net/gocheck"
+)
+
+const servicesPackageYaml = `name: %s
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: svc1
+   start: bin/foo
+ - name: svc2
+   start: bin/foo
+`
+
+func (s *SnapTestSuite) installServiceSnaps(c *C) {
+	for _, name := range []string{"foo", "foo.bar"} {
+		snapFile := makeTestSnapPackage(c, fmt.Sprintf(servicesPackageYaml, name))
+		c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+	}
+}
+
+func serviceNames(services []*SnapService) (names []string) {
+	for _, service := range services {
+		names = append(names, service.FullName())
+	}
+
+	return names
+}
+
+func (s *SnapTestSuite) TestFindServices(c *C) {
+	s.installServiceSnaps(c)
+
+	services, err := FindServices("")
+	c.Assert(err, IsNil)
+	c.Assert(serviceNames(services), HasLen, 4)
+
+	services, err = FindServices("foo")
+	c.Assert(err, IsNil)
+	c.Assert(serviceNames(services), DeepEquals, []string{"foo.svc1", "foo.svc2"})
+	c.Assert(services[0].Unit(), Equals, "foo_svc1_1.0.service")
+
+	services, err = FindServices("foo.svc2")
+	c.Assert(err, IsNil)
+	c.Assert(serviceNames(services), DeepEquals, []string{"foo.svc2"})
+
+	services, err = FindServices("foo.bar.svc1")
+	c.Assert(err, IsNil)
+	c.Assert(serviceNames(services), DeepEquals, []string{"foo.bar.svc1"})
+	c.Assert(services[0].Snap(), Equals, "foo.bar")
+	c.Assert(services[0].Unit(), Equals, "foo.bar_svc1_1.0.service")
+}
+
+func (s *SnapTestSuite) TestFindServicesNotFound(c *C) {
+	s.installServiceSnaps(c)
+
+	_, err := FindServices("foo.svc3")
+	c.Assert(err, Equals, ErrServiceNotFound)
+
+	_, err = FindServices("baz.svc1")
+	c.Assert(err, Equals, ErrPackageNotFound)
+
+	_, err = FindServices("baz")
+	c.Assert(err, Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestFindServicesSnapWithoutServices(c *C) {
+	snapFile := makeTestSnapPackage(c, "")
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	_, err := FindServices("foo")
+	c.Assert(err, Equals, ErrServiceNotFound)
+}
+
+func (s *SnapTestSuite) TestServiceActions(c *C) {
+	s.installServiceSnaps(c)
+
+	var cmds [][]string
+	runSystemctl = func(cmd ...string) ([]byte, error) {
+		cmds = append(cmds, cmd)
+		if cmd[0] == "show" {
+			return []byte("ActiveState=inactive\n"), nil
+		}
+		return nil, nil
+	}
+
+	services, err := FindServices("foo.svc1")
+	c.Assert(err, IsNil)
+	service := services[0]
+	c.Assert(service.Start(), IsNil)
+	c.Assert(service.Stop(), IsNil)
+	c.Assert(service.Restart(), IsNil)
+	c.Assert(service.Enable(), IsNil)
+	c.Assert(service.Disable(), IsNil)
+
+	unit := "foo_svc1_1.0.service"
+	c.Assert(cmds, DeepEquals, [][]string{
+		{"start", unit},
+		{"stop", "--no-block", unit},
+		{"show", "-p", "ActiveState", unit},
+		{"restart", unit},
+		{"enable", unit},
+		{"disable", unit},
+	})
+}
+
+func (s *SnapTestSuite) TestServiceStatus(c *C) {
+	s.installServiceSnaps(c)
+
+	var showArgs []string
+	runSystemctl = func(cmd ...string) ([]byte, error) {
+		showArgs = cmd
+		return []byte("ActiveState=active\nSubState=running\nUnitFileState=enabled\n"), nil
+	}
+
+	services, err := FindServices("foo.svc2")
+	c.Assert(err, IsNil)
+	st, err := services[0].Status()
+	c.Assert(err, IsNil)
+	c.Assert(st, DeepEquals, &ServiceStatus{
+		Name:    "foo.svc2",
+		Unit:    "foo_svc2_1.0.service",
+		Enabled: true,
+		Active:  "active",
+		Sub:     "running",
+	})
+	c.Assert(showArgs, DeepEquals, []string{"show", "-p", "ActiveState,SubState,UnitFileState", "foo_svc2_1.0.service"})
+}
+
+// fakeStoppingSystemctl returns a fake runSystemctl for a unit that is
+// deactivating for the given number of checks, until it is killed if it
+// is < 0
+func fakeStoppingSystemctl(cmds *[][]string, checks int) func(cmd ...string) ([]byte, error) {
+	return func(cmd ...string) ([]byte, error) {
+		*cmds = append(*cmds, cmd)
+		if cmd[0] == "kill" {
+			checks = 0
+		}
+		if cmd[0] != "show" {
+			return nil, nil
+		}
+		if checks != 0 {
+			checks--
+			return []byte("ActiveState=deactivating\n"), nil
+		}
+		return []byte("ActiveState=inactive\n"), nil
+	}
+}
+
+func (s *SnapTestSuite) TestStopServiceWaits(c *C) {
+	stopPollInterval = time.Millisecond
+	defer func() { stopPollInterval = 100 * time.Millisecond }()
+
+	var cmds [][]string
+	runSystemctl = fakeStoppingSystemctl(&cmds, 2)
+
+	m := &packageYaml{Name: "foo", Version: "1.0"}
+	c.Assert(stopService(m, Service{Name: "svc1"}), IsNil)
+
+	show := []string{"show", "-p", "ActiveState", "foo_svc1_1.0.service"}
+	c.Assert(cmds, DeepEquals, [][]string{
+		{"stop", "--no-block", "foo_svc1_1.0.service"}, show, show, show,
+	})
+}
+
+func (s *SnapTestSuite) TestStopServiceTimeout(c *C) {
+	stopPollInterval = time.Millisecond
+	defer func() { stopPollInterval = 100 * time.Millisecond }()
+
+	var cmds [][]string
+	runSystemctl = fakeStoppingSystemctl(&cmds, -1)
+
+	m := &packageYaml{Name: "foo", Version: "1.0"}
+	err := stopService(m, Service{Name: "svc1", StopTimeout: "20ms"})
+	c.Assert(err, FitsTypeOf, &ErrServiceStopTimeout{})
+	c.Assert(err, ErrorMatches, "foo_svc1_1.0.service did not stop within 20ms and was killed")
+	// the stop does not wait for systemd, the unit is still
+	// deactivating when the deadline of the snap is reached
+	c.Assert(cmds[0], DeepEquals, []string{"stop", "--no-block", "foo_svc1_1.0.service"})
+	show := []string{"show", "-p", "ActiveState", "foo_svc1_1.0.service"}
+	c.Assert(cmds[len(cmds)-3], DeepEquals, show)
+	c.Assert(cmds[len(cmds)-2], DeepEquals, []string{"kill", "--signal=SIGKILL", "foo_svc1_1.0.service"})
+	// the timeout is only reported once the killed service is gone
+	c.Assert(cmds[len(cmds)-1], DeepEquals, show)
+}
+
+func (s *SnapTestSuite) TestStopServiceNotStoppedAfterKill(c *C) {
+	stopPollInterval = time.Millisecond
+	killStopTimeout = 10 * time.Millisecond
+	defer func() {
+		stopPollInterval = 100 * time.Millisecond
+		killStopTimeout = 5 * time.Second
+	}()
+
+	runSystemctl = func(cmd ...string) ([]byte, error) {
+		return []byte("ActiveState=deactivating\n"), nil
+	}
+
+	m := &packageYaml{Name: "foo", Version: "1.0"}
+	err := stopService(m, Service{Name: "svc1", StopTimeout: "10ms"})
+	c.Assert(err, FitsTypeOf, &ErrServiceNotStopped{})
+	c.Assert(err, ErrorMatches, "foo_svc1_1.0.service did not stop after it was killed")
+}
+
+func (s *SnapTestSuite) TestRemoveWaitsForServices(c *C) {
+	snapFile := makeTestSnapPackage(c, fmt.Sprintf(servicesPackageYaml, "foo"))
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	stopPollInterval = time.Millisecond
+	defer func() { stopPollInterval = 100 * time.Millisecond }()
+
+	var cmds [][]string
+	runSystemctl = fakeStoppingSystemctl(&cmds, 1)
+
+	c.Assert(removeClick(filepath.Join(snapAppsDir, "foo", "1.0")), IsNil)
+
+	var verbs []string
+	for _, cmd := range cmds {
+		verbs = append(verbs, cmd[0])
+	}
+	c.Assert(verbs, DeepEquals, []string{
+		"stop", "show", "show", "disable",
+		"stop", "show", "disable",
+		"daemon-reload",
+	})
+}
+
+func (s *SnapTestSuite) TestRemoveKilledServices(c *C) {
+	packageYaml := strings.Replace(fmt.Sprintf(servicesPackageYaml, "foo"), "start: bin/foo\n", "start: bin/foo\n   stop-timeout: 10ms\n", 1)
+	snapFile := makeTestSnapPackage(c, packageYaml)
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	stopPollInterval = time.Millisecond
+	defer func() { stopPollInterval = 100 * time.Millisecond }()
+
+	var cmds [][]string
+	runSystemctl = fakeStoppingSystemctl(&cmds, -1)
+
+	// svc1 has to be killed, its unit is removed all the same
+	c.Assert(removeClick(filepath.Join(snapAppsDir, "foo", "1.0")), IsNil)
+
+	var verbs []string
+	for _, cmd := range cmds {
+		if cmd[0] != "show" {
+			verbs = append(verbs, cmd[0])
+		}
+	}
+	c.Assert(verbs, DeepEquals, []string{
+		"stop", "kill", "disable",
+		"stop", "disable",
+		"daemon-reload",
+	})
+	c.Assert(helpers.FileExists(filepath.Join(snapServicesDir, "foo_svc1_1.0.service")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestUpdateKeepsDisabledServices(c *C) {
+	snapFile := makeTestSnapPackage(c, fmt.Sprintf(servicesPackageYaml, "foo"))
+	c.Assert(installClick(snapFile, AllowUnauthenticated, nil), IsNil)
+
+	var cmds [][]string
+	runSystemctl = func(cmd ...string) ([]byte, error) {
+		cmds = append(cmds, cmd)
+		switch {
+		case cmd[0] == "show":
+			return []byte("ActiveState=inactive\n"), nil
+		case cmd[0] == "is-enabled" && strings.HasPrefix(cmd[1], "foo_svc1_"):
+			return nil, &ErrSystemCtl{cmd: cmd, exitCode: 1}
+		}
+		return nil, nil
+	}
+	enabledAndStarted := func() (enabled, started []string) {
+		for _, cmd := range cmds {
+			switch cmd[0] {
+			case "enable":
+				enabled = append(enabled, cmd[1])
+			case "start":
+				started = append(started, cmd[1])
+			}
+		}
+		cmds = nil
+		return enabled, started
+	}
+
+	packageYaml := strings.Replace(fmt.Sprintf(servicesPackageYaml, "foo"), "version: 1.0", "version: 2.0", 1)
+	c.Assert(installClick(makeTestSnapPackage(c, packageYaml), AllowUnauthenticated, nil), IsNil)
+
+	enabled, started := enabledAndStarted()
+	c.Assert(enabled, DeepEquals, []string{"foo_svc2_2.0.service"})
+	c.Assert(started, DeepEquals, []string{"foo_svc2_2.0.service"})
+	// the unit of the disabled service is there to be enabled again
+	c.Assert(helpers.FileExists(filepath.Join(snapServicesDir, "foo_svc1_2.0.service")), Equals, true)
+
+	// and a rollback keeps it disabled too
+	c.Assert(makeSnapActiveByNameAndVersion("foo", "1.0"), IsNil)
+	enabled, started = enabledAndStarted()
+	c.Assert(enabled, DeepEquals, []string{"foo_svc2_1.0.service"})
+	c.Assert(started, DeepEquals, []string{"foo_svc2_1.0.service"})
+}
+
+func (s *SnapTestSuite) TestServiceStopTimeout(c *C) {
+	c.Assert(serviceStopTimeout(Service{}), Equals, defaultStopTimeout)
+	c.Assert(serviceStopTimeout(Service{StopTimeout: "30"}), Equals, 30*time.Second)
+	c.Assert(serviceStopTimeout(Service{StopTimeout: "1m30s"}), Equals, 90*time.Second)
+	c.Assert(serviceStopTimeout(Service{StopTimeout: "soon"}), Equals, defaultStopTimeout)
+
+	_, err := parseStopTimeout("-1")
+	c.Assert(err, ErrorMatches, `invalid timeout "-1"`)
+}
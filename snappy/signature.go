@@ -0,0 +1,179 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"launchpad.net/snappy/clickdeb"
+
+	"code.google.com/p/go.crypto/openpgp"
+	"code.google.com/p/go.crypto/openpgp/armor"
+	pgperrors "code.google.com/p/go.crypto/openpgp/errors"
+	"code.google.com/p/go.crypto/openpgp/packet"
+)
+
+// trustedKeyringFiles returns the keyring files with the keys that are
+// trusted to sign snaps. Only the snappy keyrings are used, the debsig
+// keyrings are trusted by debsig-verify for the origins and files its
+// policies name and not for any snap.
+func trustedKeyringFiles() (files []string, err error) {
+	globs := []string{
+		filepath.Join(snappyKeyringsDir, "*.gpg"),
+		filepath.Join(snappyKeyringsDir, "*.asc"),
+	}
+
+	for _, glob := range globs {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
+
+// readKeyringFile reads a binary or an armored keyring file
+func readKeyringFile(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if keyring, err := openpgp.ReadKeyRing(f); err == nil {
+		return keyring, nil
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	return openpgp.ReadArmoredKeyRing(f)
+}
+
+// trustedKeyring returns the keys that are trusted to sign snaps,
+// broken keyring files are skipped
+func trustedKeyring() (openpgp.EntityList, error) {
+	files, err := trustedKeyringFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var keyring openpgp.EntityList
+	for _, file := range files {
+		keys, err := readKeyringFile(file)
+		if err != nil {
+			log.Printf("WARNING: can not read keyring %s: %s", file, err)
+			continue
+		}
+		keyring = append(keyring, keys...)
+	}
+
+	return keyring, nil
+}
+
+// signatureIssuer returns the id of the key that made the given detached
+// signature or 0 if it can not be found
+func signatureIssuer(sig []byte) uint64 {
+	var r io.Reader = bytes.NewReader(sig)
+	if block, err := armor.Decode(bytes.NewReader(sig)); err == nil {
+		r = block.Body
+	}
+
+	p, err := packet.Read(r)
+	if err != nil {
+		return 0
+	}
+
+	switch sig := p.(type) {
+	case *packet.Signature:
+		if sig.IssuerKeyId != nil {
+			return *sig.IssuerKeyId
+		}
+	case *packet.SignatureV3:
+		return sig.IssuerKeyId
+	}
+
+	return 0
+}
+
+// verifySnapSignatureWithKeyring checks the signature of the given snap
+// against the given keyring
+func verifySnapSignatureWithKeyring(snapFile string, keyring openpgp.EntityList) error {
+	d := clickdeb.ClickDeb{Path: snapFile}
+
+	_, err := d.VerifySignature(keyring)
+	switch {
+	case err == nil:
+		return nil
+	case err == clickdeb.ErrSnapNotSigned:
+		return err
+	case err == pgperrors.ErrUnknownIssuer:
+		if len(keyring) == 0 {
+			return ErrNoTrustedKeys
+		}
+		sig, err := d.Signature()
+		if err != nil {
+			return err
+		}
+		return &ErrUnknownSigningKey{keyID: signatureIssuer(sig)}
+	}
+
+	switch err.(type) {
+	case pgperrors.SignatureError, pgperrors.StructuralError, pgperrors.UnsupportedError:
+		return &ErrBadSignature{err: err}
+	}
+
+	return err
+}
+
+// allowUnauthenticatedOk checks if the given signature error is "ok"
+// when running with --allow-unauthenticated. We allow packages with no
+// signature, with a signature from a unknown key or when there are no
+// trusted keys at all. We do not allow overriding bad signatures
+func allowUnauthenticatedOk(err error) bool {
+	switch err.(type) {
+	case *ErrUnknownSigningKey:
+		return true
+	}
+
+	return err == clickdeb.ErrSnapNotSigned || err == ErrNoTrustedKeys
+}
+
+func verifySnapSignatureImpl(snapFile string, allowUnauthenticated bool) error {
+	keyring, err := trustedKeyring()
+	if err != nil {
+		return err
+	}
+
+	err = verifySnapSignatureWithKeyring(snapFile, keyring)
+	if err != nil && allowUnauthenticated && allowUnauthenticatedOk(err) {
+		log.Printf("Signature check failed (%s), but installing anyway as requested", err)
+		return nil
+	}
+
+	return err
+}
+
+var verifySnapSignature = verifySnapSignatureImpl
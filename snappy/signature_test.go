@@ -0,0 +1,168 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"launchpad.net/snappy/clickdeb"
+
+	"code.google.com/p/go.crypto/openpgp"
+	"github.com/blakesmith/ar"
+	. "launchpad.net/gocheck"
+)
+
+func makeTestSigningKey(c *C) *openpgp.Entity {
+	key, err := openpgp.NewEntity("Snappy Test", "", "test@example.com", nil)
+	c.Assert(err, IsNil)
+
+	return key
+}
+
+// trustTestKey writes the public part of the given key to the trusted
+// keyring dir
+func trustTestKey(c *C, key *openpgp.Entity) {
+	c.Assert(os.MkdirAll(snappyKeyringsDir, 0755), IsNil)
+
+	f, err := os.Create(filepath.Join(snappyKeyringsDir, key.PrimaryKey.KeyIdShortString()+".gpg"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+	c.Assert(key.Serialize(f), IsNil)
+}
+
+// appendTestSignature appends the given signature to the snap
+func appendTestSignature(c *C, snapFile string, sig []byte) {
+	f, err := os.OpenFile(snapFile, os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	w := ar.NewWriter(f)
+	err = w.WriteHeader(&ar.Header{
+		Name:    "_gpgorigin",
+		ModTime: time.Now(),
+		Mode:    0644,
+		Size:    int64(len(sig)),
+	})
+	c.Assert(err, IsNil)
+	_, err = w.Write(sig)
+	c.Assert(err, IsNil)
+}
+
+// signTestSnap signs the given snap with the given key
+func signTestSnap(c *C, snapFile string, key *openpgp.Entity) {
+	d := clickdeb.ClickDeb{Path: snapFile}
+	var content bytes.Buffer
+	c.Assert(d.SignedContent(&content), IsNil)
+
+	var sig bytes.Buffer
+	c.Assert(openpgp.DetachSign(&sig, key, &content, nil), IsNil)
+
+	appendTestSignature(c, snapFile, sig.Bytes())
+}
+
+func (s *SnapTestSuite) TestVerifySnapSignatureValid(c *C) {
+	key := makeTestSigningKey(c)
+	trustTestKey(c, key)
+
+	snapFile := makeTestSnapPackage(c, "")
+	signTestSnap(c, snapFile, key)
+
+	c.Assert(verifySnapSignatureImpl(snapFile, false), IsNil)
+}
+
+func (s *SnapTestSuite) TestVerifySnapSignatureArmored(c *C) {
+	key := makeTestSigningKey(c)
+	trustTestKey(c, key)
+
+	snapFile := makeTestSnapPackage(c, "")
+	d := clickdeb.ClickDeb{Path: snapFile}
+	var content, sig bytes.Buffer
+	c.Assert(d.SignedContent(&content), IsNil)
+	c.Assert(openpgp.ArmoredDetachSign(&sig, key, &content, nil), IsNil)
+	appendTestSignature(c, snapFile, sig.Bytes())
+
+	c.Assert(verifySnapSignatureImpl(snapFile, false), IsNil)
+}
+
+func (s *SnapTestSuite) TestVerifySnapSignatureNotSigned(c *C) {
+	trustTestKey(c, makeTestSigningKey(c))
+	snapFile := makeTestSnapPackage(c, "")
+
+	c.Assert(verifySnapSignatureImpl(snapFile, false), Equals, clickdeb.ErrSnapNotSigned)
+	c.Assert(verifySnapSignatureImpl(snapFile, true), IsNil)
+}
+
+func (s *SnapTestSuite) TestVerifySnapSignatureNoTrustedKeys(c *C) {
+	snapFile := makeTestSnapPackage(c, "")
+	signTestSnap(c, snapFile, makeTestSigningKey(c))
+
+	c.Assert(verifySnapSignatureImpl(snapFile, false), Equals, ErrNoTrustedKeys)
+	c.Assert(verifySnapSignatureImpl(snapFile, true), IsNil)
+}
+
+func (s *SnapTestSuite) TestVerifySnapSignatureUnknownKey(c *C) {
+	trustTestKey(c, makeTestSigningKey(c))
+
+	key := makeTestSigningKey(c)
+	snapFile := makeTestSnapPackage(c, "")
+	signTestSnap(c, snapFile, key)
+
+	err := verifySnapSignatureImpl(snapFile, false)
+	c.Assert(err, FitsTypeOf, &ErrUnknownSigningKey{})
+	c.Assert(err.(*ErrUnknownSigningKey).keyID, Equals, key.PrimaryKey.KeyId)
+	c.Assert(strings.Contains(err.Error(), key.PrimaryKey.KeyIdString()), Equals, true)
+
+	c.Assert(verifySnapSignatureImpl(snapFile, true), IsNil)
+}
+
+func (s *SnapTestSuite) TestVerifySnapSignatureBad(c *C) {
+	key := makeTestSigningKey(c)
+	trustTestKey(c, key)
+
+	// a valid signature, but for a different content
+	var sig bytes.Buffer
+	c.Assert(openpgp.DetachSign(&sig, key, strings.NewReader("not the snap"), nil), IsNil)
+	snapFile := makeTestSnapPackage(c, "")
+	appendTestSignature(c, snapFile, sig.Bytes())
+
+	err := verifySnapSignatureImpl(snapFile, false)
+	c.Assert(err, FitsTypeOf, &ErrBadSignature{})
+
+	// bad signatures can never be overridden
+	err = verifySnapSignatureImpl(snapFile, true)
+	c.Assert(err, FitsTypeOf, &ErrBadSignature{})
+}
+
+func (s *SnapTestSuite) TestVerifySnapSignatureIgnoresDebsigKeyrings(c *C) {
+	key := makeTestSigningKey(c)
+	dir := filepath.Join(s.tempdir, "/usr/share/debsig/keyrings", key.PrimaryKey.KeyIdString())
+	c.Assert(os.MkdirAll(dir, 0755), IsNil)
+	f, err := os.Create(filepath.Join(dir, "origin.gpg"))
+	c.Assert(err, IsNil)
+	c.Assert(key.Serialize(f), IsNil)
+	f.Close()
+
+	snapFile := makeTestSnapPackage(c, "")
+	signTestSnap(c, snapFile, key)
+
+	c.Assert(verifySnapSignatureImpl(snapFile, false), Equals, ErrNoTrustedKeys)
+}
@@ -60,16 +60,82 @@ type Service struct {
 	PostStop    string `yaml:"poststop,omitempty" json:"poststop,omitempty"`
 	StopTimeout string `yaml:"stop-timeout,omitempty" json:"stop-timeout,omitempty"`
 
+	// the systemd Restart= and Type= of the service
+	Restart string `yaml:"restart,omitempty" json:"restart,omitempty"`
+	Type    string `yaml:"type,omitempty" json:"type,omitempty"`
+
+	// names of other services of the snap that this one is ordered
+	// after or before
+	After  []string `yaml:"after,omitempty" json:"after,omitempty"`
+	Before []string `yaml:"before,omitempty" json:"before,omitempty"`
+
+	Environment      map[string]string `yaml:"environment,omitempty" json:"environment,omitempty"`
+	WorkingDirectory string            `yaml:"working-directory,omitempty" json:"working-directory,omitempty"`
+
 	// must be a pointer so that it can be "nil" and omitempty works
 	Ports *Ports `yaml:"ports,omitempty" json:"ports,omitempty"`
+
+	// the security fields are not part of the snappy-systemd hook
+	SecurityDefinitions `yaml:",inline" json:"-"`
 }
 
 // Binary represents a single binary inside the binaries: package.yaml
 type Binary struct {
-	Name             string `yaml:"name"`
-	Exec             string `yaml:"exec"`
-	SecurityTemplate string `yaml:"security-template"`
-	SecurityPolicy   string `yaml:"security-policy"`
+	Name string `yaml:"name"`
+	Exec string `yaml:"exec"`
+
+	SecurityDefinitions `yaml:",inline"`
+}
+
+// SecurityOverrideDefinition points to the files with the high level
+// overrides of the apparmor and seccomp policy of a app
+type SecurityOverrideDefinition struct {
+	Apparmor string `yaml:"apparmor,omitempty"`
+	Seccomp  string `yaml:"seccomp,omitempty"`
+}
+
+// SecurityPolicyDefinition points to the files with the hand-crafted
+// apparmor profile and seccomp filter of a app
+type SecurityPolicyDefinition struct {
+	Apparmor string `yaml:"apparmor,omitempty"`
+	Seccomp  string `yaml:"seccomp,omitempty"`
+}
+
+// SecurityDefinitions are the security fields of services and binaries,
+// see docs/security.md
+type SecurityDefinitions struct {
+	// the template to use instead of "default"
+	SecurityTemplate string `yaml:"security-template,omitempty"`
+	// must be pointers so that they can be "nil" and omitempty works
+	SecurityOverride *SecurityOverrideDefinition `yaml:"security-override,omitempty"`
+	SecurityPolicy   *SecurityPolicyDefinition   `yaml:"security-policy,omitempty"`
+	// the policy groups, nil means the default ones and a empty
+	// list none at all
+	SecurityCaps Caps `yaml:"caps,omitempty"`
+}
+
+// Caps are the policy groups of a app, in the package.yaml they are a
+// list or "none"
+type Caps []string
+
+// UnmarshalYAML accepts "caps: none" as the empty list
+func (caps *Caps) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var none string
+	if err := unmarshal(&none); err == nil {
+		if none != "none" {
+			return fmt.Errorf("caps must be a list or none, not %q", none)
+		}
+		*caps = Caps{}
+		return nil
+	}
+
+	var l []string
+	if err := unmarshal(&l); err != nil {
+		return err
+	}
+	*caps = l
+
+	return nil
 }
 
 // SnapPart represents a generic snap type
@@ -114,19 +180,20 @@ type packageYaml struct {
 }
 
 type remoteSnap struct {
-	Publisher       string  `json:"publisher,omitempty"`
-	Name            string  `json:"name"`
-	Title           string  `json:"title"`
-	IconURL         string  `json:"icon_url"`
-	Price           float64 `json:"price,omitempty"`
-	Content         string  `json:"content,omitempty"`
-	RatingsAverage  float64 `json:"ratings_average,omitempty"`
-	Version         string  `json:"version"`
-	AnonDownloadURL string  `json:"anon_download_url, omitempty"`
-	DownloadURL     string  `json:"download_url, omitempty"`
-	DownloadSha512  string  `json:"download_sha512, omitempty"`
-	LastUpdated     string  `json:"last_updated, omitempty"`
-	DownloadSize    int64   `json:"binary_filesize, omitempty"`
+	Publisher       string   `json:"publisher,omitempty"`
+	Name            string   `json:"name"`
+	Title           string   `json:"title"`
+	IconURL         string   `json:"icon_url"`
+	Price           float64  `json:"price,omitempty"`
+	Content         string   `json:"content,omitempty"`
+	RatingsAverage  float64  `json:"ratings_average,omitempty"`
+	Version         string   `json:"version"`
+	AnonDownloadURL string   `json:"anon_download_url, omitempty"`
+	DownloadURL     string   `json:"download_url, omitempty"`
+	DownloadSha512  string   `json:"download_sha512, omitempty"`
+	LastUpdated     string   `json:"last_updated, omitempty"`
+	DownloadSize    int64    `json:"binary_filesize, omitempty"`
+	Framework       []string `json:"framework,omitempty"`
 }
 
 type searchResults struct {
@@ -235,10 +302,14 @@ func (s *SnapPart) Hash() string {
 	return s.hash
 }
 
-// Channel returns the channel used
+// Channel returns the channel the snap tracks
 func (s *SnapPart) Channel() string {
-	// FIXME: real channel support
-	return "edge"
+	return snapChannel(s.Name())
+}
+
+// Frameworks returns the frameworks needed by the snap
+func (s *SnapPart) Frameworks() ([]string, error) {
+	return parseFrameworks(s.m.Framework), nil
 }
 
 // Icon returns the path to the icon
@@ -346,6 +417,8 @@ func (s *SnapLocalRepository) Search(terms string) (versions []Part, err error)
 
 // Details returns details for the given snap
 func (s *SnapLocalRepository) Details(name string) (versions []Part, err error) {
+	// the channel is irrelevant for what is installed already
+	name, _ = splitChannel(name)
 	globExpr := filepath.Join(s.path, name, "*", "meta", "package.yaml")
 	parts, err := s.partsForGlobExpr(globExpr)
 
@@ -394,7 +467,9 @@ func (s *SnapLocalRepository) partsForGlobExpr(globExpr string) (parts []Part, e
 
 // RemoteSnapPart represents a snap available on the server
 type RemoteSnapPart struct {
-	pkg remoteSnap
+	pkg     remoteSnap
+	channel string
+	storeID string
 }
 
 // Type returns the type of the SnapPart (app, oem, ...)
@@ -423,10 +498,18 @@ func (s *RemoteSnapPart) Hash() string {
 	return s.pkg.DownloadSha512
 }
 
-// Channel returns the channel used
+// Channel returns the channel the snap was found in
 func (s *RemoteSnapPart) Channel() string {
-	// FIXME: real channel support, this requires server work
-	return "edge"
+	if s.channel == "" {
+		return defaultChannel
+	}
+
+	return s.channel
+}
+
+// Frameworks returns the frameworks needed by the snap
+func (s *RemoteSnapPart) Frameworks() ([]string, error) {
+	return s.pkg.Framework, nil
 }
 
 // Icon returns the icon
@@ -464,18 +547,102 @@ func (s *RemoteSnapPart) Date() time.Time {
 	return p
 }
 
-// Download downloads the snap and returns the filename
+// partialDownloadFile returns the file that is used to download the
+// snap to, it is kept if the download is interrupted so that the next
+// download can resume from where it stopped
+func (s *RemoteSnapPart) partialDownloadFile() string {
+	return filepath.Join(snapDownloadsDir, fmt.Sprintf("%s_%s.snap.partial", s.Name(), s.Version()))
+}
+
+// partialDownloadValidatorFile returns the file that keeps the ETag or
+// Last-Modified of the partial download, it is sent as If-Range so
+// that only the rest of the same snap is appended on resume
+func (s *RemoteSnapPart) partialDownloadValidatorFile() string {
+	return s.partialDownloadFile() + ".if-range"
+}
+
+// Download downloads the snap and returns the filename. An interrupted
+// download is resumed and the downloaded snap is checked against the
+// sha512 from the store. Without a sha512 the download always starts
+// from the beginning because a resumed snap could not be verified.
 func (s *RemoteSnapPart) Download(pbar ProgressMeter) (string, error) {
+	// the name and version end up in the path of the download
+	if !validSnapName.MatchString(s.Name()) {
+		return "", &ErrInvalidRemoteSnap{field: "name", value: s.Name()}
+	}
+	if !validSnapVersion.MatchString(s.Version()) {
+		return "", &ErrInvalidRemoteSnap{field: "version", value: s.Version()}
+	}
+
+	if err := helpers.EnsureDir(snapDownloadsDir, 0755); err != nil {
+		return "", err
+	}
 
-	w, err := ioutil.TempFile("", s.pkg.Name)
+	partial := s.partialDownloadFile()
+	validator := s.partialDownloadValidatorFile()
+	flags := os.O_CREATE | os.O_WRONLY
+	if s.pkg.DownloadSha512 == "" {
+		flags |= os.O_TRUNC
+	}
+	w, err := os.OpenFile(partial, flags, 0644)
 	if err != nil {
 		return "", err
 	}
-	defer func() {
+	defer w.Close()
+
+	if err := s.download(w, validator, pbar); err != nil {
+		return "", err
+	}
+	if err := w.Sync(); err != nil {
+		return "", err
+	}
+	os.Remove(validator)
+
+	if s.pkg.DownloadSha512 != "" {
+		sha512, err := helpers.Sha512sum(partial)
 		if err != nil {
-			os.Remove(w.Name())
+			return "", err
+		}
+		if sha512 != s.pkg.DownloadSha512 {
+			// there is no point in resuming a broken download
+			os.Remove(partial)
+			return "", &ErrHashMismatch{
+				file:     s.Name(),
+				expected: s.pkg.DownloadSha512,
+				got:      sha512,
+			}
+		}
+	}
+
+	snapFile := strings.TrimSuffix(partial, ".partial")
+	if err := os.Rename(partial, snapFile); err != nil {
+		return "", err
+	}
+
+	return snapFile, nil
+}
+
+// download writes the snap to w, if w already contains the start of
+// the snap only the missing part is requested. The ETag or
+// Last-Modified of the snap is kept in the validator file and sent as
+// If-Range so that the server sends the whole snap if it has changed.
+func (s *RemoteSnapPart) download(w *os.File, validator string, pbar ProgressMeter) error {
+	offset, err := w.Seek(0, os.SEEK_END)
+	if err != nil {
+		return err
+	}
+
+	ifRange, err := ioutil.ReadFile(validator)
+	if offset > 0 && (err != nil || len(ifRange) == 0) {
+		// nothing to tell if the partial download is from the same
+		// snap, start over
+		if err := w.Truncate(0); err != nil {
+			return err
 		}
-	}()
+		if offset, err = w.Seek(0, os.SEEK_SET); err != nil {
+			return err
+		}
+	}
 
 	// try anonymous download first and fallback to authenticated
 	url := s.pkg.AnonDownloadURL
@@ -484,22 +651,50 @@ func (s *RemoteSnapPart) Download(pbar ProgressMeter) (string, error) {
 	}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", err
+		return err
+	}
+	setUbuntuStoreHeaders(req, s.storeID)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", string(ifRange))
 	}
-	setUbuntuStoreHeaders(req)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("Unexpected status code %v", resp.StatusCode)
+
+	switch {
+	case resp.StatusCode == 206 && offset > 0:
+		// resume the download
+	case resp.StatusCode == 416 && offset > 0:
+		// the partial download is not usable, start over
+		resp.Body.Close()
+		if err := w.Truncate(0); err != nil {
+			return err
+		}
+		os.Remove(validator)
+		return s.download(w, validator, pbar)
+	case resp.StatusCode == 200:
+		// the server does not support ranges or the snap has changed
+		if err := w.Truncate(0); err != nil {
+			return err
+		}
+		if offset, err = w.Seek(0, os.SEEK_SET); err != nil {
+			return err
+		}
+		if err := writeDownloadValidator(validator, resp.Header); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unexpected status code %v", resp.StatusCode)
 	}
 
 	if pbar != nil {
-		pbar.Start(float64(resp.ContentLength))
+		pbar.Start(float64(offset + resp.ContentLength))
+		pbar.Set(float64(offset))
 		mw := io.MultiWriter(w, pbar)
 		_, err = io.Copy(mw, resp.Body)
 		pbar.Finished()
@@ -507,27 +702,69 @@ func (s *RemoteSnapPart) Download(pbar ProgressMeter) (string, error) {
 		_, err = io.Copy(w, resp.Body)
 	}
 
+	return err
+}
+
+// writeDownloadValidator writes the strong ETag or else the
+// Last-Modified of a response to the validator file, if there is
+// neither the validator file is removed
+func writeDownloadValidator(validator string, header http.Header) error {
+	v := header.Get("ETag")
+	if strings.HasPrefix(v, "W/") {
+		// weak ETags are not allowed in If-Range
+		v = ""
+	}
+	if v == "" {
+		v = header.Get("Last-Modified")
+	}
+	if v == "" {
+		if err := os.Remove(validator); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	return helpers.AtomicWriteFile(validator, []byte(v), 0644)
+}
+
+// downloadOrCached returns the snap file from the download cache or
+// downloads it and adds it to the cache
+func (s *RemoteSnapPart) downloadOrCached(pbar ProgressMeter) (string, error) {
+	// without a sha512 the cache can not tell versions apart
+	if s.pkg.DownloadSha512 == "" {
+		return s.Download(pbar)
+	}
+
+	if snapFile := cachedSnapFile(s.Name(), s.Version(), s.pkg.DownloadSha512); snapFile != "" {
+		return snapFile, nil
+	}
+
+	downloadedSnap, err := s.Download(pbar)
 	if err != nil {
 		return "", err
 	}
 
-	return w.Name(), w.Sync()
+	return addToCache(downloadedSnap, s.Name(), s.Version(), s.pkg.DownloadSha512)
 }
 
 // Install installs the snap
 func (s *RemoteSnapPart) Install(pbar ProgressMeter, flags InstallFlags) error {
-	downloadedSnap, err := s.Download(pbar)
+	snapFile, err := s.downloadOrCached(pbar)
 	if err != nil {
 		return err
 	}
-	defer os.Remove(downloadedSnap)
+	// snaps without a sha512 are not kept in the cache
+	if !isCachedSnapFile(snapFile) {
+		defer os.Remove(snapFile)
+	}
 
-	err = installClick(downloadedSnap, flags, nil)
+	err = installClick(snapFile, flags, nil)
 	if err != nil {
 		return err
 	}
 
-	return err
+	// remember the channel so that updates come from the same place
+	return setSnapChannel(s.Name(), s.Channel())
 }
 
 // SetActive sets the snap active
@@ -551,9 +788,9 @@ func (s *RemoteSnapPart) NeedsReboot() bool {
 }
 
 // NewRemoteSnapPart returns a new RemoteSnapPart from the given
-// remoteSnap data
-func NewRemoteSnapPart(data remoteSnap) *RemoteSnapPart {
-	return &RemoteSnapPart{pkg: data}
+// remoteSnap data that was found in the given channel
+func NewRemoteSnapPart(data remoteSnap, channel string) *RemoteSnapPart {
+	return &RemoteSnapPart{pkg: data, channel: channel}
 }
 
 // SnapUbuntuStoreRepository represents the ubuntu snap store
@@ -561,6 +798,9 @@ type SnapUbuntuStoreRepository struct {
 	searchURI  string
 	detailsURI string
 	bulkURI    string
+
+	// overrides the store id of the oem snap if set
+	storeID string
 }
 
 var (
@@ -582,8 +822,9 @@ func NewUbuntuStoreSnapRepository() *SnapUbuntuStoreRepository {
 	}
 }
 
-// small helper that sets the correct http headers for the ubuntu store
-func setUbuntuStoreHeaders(req *http.Request) {
+// small helper that sets the correct http headers for the ubuntu store,
+// the storeID is optional
+func setUbuntuStoreHeaders(req *http.Request, storeID string) {
 	req.Header.Set("Accept", "application/hal+json")
 
 	// frameworks
@@ -593,13 +834,15 @@ func setUbuntuStoreHeaders(req *http.Request) {
 	req.Header.Set("X-Ubuntu-Architecture", string(Architecture()))
 
 	// check if the oem part sets a custom store-id
-	oems, _ := InstalledSnapsByType(SnapTypeOem)
-	if len(oems) == 1 {
-		storeID := oems[0].(*SnapPart).m.Store.ID
-		if storeID != "" {
-			req.Header.Set("X-Ubuntu-Store", storeID)
+	if storeID == "" {
+		oems, _ := InstalledSnapsByType(SnapTypeOem)
+		if len(oems) == 1 {
+			storeID = oems[0].(*SnapPart).m.Store.ID
 		}
 	}
+	if storeID != "" {
+		req.Header.Set("X-Ubuntu-Store", storeID)
+	}
 
 	// sso
 	ssoToken, err := ReadStoreToken()
@@ -608,21 +851,40 @@ func setUbuntuStoreHeaders(req *http.Request) {
 	}
 }
 
+// newRemoteSnapPart returns a RemoteSnapPart for a snap found in this
+// repository
+func (s *SnapUbuntuStoreRepository) newRemoteSnapPart(data remoteSnap, channel string) *RemoteSnapPart {
+	snap := NewRemoteSnapPart(data, channel)
+	snap.storeID = s.storeID
+
+	return snap
+}
+
 // Description describes the repository
 func (s *SnapUbuntuStoreRepository) Description() string {
 	return fmt.Sprintf("Snap remote repository for %s", s.searchURI)
 }
 
-// Details returns details for the given snap in this repository
+// Details returns details for the given snap in this repository. The
+// snapName can be given as "name/channel", if no channel is given the
+// channel the snap tracks is used.
 func (s *SnapUbuntuStoreRepository) Details(snapName string) (parts []Part, err error) {
-	url := fmt.Sprintf(s.detailsURI, snapName)
+	snapName, channel := splitChannel(snapName)
+	if channel == "" {
+		channel = snapChannel(snapName)
+	}
+
+	url, err := addChannelQuery(fmt.Sprintf(s.detailsURI, snapName), channel)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	// set headers
-	setUbuntuStoreHeaders(req)
+	setUbuntuStoreHeaders(req, s.storeID)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -646,22 +908,32 @@ func (s *SnapUbuntuStoreRepository) Details(snapName string) (parts []Part, err
 		return nil, err
 	}
 
-	snap := NewRemoteSnapPart(detailsData)
+	snap := s.newRemoteSnapPart(detailsData, channel)
 	parts = append(parts, snap)
 
 	return parts, nil
 }
 
-// Search searches the repository for the given searchTerm
+// Search searches the repository for the given searchTerm. The
+// searchTerm can be given as "term/channel" to search a channel other
+// than the default one.
 func (s *SnapUbuntuStoreRepository) Search(searchTerm string) (parts []Part, err error) {
-	url := fmt.Sprintf(s.searchURI, searchTerm)
+	searchTerm, channel := splitChannel(searchTerm)
+	if channel == "" {
+		channel = defaultChannel
+	}
+
+	url, err := addChannelQuery(fmt.Sprintf(s.searchURI, searchTerm), channel)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	// set headers
-	setUbuntuStoreHeaders(req)
+	setUbuntuStoreHeaders(req, s.storeID)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -678,14 +950,15 @@ func (s *SnapUbuntuStoreRepository) Search(searchTerm string) (parts []Part, err
 	}
 
 	for _, pkg := range searchData.Payload.Packages {
-		snap := NewRemoteSnapPart(pkg)
+		snap := s.newRemoteSnapPart(pkg, channel)
 		parts = append(parts, snap)
 	}
 
 	return parts, nil
 }
 
-// Updates returns the available updates
+// Updates returns the available updates. Each installed snap is checked
+// against the channel it tracks.
 func (s *SnapUbuntuStoreRepository) Updates() (parts []Part, err error) {
 	// the store only supports apps and framworks currently, so no
 	// sense in sending it our ubuntu-core snap
@@ -693,17 +966,46 @@ func (s *SnapUbuntuStoreRepository) Updates() (parts []Part, err error) {
 	if err != nil || len(installed) == 0 {
 		return nil, err
 	}
-	jsonData, err := json.Marshal(map[string][]string{"name": installed})
+
+	// the store answers for one channel at a time
+	byChannel := make(map[string][]string)
+	for _, name := range installed {
+		channel := snapChannel(name)
+		byChannel[channel] = append(byChannel[channel], name)
+	}
+
+	for _, channel := range storeChannels {
+		names, ok := byChannel[channel]
+		if !ok {
+			continue
+		}
+
+		updates, err := s.updatesForChannel(names, channel)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, updates...)
+	}
+
+	return parts, nil
+}
+
+func (s *SnapUbuntuStoreRepository) updatesForChannel(names []string, channel string) (parts []Part, err error) {
+	jsonData, err := json.Marshal(map[string][]string{"name": names})
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", s.bulkURI, bytes.NewBuffer([]byte(jsonData)))
+	url, err := addChannelQuery(s.bulkURI, channel)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(jsonData)))
 	if err != nil {
 		return nil, err
 	}
 	// set headers
-	setUbuntuStoreHeaders(req)
+	setUbuntuStoreHeaders(req, s.storeID)
 	// the updates call is a special snowflake right now
 	// (see LP: #1427155)
 	req.Header.Set("Accept", "application/json")
@@ -724,7 +1026,7 @@ func (s *SnapUbuntuStoreRepository) Updates() (parts []Part, err error) {
 	for _, pkg := range updateData {
 		current := ActiveSnapByName(pkg.Name)
 		if current == nil || current.Version() != pkg.Version {
-			snap := NewRemoteSnapPart(pkg)
+			snap := s.newRemoteSnapPart(pkg, channel)
 			parts = append(parts, snap)
 		}
 	}
@@ -18,6 +18,9 @@
 package snappy
 
 import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -51,12 +54,20 @@ func (s *SnapTestSuite) SetUpTest(c *C) {
 	clickSystemHooksDir = filepath.Join(s.tempdir, "/usr/share/click/hooks")
 	os.MkdirAll(clickSystemHooksDir, 0755)
 
-	// we may not have debsig-verify installed (and we don't need it
-	// for the unittests)
-	runDebsigVerify = func(snapFile string, allowUnauth bool) (err error) {
+	// the test snaps are not signed
+	verifySnapSignature = func(snapFile string, allowUnauth bool) (err error) {
 		return nil
 	}
-	runSystemctl = func(cmd ...string) error {
+	runSystemctl = func(cmd ...string) ([]byte, error) {
+		// all services stop right away
+		if cmd[0] == "show" {
+			return []byte("ActiveState=inactive\n"), nil
+		}
+		return nil, nil
+	}
+
+	// do not load the generated apparmor profiles
+	runAppArmorParser = func(args ...string) error {
 		return nil
 	}
 
@@ -68,8 +79,8 @@ func (s *SnapTestSuite) SetUpTest(c *C) {
 	storeDetailsURI = ""
 	storeBulkURI = ""
 
-	aaExec = filepath.Join(s.tempdir, "aa-exec")
-	err := ioutil.WriteFile(aaExec, []byte(mockAaExecScript), 0755)
+	hookLauncher = filepath.Join(s.tempdir, "snappy")
+	err := ioutil.WriteFile(hookLauncher, []byte(mockLauncherScript), 0755)
 	c.Assert(err, IsNil)
 
 	// ensure we do not look at the system
@@ -79,7 +90,10 @@ func (s *SnapTestSuite) SetUpTest(c *C) {
 func (s *SnapTestSuite) TearDownTest(c *C) {
 	// ensure all functions are back to their original state
 	regenerateAppArmorRules = regenerateAppArmorRulesImpl
+	runAppArmorParser = runAppArmorParserImpl
+	appArmorSecurityFS = "/sys/kernel/security/apparmor"
 	InstalledSnapNamesByType = installedSnapNamesByTypeImpl
+	newMetaRepository = NewMetaRepository
 	duCmd = "du"
 }
 
@@ -379,7 +393,8 @@ func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetails(c *C) {
 		storeID := r.Header.Get("X-Ubuntu-Store")
 		c.Assert(storeID, Equals, "")
 
-		c.Assert(strings.HasSuffix(r.URL.String(), "xkcd-webserver"), Equals, true)
+		c.Assert(strings.HasSuffix(r.URL.Path, "xkcd-webserver"), Equals, true)
+		c.Assert(r.URL.Query().Get("channel"), Equals, "edge")
 		io.WriteString(w, MockDetailsJSON)
 	}))
 
@@ -403,7 +418,7 @@ func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetails(c *C) {
 
 func (s *SnapTestSuite) TestUbuntuStoreRepositoryNoDetails(c *C) {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c.Assert(strings.HasSuffix(r.URL.String(), "no-such-pkg"), Equals, true)
+		c.Assert(strings.HasSuffix(r.URL.Path, "no-such-pkg"), Equals, true)
 		w.WriteHeader(404)
 		io.WriteString(w, MockNoDetailsJSON)
 	}))
@@ -454,6 +469,8 @@ func (s *SnapTestSuite) TestUbuntuStoreRepositoryInstallRemoveSnap(c *C) {
 	defer mockServer.Close()
 
 	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.Version = "1.0"
 	snap.pkg.AnonDownloadURL = mockServer.URL + "/snap"
 
 	p := &MockProgressMeter{}
@@ -464,6 +481,220 @@ func (s *SnapTestSuite) TestUbuntuStoreRepositoryInstallRemoveSnap(c *C) {
 	c.Assert(p.written, Equals, int(st.Size()))
 }
 
+func (s *SnapTestSuite) TestRemoteSnapDownloadResume(c *C) {
+	snapPackage := makeTestSnapPackage(c, "")
+	content, err := ioutil.ReadFile(snapPackage)
+	c.Assert(err, IsNil)
+	sha512, err := helpers.Sha512sum(snapPackage)
+	c.Assert(err, IsNil)
+
+	var rangeHeader, ifRangeHeader string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader = r.Header.Get("Range")
+		ifRangeHeader = r.Header.Get("If-Range")
+		w.Header().Set("ETag", `"foo-1.0"`)
+		http.ServeContent(w, r, "foo.snap", time.Time{}, strings.NewReader(string(content)))
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.Version = "1.0"
+	snap.pkg.AnonDownloadURL = mockServer.URL + "/snap"
+	snap.pkg.DownloadSha512 = sha512
+
+	// a previous download was interrupted half way
+	half := len(content) / 2
+	c.Assert(os.MkdirAll(snapDownloadsDir, 0755), IsNil)
+	err = ioutil.WriteFile(snap.partialDownloadFile(), content[:half], 0644)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(snap.partialDownloadValidatorFile(), []byte(`"foo-1.0"`), 0644)
+	c.Assert(err, IsNil)
+
+	p := &MockProgressMeter{}
+	snapFile, err := snap.Download(p)
+	c.Assert(err, IsNil)
+	c.Assert(rangeHeader, Equals, fmt.Sprintf("bytes=%d-", half))
+	c.Assert(ifRangeHeader, Equals, `"foo-1.0"`)
+	c.Assert(p.written, Equals, len(content)-half)
+	c.Assert(helpers.FileExists(snap.partialDownloadFile()), Equals, false)
+	c.Assert(helpers.FileExists(snap.partialDownloadValidatorFile()), Equals, false)
+
+	downloaded, err := ioutil.ReadFile(snapFile)
+	c.Assert(err, IsNil)
+	c.Assert(downloaded, DeepEquals, content)
+}
+
+// sha512Hex returns the sha512 of data like the store sends it
+func sha512Hex(data string) string {
+	sum := sha512.Sum512([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadResumeChanged(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"foo-1.0-rebuilt"`)
+		http.ServeContent(w, r, "foo.snap", time.Time{}, strings.NewReader("snap content"))
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.Version = "1.0"
+	snap.pkg.AnonDownloadURL = mockServer.URL + "/snap"
+	snap.pkg.DownloadSha512 = "1234"
+
+	c.Assert(os.MkdirAll(snapDownloadsDir, 0755), IsNil)
+	err := ioutil.WriteFile(snap.partialDownloadFile(), []byte("old snap"), 0644)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(snap.partialDownloadValidatorFile(), []byte(`"foo-1.0"`), 0644)
+	c.Assert(err, IsNil)
+
+	// the If-Range does not match so the whole snap is sent and the
+	// old start is not kept
+	_, err = snap.Download(nil)
+	c.Assert(err, FitsTypeOf, &ErrHashMismatch{})
+	c.Assert(err.(*ErrHashMismatch).got, Equals, sha512Hex("snap content"))
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadNoValidatorStartsOver(c *C) {
+	var rangeHeader string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader = r.Header.Get("Range")
+		http.ServeContent(w, r, "foo.snap", time.Time{}, strings.NewReader("snap content"))
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.Version = "1.0"
+	snap.pkg.AnonDownloadURL = mockServer.URL + "/snap"
+	snap.pkg.DownloadSha512 = sha512Hex("snap content")
+
+	c.Assert(os.MkdirAll(snapDownloadsDir, 0755), IsNil)
+	err := ioutil.WriteFile(snap.partialDownloadFile(), []byte("snap"), 0644)
+	c.Assert(err, IsNil)
+
+	snapFile, err := snap.Download(nil)
+	c.Assert(err, IsNil)
+	c.Assert(rangeHeader, Equals, "")
+	downloaded, err := ioutil.ReadFile(snapFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(downloaded), Equals, "snap content")
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadNoSha512StartsOver(c *C) {
+	var rangeHeader string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader = r.Header.Get("Range")
+		w.Header().Set("ETag", `"foo-1.0"`)
+		http.ServeContent(w, r, "foo.snap", time.Time{}, strings.NewReader("snap content"))
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.Version = "1.0"
+	snap.pkg.AnonDownloadURL = mockServer.URL + "/snap"
+
+	c.Assert(os.MkdirAll(snapDownloadsDir, 0755), IsNil)
+	err := ioutil.WriteFile(snap.partialDownloadFile(), []byte("snap"), 0644)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(snap.partialDownloadValidatorFile(), []byte(`"foo-1.0"`), 0644)
+	c.Assert(err, IsNil)
+
+	// without a sha512 the resumed snap could not be verified
+	snapFile, err := snap.Download(nil)
+	c.Assert(err, IsNil)
+	c.Assert(rangeHeader, Equals, "")
+	downloaded, err := ioutil.ReadFile(snapFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(downloaded), Equals, "snap content")
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadInvalidNameOrVersion(c *C) {
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "../foo"
+	snap.pkg.Version = "1.0"
+
+	_, err := snap.Download(nil)
+	c.Assert(err, ErrorMatches, `invalid snap name "../foo"`)
+
+	snap.pkg.Name = "foo"
+	snap.pkg.Version = "1.0/../../x"
+	_, err = snap.Download(nil)
+	c.Assert(err, ErrorMatches, `invalid snap version "1.0/../../x"`)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadNoRangeSupport(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "snap content")
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.Version = "1.0"
+	snap.pkg.AnonDownloadURL = mockServer.URL + "/snap"
+
+	c.Assert(os.MkdirAll(snapDownloadsDir, 0755), IsNil)
+	err := ioutil.WriteFile(snap.partialDownloadFile(), []byte("something else"), 0644)
+	c.Assert(err, IsNil)
+
+	// the server sends the whole snap so the partial file is replaced
+	snapFile, err := snap.Download(nil)
+	c.Assert(err, IsNil)
+	downloaded, err := ioutil.ReadFile(snapFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(downloaded), Equals, "snap content")
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadInterrupted(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// promise more than is sent
+		w.Header().Set("Content-Length", "100")
+		io.WriteString(w, "snap")
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.Version = "1.0"
+	snap.pkg.AnonDownloadURL = mockServer.URL + "/snap"
+
+	_, err := snap.Download(nil)
+	c.Assert(err, NotNil)
+
+	// the partial download is kept for the next try
+	partial, err := ioutil.ReadFile(snap.partialDownloadFile())
+	c.Assert(err, IsNil)
+	c.Assert(string(partial), Equals, "snap")
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadHashMismatch(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "not the snap")
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.Version = "1.0"
+	snap.pkg.AnonDownloadURL = mockServer.URL + "/snap"
+	snap.pkg.DownloadSha512 = "1234"
+
+	err := snap.Install(nil, 0)
+	c.Assert(err, FitsTypeOf, &ErrHashMismatch{})
+	c.Assert(helpers.FileExists(snap.partialDownloadFile()), Equals, false)
+}
+
 func (s *SnapTestSuite) TestRemoteSnapErrors(c *C) {
 	snap := RemoteSnapPart{}
 
@@ -573,6 +804,30 @@ func (s *SnapTestSuite) TestPackageYamlLicenseParsing(c *C) {
 	c.Assert(m.ExplicitLicenseAgreement, Equals, true)
 }
 
+func (s *SnapTestSuite) TestPackageYamlCapsParsing(c *C) {
+	m, err := parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: none
+   start: bin/foo
+   caps: none
+ - name: list
+   start: bin/foo
+   caps:
+     - network-client
+ - name: default
+   start: bin/foo
+`))
+	c.Assert(err, IsNil)
+	c.Assert(m.Services[0].SecurityCaps, DeepEquals, Caps{})
+	c.Assert(m.Services[1].SecurityCaps, DeepEquals, Caps{"network-client"})
+	c.Assert(m.Services[2].SecurityCaps, IsNil)
+
+	_, err = parsePackageYamlData([]byte("name: foo\nbinaries:\n - name: bin/foo\n   caps: network-client\n"))
+	c.Assert(err, ErrorMatches, `.*caps must be a list or none, not "network-client"`)
+}
+
 func (s *SnapTestSuite) TestUbuntuStoreRepositoryOemStoreId(c *C) {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// ensure we get the right header
@@ -249,6 +249,11 @@ func (s *SystemImagePart) Channel() string {
 	return s.channelName
 }
 
+// Frameworks returns the frameworks needed by the part
+func (s *SystemImagePart) Frameworks() ([]string, error) {
+	return nil, nil
+}
+
 // Icon returns the icon path
 func (s *SystemImagePart) Icon() string {
 	return ""
@@ -0,0 +1,310 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// the rules for the package.yaml fields from docs/meta.md
+var (
+	validSnapName    = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]*$`)
+	validSnapVersion = regexp.MustCompile(`^[a-zA-Z0-9.+~-]+$`)
+	validCommandName = regexp.MustCompile(`^[a-zA-Z0-9+.-]+$`)
+	validEnvName     = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// security templates, caps and abstractions
+	validSecurityName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.+-]*$`)
+)
+
+// the values systemd knows for the restart and type of a service
+var (
+	validServiceRestarts = []string{"no", "on-success", "on-failure", "on-abnormal", "on-watchdog", "on-abort", "always"}
+	validServiceTypes    = []string{"simple", "forking", "oneshot", "notify"}
+)
+
+// packageYamlValidator collects the problems of a package.yaml
+type packageYamlValidator struct {
+	problems []string
+}
+
+func (v *packageYamlValidator) addProblem(field, format string, a ...interface{}) {
+	v.problems = append(v.problems, field+": "+fmt.Sprintf(format, a...))
+}
+
+// checkRequired adds a problem if the value of the field is empty
+func (v *packageYamlValidator) checkRequired(field, value string) bool {
+	if value == "" {
+		v.addProblem(field, "is required")
+		return false
+	}
+
+	return true
+}
+
+// checkPattern adds a problem if the value of the field does not match
+// the given pattern
+func (v *packageYamlValidator) checkPattern(field, value string, pattern *regexp.Regexp) {
+	if !pattern.MatchString(value) {
+		v.addProblem(field, "%q does not match %s", value, pattern)
+	}
+}
+
+// checkSnapPath adds a problem if the command in the field does not
+// point into the snap directory, commands are relative to it
+func (v *packageYamlValidator) checkSnapPath(field, command string) {
+	l := strings.Fields(command)
+	if len(l) == 0 {
+		return
+	}
+
+	v.checkRelativePath(field, l[0])
+}
+
+// checkSnapCommand adds a problem if the command in the field spans more
+// than one line, it ends up in unit files and wrappers, or does not
+// point into the snap directory
+func (v *packageYamlValidator) checkSnapCommand(field, command string) {
+	if v.checkSingleLine(field, command) {
+		v.checkSnapPath(field, command)
+	}
+}
+
+// checkRelativePath adds a problem if the path in the field does not
+// point into the snap directory
+func (v *packageYamlValidator) checkRelativePath(field, p string) {
+	if filepath.IsAbs(p) {
+		v.addProblem(field, "%q must be relative to the snap directory", p)
+		return
+	}
+	clean := filepath.Clean(p)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		v.addProblem(field, "%q points outside of the snap directory", p)
+	}
+}
+
+// checkOneOf adds a problem if the value of the field is set and not
+// one of the given values
+func (v *packageYamlValidator) checkOneOf(field, value string, valid []string) {
+	if value == "" {
+		return
+	}
+	for _, s := range valid {
+		if value == s {
+			return
+		}
+	}
+	v.addProblem(field, "%q is not one of %s", value, strings.Join(valid, ", "))
+}
+
+// checkSingleLine adds a problem if the value of the field would span
+// more than one line of a generated file
+func (v *packageYamlValidator) checkSingleLine(field, value string) bool {
+	if strings.ContainsAny(value, "\n\r") {
+		v.addProblem(field, "must not contain newlines")
+		return false
+	}
+
+	return true
+}
+
+// checkUnique adds a problem if the name was already seen
+func (v *packageYamlValidator) checkUnique(field, name string, seen map[string]bool) {
+	if seen[name] {
+		v.addProblem(field, "duplicate name %q", name)
+	}
+	seen[name] = true
+}
+
+// checkPorts adds a problem for each port that can not be parsed and for
+// external tags that are already used by another service
+func (v *packageYamlValidator) checkPorts(field string, ports *Ports, seenTags map[string]bool) {
+	if ports == nil {
+		return
+	}
+
+	for _, tag := range sortedPortTags(ports.Internal) {
+		if port := ports.Internal[tag].Port; port != "" {
+			if _, _, err := parsePort(port); err != nil {
+				v.addProblem(field+".internal."+tag+".port", "%s", err)
+			}
+		}
+	}
+	for _, tag := range sortedPortTags(ports.External) {
+		// the ports are passed to the config hook by tag
+		v.checkUnique(field+".external."+tag, tag, seenTags)
+		if port := ports.External[tag].Port; port != "" {
+			if _, _, err := parsePort(port); err != nil {
+				v.addProblem(field+".external."+tag+".port", "%s", err)
+			}
+		}
+	}
+}
+
+// checkSecurityPaths adds a problem for each of the apparmor and seccomp
+// files that is not in the snap
+func (v *packageYamlValidator) checkSecurityPaths(field, apparmor, seccomp string) {
+	if apparmor == "" && seccomp == "" {
+		v.addProblem(field, "needs apparmor or seccomp")
+		return
+	}
+	if apparmor != "" {
+		v.checkRelativePath(field+".apparmor", apparmor)
+	}
+	if seccomp != "" {
+		v.checkRelativePath(field+".seccomp", seccomp)
+	}
+}
+
+// validateSecurity checks the security fields of a service or binary
+func (v *packageYamlValidator) validateSecurity(field string, s SecurityDefinitions) {
+	if s.SecurityTemplate != "" {
+		v.checkPattern(field+".security-template", s.SecurityTemplate, validSecurityName)
+	}
+	seen := make(map[string]bool)
+	for i, cap := range s.SecurityCaps {
+		capField := fmt.Sprintf("%s.caps[%d]", field, i)
+		v.checkPattern(capField, cap, validSecurityName)
+		v.checkUnique(capField, cap, seen)
+	}
+	if s.SecurityOverride != nil {
+		v.checkSecurityPaths(field+".security-override", s.SecurityOverride.Apparmor, s.SecurityOverride.Seccomp)
+	}
+
+	if s.SecurityPolicy == nil {
+		return
+	}
+	v.checkSecurityPaths(field+".security-policy", s.SecurityPolicy.Apparmor, s.SecurityPolicy.Seccomp)
+	// the hand-crafted policy is used instead of the template based one
+	if s.SecurityTemplate != "" || s.SecurityCaps != nil || s.SecurityOverride != nil {
+		v.addProblem(field+".security-policy", "can not be used together with security-template, caps or security-override")
+	}
+}
+
+func (v *packageYamlValidator) validateServices(services []Service) {
+	seen := make(map[string]bool)
+	seenTags := make(map[string]bool)
+	for i, service := range services {
+		field := fmt.Sprintf("services[%d]", i)
+
+		if v.checkRequired(field+".name", service.Name) {
+			v.checkPattern(field+".name", service.Name, validCommandName)
+			v.checkUnique(field+".name", service.Name, seen)
+		}
+		v.checkSnapCommand(field+".start", service.Start)
+		v.checkSnapCommand(field+".stop", service.Stop)
+		v.checkSnapCommand(field+".poststop", service.PostStop)
+		v.checkSingleLine(field+".description", service.Description)
+
+		if service.StopTimeout != "" {
+			if _, err := parseStopTimeout(service.StopTimeout); err != nil {
+				v.addProblem(field+".stop-timeout", "%s", err)
+			}
+		}
+		v.checkOneOf(field+".restart", service.Restart, validServiceRestarts)
+		v.checkOneOf(field+".type", service.Type, validServiceTypes)
+		v.checkServiceOrder(field+".after", service, service.After, services)
+		v.checkServiceOrder(field+".before", service, service.Before, services)
+
+		for _, k := range sortedEnvironmentNames(service.Environment) {
+			envField := field + ".environment." + k
+			v.checkPattern(envField, k, validEnvName)
+			v.checkSingleLine(envField, service.Environment[k])
+		}
+
+		v.checkPorts(field+".ports", service.Ports, seenTags)
+
+		if service.WorkingDirectory != "" && v.checkSingleLine(field+".working-directory", service.WorkingDirectory) {
+			v.checkRelativePath(field+".working-directory", service.WorkingDirectory)
+		}
+
+		v.validateSecurity(field, service.SecurityDefinitions)
+	}
+}
+
+// checkServiceOrder adds a problem for each name that is not the name of
+// another service of the snap
+func (v *packageYamlValidator) checkServiceOrder(field string, service Service, names []string, services []Service) {
+	for i, name := range names {
+		if name == service.Name {
+			v.addProblem(fmt.Sprintf("%s[%d]", field, i), "service can not be ordered against itself")
+			continue
+		}
+
+		found := false
+		for _, other := range services {
+			if other.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.addProblem(fmt.Sprintf("%s[%d]", field, i), "unknown service %q", name)
+		}
+	}
+}
+
+func (v *packageYamlValidator) validateBinaries(binaries []Binary) {
+	seen := make(map[string]bool)
+	for i, binary := range binaries {
+		field := fmt.Sprintf("binaries[%d]", i)
+
+		if !v.checkRequired(field+".name", binary.Name) {
+			continue
+		}
+
+		// the name may point to the binary in the snap, the
+		// command that the user calls is its base name
+		name := filepath.Base(binary.Name)
+		v.checkPattern(field+".name", name, validCommandName)
+		v.checkUnique(field+".name", name, seen)
+
+		if binary.Exec != "" {
+			v.checkSnapCommand(field+".exec", binary.Exec)
+		} else {
+			v.checkSnapCommand(field+".name", binary.Name)
+		}
+
+		v.validateSecurity(field, binary.SecurityDefinitions)
+	}
+}
+
+// validate returns a ErrInvalidPackageYaml with all the problems of the
+// package.yaml or nil if it follows the rules
+func (m *packageYaml) validate() error {
+	var v packageYamlValidator
+
+	if v.checkRequired("name", m.Name) {
+		v.checkPattern("name", m.Name, validSnapName)
+	}
+	if v.checkRequired("version", m.Version) {
+		v.checkPattern("version", m.Version, validSnapVersion)
+	}
+	v.checkRequired("vendor", m.Vendor)
+
+	v.validateServices(m.Services)
+	v.validateBinaries(m.Binaries)
+
+	if len(v.problems) > 0 {
+		return &ErrInvalidPackageYaml{problems: v.problems}
+	}
+
+	return nil
+}
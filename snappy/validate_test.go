@@ -0,0 +1,213 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *SnapTestSuite) validatePackageYaml(c *C, yaml string) error {
+	m, err := parsePackageYamlData([]byte(yaml))
+	c.Assert(err, IsNil)
+
+	return m.validate()
+}
+
+func (s *SnapTestSuite) TestValidatePackageYamlValid(c *C) {
+	err := s.validatePackageYaml(c, `name: foo.bar-2
+version: 1.0+git~1-2
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: svc1
+   start: bin/foo --daemon
+   stop: ./bin/foo-stop
+ - name: svc2
+   start: bin/bar
+binaries:
+ - name: bin/foo
+ - name: bar
+   exec: bin/bar
+`)
+	c.Assert(err, IsNil)
+}
+
+func (s *SnapTestSuite) TestValidatePackageYamlRequired(c *C) {
+	err := s.validatePackageYaml(c, "icon: foo.svg\n")
+	c.Assert(err, FitsTypeOf, &ErrInvalidPackageYaml{})
+	c.Assert(err.(*ErrInvalidPackageYaml).problems, DeepEquals, []string{
+		"name: is required",
+		"version: is required",
+		"vendor: is required",
+	})
+}
+
+func (s *SnapTestSuite) TestValidatePackageYamlNameAndVersion(c *C) {
+	err := s.validatePackageYaml(c, "name: Foo_bar\nversion: 1.0 beta\nvendor: foo\n")
+	c.Assert(err, FitsTypeOf, &ErrInvalidPackageYaml{})
+	c.Assert(err.(*ErrInvalidPackageYaml).problems, DeepEquals, []string{
+		`name: "Foo_bar" does not match ^[a-z0-9][a-z0-9+.-]*$`,
+		`version: "1.0 beta" does not match ^[a-zA-Z0-9.+~-]+$`,
+	})
+
+	c.Assert(s.validatePackageYaml(c, "name: -foo\nversion: 1.0\nvendor: foo\n"), NotNil)
+}
+
+func (s *SnapTestSuite) TestValidatePackageYamlServicesAndBinaries(c *C) {
+	err := s.validatePackageYaml(c, `name: foo
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: svc1
+   start: ../../bar/bin/evil
+ - name: svc1
+   start: /bin/sh
+   poststop: bin/../../x
+ - start: bin/foo
+ - name: svc/2
+   start: bin/foo
+binaries:
+ - name: bin/foo
+ - name: foo
+   exec: bin/../../../usr/bin/foo
+ - name: ../escape
+ - name: bin/foo_bar
+`)
+	c.Assert(err, FitsTypeOf, &ErrInvalidPackageYaml{})
+	c.Assert(err.(*ErrInvalidPackageYaml).problems, DeepEquals, []string{
+		`services[0].start: "../../bar/bin/evil" points outside of the snap directory`,
+		`services[1].name: duplicate name "svc1"`,
+		`services[1].start: "/bin/sh" must be relative to the snap directory`,
+		`services[1].poststop: "bin/../../x" points outside of the snap directory`,
+		`services[2].name: is required`,
+		`services[3].name: "svc/2" does not match ^[a-zA-Z0-9+.-]+$`,
+		`binaries[1].name: duplicate name "foo"`,
+		`binaries[1].exec: "bin/../../../usr/bin/foo" points outside of the snap directory`,
+		`binaries[2].name: "../escape" points outside of the snap directory`,
+		`binaries[3].name: "foo_bar" does not match ^[a-zA-Z0-9+.-]+$`,
+	})
+	c.Assert(err, ErrorMatches, `invalid package.yaml: services\[0\].start: .*; binaries\[3\].name: .*`)
+}
+
+func (s *SnapTestSuite) TestValidatePackageYamlServiceOptions(c *C) {
+	c.Assert(s.validatePackageYaml(c, `name: foo
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: db
+   start: bin/db
+   type: forking
+   restart: always
+   stop-timeout: 1m
+   before: [web]
+ - name: web
+   start: bin/web
+   after: [db]
+   environment:
+     PORT: 80
+   working-directory: www/html
+`), IsNil)
+
+	err := s.validatePackageYaml(c, `name: foo
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: web
+   start: bin/web
+   stop-timeout: soon
+   type: daemon
+   restart: sometimes
+   after: [web, db]
+   environment:
+     1PORT: 80
+     EVIL: "x\nExecStartPre=/bin/sh"
+   working-directory: www/../..
+`)
+	c.Assert(err, FitsTypeOf, &ErrInvalidPackageYaml{})
+	c.Assert(err.(*ErrInvalidPackageYaml).problems, DeepEquals, []string{
+		`services[0].stop-timeout: invalid timeout "soon"`,
+		`services[0].restart: "sometimes" is not one of no, on-success, on-failure, on-abnormal, on-watchdog, on-abort, always`,
+		`services[0].type: "daemon" is not one of simple, forking, oneshot, notify`,
+		`services[0].after[0]: service can not be ordered against itself`,
+		`services[0].after[1]: unknown service "db"`,
+		`services[0].environment.1PORT: "1PORT" does not match ^[a-zA-Z_][a-zA-Z0-9_]*$`,
+		`services[0].environment.EVIL: must not contain newlines`,
+		`services[0].working-directory: "www/../.." points outside of the snap directory`,
+	})
+}
+
+func (s *SnapTestSuite) TestValidatePackageYamlMultiLineCommands(c *C) {
+	err := s.validatePackageYaml(c, `name: foo
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: svc
+   description: "svc\nExecStartPre=/bin/sh"
+   start: "bin/svc\nExecStartPre=/bin/sh"
+   stop: "bin/svc --stop\r"
+   poststop: bin/svc-cleanup
+binaries:
+ - name: foo
+   exec: "bin/foo\n/bin/sh"
+ - name: "bin\n/bar"
+`)
+	c.Assert(err, FitsTypeOf, &ErrInvalidPackageYaml{})
+	c.Assert(err.(*ErrInvalidPackageYaml).problems, DeepEquals, []string{
+		`services[0].start: must not contain newlines`,
+		`services[0].stop: must not contain newlines`,
+		`services[0].description: must not contain newlines`,
+		`binaries[0].exec: must not contain newlines`,
+		`binaries[1].name: must not contain newlines`,
+	})
+}
+
+func (s *SnapTestSuite) TestBuildInvalidPackageYaml(c *C) {
+	sourceDir := makeExampleSnapSourceDir(c, "name: foo\nversion: 1.0\n")
+
+	_, err := Build(sourceDir, c.MkDir())
+	c.Assert(err, FitsTypeOf, &ErrInvalidPackageYaml{})
+	c.Assert(err, ErrorMatches, "invalid package.yaml: vendor: is required")
+}
+
+func (s *SnapTestSuite) TestValidatePackageYamlSecurity(c *C) {
+	err := s.validatePackageYaml(c, `name: foo
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: svc
+   start: bin/foo
+   security-template: ../unconfined
+   caps:
+    - network-client
+    - network-client
+binaries:
+ - name: bin/foo
+   security-override: {}
+ - name: bin/bar
+   caps: []
+   security-policy:
+     apparmor: /etc/apparmor.d/foo
+`)
+	c.Assert(err, FitsTypeOf, &ErrInvalidPackageYaml{})
+	c.Assert(err.(*ErrInvalidPackageYaml).problems, DeepEquals, []string{
+		`services[0].security-template: "../unconfined" does not match ^[a-zA-Z0-9][a-zA-Z0-9_.+-]*$`,
+		`services[0].caps[1]: duplicate name "network-client"`,
+		`binaries[0].security-override: needs apparmor or seccomp`,
+		`binaries[1].security-policy.apparmor: "/etc/apparmor.d/foo" must be relative to the snap directory`,
+		`binaries[1].security-policy: can not be used together with security-template, caps or security-override`,
+	})
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"launchpad.net/snappy/helpers"
+
+	"gopkg.in/yaml.v2"
+)
+
+// VerifyProblem describes how an installed file differs from the file
+// in the snap
+type VerifyProblem string
+
+// the problems that the verification can find
+const (
+	VerifyModified  VerifyProblem = "modified"
+	VerifyMissing   VerifyProblem = "missing"
+	VerifyExtra     VerifyProblem = "extra"
+	VerifyWrongMode VerifyProblem = "wrong-mode"
+	// the sha512 of the hashes.yaml was not recorded on install so
+	// the hashes can not be trusted, this is only a warning as the
+	// snaps installed before snappy recorded it have none
+	VerifyUnrecorded VerifyProblem = "unrecorded"
+)
+
+// VerifyIssue is a single file that does not match its entry in the
+// hashes.yaml of the snap
+type VerifyIssue struct {
+	File     string        `yaml:"file"`
+	Problem  VerifyProblem `yaml:"problem"`
+	Expected string        `yaml:"expected,omitempty"`
+	Got      string        `yaml:"got,omitempty"`
+}
+
+// VerifyReport is the result of the verification of a installed snap
+type VerifyReport struct {
+	Name    string        `yaml:"name"`
+	Version string        `yaml:"version"`
+	Issues  []VerifyIssue `yaml:"issues,omitempty"`
+}
+
+// OK returns true if no issues other than warnings were found
+func (r *VerifyReport) OK() bool {
+	for _, issue := range r.Issues {
+		if issue.Problem != VerifyUnrecorded {
+			return false
+		}
+	}
+
+	return true
+}
+
+// files that snappy adds to the snap dir on install, they are not part
+// of the hashes.yaml; the hashes.yaml itself is checked against the
+// sha512 that was recorded on install
+func verifyIgnored(name string) bool {
+	return name == ".click" ||
+		strings.HasPrefix(name, ".click/") ||
+		name == filepath.Join("meta", "hashes.yaml")
+}
+
+// formatFileMode returns the file mode in the format that is used in
+// the hashes.yaml
+func formatFileMode(mode os.FileMode) string {
+	s, err := newYamlFileMode(mode).MarshalYAML()
+	if err != nil {
+		return mode.String()
+	}
+
+	return s.(string)
+}
+
+// verifyFile compares the file at path with the given hashes.yaml entry
+func verifyFile(path string, fh *fileHash) (*VerifyIssue, error) {
+	st, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return &VerifyIssue{File: fh.Name, Problem: VerifyMissing}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// only the type and the permission bits are recorded
+	mode := st.Mode() & (os.ModeType | os.ModePerm)
+	if fh.Mode != nil && mode != fh.Mode.mode {
+		return &VerifyIssue{
+			File:     fh.Name,
+			Problem:  VerifyWrongMode,
+			Expected: formatFileMode(fh.Mode.mode),
+			Got:      formatFileMode(mode),
+		}, nil
+	}
+
+	if !mode.IsRegular() {
+		return nil, nil
+	}
+
+	if fh.Size != nil && *fh.Size != st.Size() {
+		return &VerifyIssue{File: fh.Name, Problem: VerifyModified}, nil
+	}
+	sha512, err := helpers.Sha512sum(path)
+	if err != nil {
+		return nil, err
+	}
+	if sha512 != fh.Sha512 {
+		return &VerifyIssue{File: fh.Name, Problem: VerifyModified}, nil
+	}
+
+	return nil, nil
+}
+
+// verifyHashesFile compares the hashes.yaml of the given snap with the
+// sha512 that was recorded when it was installed, the files of the
+// snap can only be trusted if it matches
+func verifyHashesFile(hashesData []byte, name, version string) (*VerifyIssue, error) {
+	hashesFile := filepath.Join("meta", "hashes.yaml")
+
+	recorded, err := ioutil.ReadFile(hashesSha512File(name, version))
+	if os.IsNotExist(err) {
+		return &VerifyIssue{File: hashesFile, Problem: VerifyUnrecorded}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha512.Sum512(hashesData)
+	expected := strings.TrimSpace(string(recorded))
+	if got := hex.EncodeToString(sum[:]); got != expected {
+		return &VerifyIssue{
+			File:     hashesFile,
+			Problem:  VerifyModified,
+			Expected: expected,
+			Got:      got,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// verifySnapDir checks the files in the given snap dir against the
+// files in the given hashes
+func verifySnapDir(dir string, hashes *hashesYaml) (issues []VerifyIssue, err error) {
+	known := make(map[string]bool, len(hashes.Files))
+	for i := range hashes.Files {
+		fh := &hashes.Files[i]
+		known[fh.Name] = true
+
+		issue, err := verifyFile(filepath.Join(dir, fh.Name), fh)
+		if err != nil {
+			return nil, err
+		}
+		if issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+
+		name := path[len(dir)+1:]
+		if known[name] || verifyIgnored(name) {
+			return nil
+		}
+
+		issues = append(issues, VerifyIssue{File: name, Problem: VerifyExtra})
+		// everything below a extra dir is extra too
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return issues, nil
+}
+
+// Verify checks the installed files of the snap against the hashes.yaml
+// that came with it
+func (s *SnapPart) Verify() (*VerifyReport, error) {
+	hashesData, err := ioutil.ReadFile(filepath.Join(s.basedir, "meta", "hashes.yaml"))
+	if os.IsNotExist(err) {
+		return nil, ErrNoHashes
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	issue, err := verifyHashesFile(hashesData, s.Name(), s.Version())
+	if err != nil {
+		return nil, err
+	}
+
+	var h hashesYaml
+	if err := yaml.Unmarshal(hashesData, &h); err != nil {
+		return nil, err
+	}
+
+	issues, err := verifySnapDir(s.basedir, &h)
+	if err != nil {
+		return nil, err
+	}
+	if issue != nil {
+		issues = append([]VerifyIssue{*issue}, issues...)
+	}
+
+	return &VerifyReport{
+		Name:    s.Name(),
+		Version: s.Version(),
+		Issues:  issues,
+	}, nil
+}
+
+// Verify checks all installed versions of the snap with the given name,
+// or of all installed snaps if the name is empty, against the hashes
+// that came with them
+func Verify(name string) (reports []*VerifyReport, err error) {
+	for _, dir := range []string{snapAppsDir, snapOemDir} {
+		repo := NewLocalSnapRepository(dir)
+		if repo == nil {
+			continue
+		}
+
+		installed, err := repo.Installed()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, part := range installed {
+			snap, ok := part.(*SnapPart)
+			if !ok || (name != "" && snap.Name() != name) {
+				continue
+			}
+
+			report, err := snap.Verify()
+			if err != nil {
+				return nil, fmt.Errorf("can not verify %s %s: %s", snap.Name(), snap.Version(), err)
+			}
+			reports = append(reports, report)
+		}
+	}
+
+	if name != "" && len(reports) == 0 {
+		return nil, ErrPackageNotFound
+	}
+
+	return reports, nil
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"launchpad.net/snappy/helpers"
+
+	"gopkg.in/yaml.v2"
+
+	. "launchpad.net/gocheck"
+)
+
+// installTestSnapForVerify installs the test snap and returns its dir
+func installTestSnapForVerify(c *C) string {
+	snapFile := makeTestSnapPackage(c, "")
+	c.Assert(installClick(snapFile, 0, nil), IsNil)
+
+	return filepath.Join(snapAppsDir, "foo", "1.0")
+}
+
+func (s *SnapTestSuite) TestVerifyUnmodified(c *C) {
+	installTestSnapForVerify(c)
+
+	reports, err := Verify("foo")
+	c.Assert(err, IsNil)
+	c.Assert(reports, HasLen, 1)
+	c.Assert(reports[0].Name, Equals, "foo")
+	c.Assert(reports[0].Version, Equals, "1.0")
+	c.Assert(reports[0].OK(), Equals, true)
+}
+
+func (s *SnapTestSuite) TestVerifyModified(c *C) {
+	dir := installTestSnapForVerify(c)
+
+	// same size, different content
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "bin", "foo"), []byte("#!/bin/sh\necho \"HELLO\""), 0755), IsNil)
+
+	reports, err := Verify("foo")
+	c.Assert(err, IsNil)
+	c.Assert(reports[0].Issues, DeepEquals, []VerifyIssue{
+		{File: "bin/foo", Problem: VerifyModified},
+	})
+}
+
+func (s *SnapTestSuite) TestVerifyMissingAndExtra(c *C) {
+	dir := installTestSnapForVerify(c)
+
+	c.Assert(os.Remove(filepath.Join(dir, "bin", "foo")), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "extra-dir", "sub"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "meta", "extra"), nil, 0644), IsNil)
+
+	reports, err := Verify("")
+	c.Assert(err, IsNil)
+	c.Assert(reports, HasLen, 1)
+	c.Assert(reports[0].Issues, DeepEquals, []VerifyIssue{
+		{File: "bin/foo", Problem: VerifyMissing},
+		{File: "extra-dir", Problem: VerifyExtra},
+		{File: "meta/extra", Problem: VerifyExtra},
+	})
+}
+
+func (s *SnapTestSuite) TestVerifyWrongMode(c *C) {
+	dir := installTestSnapForVerify(c)
+
+	binFoo := filepath.Join(dir, "bin", "foo")
+	st, err := os.Stat(binFoo)
+	c.Assert(err, IsNil)
+	c.Assert(os.Chmod(binFoo, 0600), IsNil)
+
+	reports, err := Verify("foo")
+	c.Assert(err, IsNil)
+	c.Assert(reports[0].Issues, DeepEquals, []VerifyIssue{
+		{
+			File:     "bin/foo",
+			Problem:  VerifyWrongMode,
+			Expected: formatFileMode(st.Mode()),
+			Got:      "frw-------",
+		},
+	})
+}
+
+func (s *SnapTestSuite) TestVerifyModifiedHashes(c *C) {
+	dir := installTestSnapForVerify(c)
+
+	// the modified file is hidden by a matching hashes.yaml
+	binFoo := filepath.Join(dir, "bin", "foo")
+	c.Assert(ioutil.WriteFile(binFoo, []byte("#!/bin/sh\necho \"HELLO\""), 0755), IsNil)
+	hashesFile := filepath.Join(dir, "meta", "hashes.yaml")
+	hashes, err := ioutil.ReadFile(hashesFile)
+	c.Assert(err, IsNil)
+	sha512, err := helpers.Sha512sum(binFoo)
+	c.Assert(err, IsNil)
+	var h hashesYaml
+	c.Assert(yaml.Unmarshal(hashes, &h), IsNil)
+	for i := range h.Files {
+		if h.Files[i].Name == "bin/foo" {
+			h.Files[i].Sha512 = sha512
+		}
+	}
+	modified, err := yaml.Marshal(&h)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(hashesFile, modified, 0644), IsNil)
+
+	reports, err := Verify("foo")
+	c.Assert(err, IsNil)
+	c.Assert(reports[0].Issues, HasLen, 1)
+	c.Assert(reports[0].Issues[0].File, Equals, "meta/hashes.yaml")
+	c.Assert(reports[0].Issues[0].Problem, Equals, VerifyModified)
+}
+
+func (s *SnapTestSuite) TestVerifyUnrecordedHashes(c *C) {
+	dir := installTestSnapForVerify(c)
+	c.Assert(os.Remove(hashesSha512File("foo", "1.0")), IsNil)
+
+	reports, err := Verify("foo")
+	c.Assert(err, IsNil)
+	c.Assert(reports[0].Issues, DeepEquals, []VerifyIssue{
+		{File: "meta/hashes.yaml", Problem: VerifyUnrecorded},
+	})
+	// snaps from before the hashes were recorded still pass
+	c.Assert(reports[0].OK(), Equals, true)
+
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "bin", "foo"), []byte("changed"), 0755), IsNil)
+	reports, err = Verify("foo")
+	c.Assert(err, IsNil)
+	c.Assert(reports[0].OK(), Equals, false)
+}
+
+func (s *SnapTestSuite) TestVerifyNoHashes(c *C) {
+	dir := installTestSnapForVerify(c)
+	c.Assert(os.Remove(filepath.Join(dir, "meta", "hashes.yaml")), IsNil)
+
+	_, err := Verify("foo")
+	c.Assert(err, ErrorMatches, "can not verify foo 1.0: snap has no meta/hashes.yaml")
+}
+
+func (s *SnapTestSuite) TestVerifyNotInstalled(c *C) {
+	installTestSnapForVerify(c)
+
+	_, err := Verify("bar")
+	c.Assert(err, Equals, ErrPackageNotFound)
+}